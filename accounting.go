@@ -0,0 +1,324 @@
+package libradius
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	"layeh.com/radius"
+	"layeh.com/radius/rfc2865"
+	"layeh.com/radius/rfc2866"
+	"layeh.com/radius/rfc2869"
+)
+
+// AcctTerminateCause is the name of an Acct-Terminate-Cause value of RFC 2866.
+type AcctTerminateCause string
+
+const (
+	AcctTerminateCauseUserRequest        AcctTerminateCause = "User-Request"
+	AcctTerminateCauseLostCarrier        AcctTerminateCause = "Lost-Carrier"
+	AcctTerminateCauseLostService        AcctTerminateCause = "Lost-Service"
+	AcctTerminateCauseIdleTimeout        AcctTerminateCause = "Idle-Timeout"
+	AcctTerminateCauseSessionTimeout     AcctTerminateCause = RadiusCauseSession
+	AcctTerminateCauseAdminReset         AcctTerminateCause = RadiusCauseAdmin
+	AcctTerminateCauseAdminReboot        AcctTerminateCause = "Admin-Reboot"
+	AcctTerminateCausePortError          AcctTerminateCause = "Port-Error"
+	AcctTerminateCauseNASError           AcctTerminateCause = "NAS-Error"
+	AcctTerminateCauseNASRequest         AcctTerminateCause = "NAS-Request"
+	AcctTerminateCauseNASReboot          AcctTerminateCause = "NAS-Reboot"
+	AcctTerminateCausePortUnneeded       AcctTerminateCause = "Port-Unneeded"
+	AcctTerminateCausePortPreempted      AcctTerminateCause = "Port-Preempted"
+	AcctTerminateCausePortSuspended      AcctTerminateCause = "Port-Suspended"
+	AcctTerminateCauseServiceUnavailable AcctTerminateCause = "Service-Unavailable"
+	AcctTerminateCauseCallback           AcctTerminateCause = "Callback"
+	AcctTerminateCauseUserError          AcctTerminateCause = "User-Error"
+	AcctTerminateCauseHostRequest        AcctTerminateCause = RadiusCauseStop
+)
+
+type AccountingRecord struct {
+	StatusType     string
+	SessionID      string
+	MultiSessionID string
+
+	UserName         string
+	NASIPAddress     net.IP
+	NASIdentifier    string
+	NASPort          uint32
+	NASPortType      string
+	CallingStationID string
+	CalledStationID  string
+	FramedIPAddress  net.IP
+	Class            []byte
+
+	SessionTime     time.Duration
+	DelayTime       time.Duration
+	InterimInterval time.Duration
+	EventTimestamp  time.Time
+
+	InputOctets   uint64
+	OutputOctets  uint64
+	InputPackets  uint64
+	OutputPackets uint64
+
+	TerminateCause AcctTerminateCause
+
+	Wimark   WimarkAVPs
+	Cisco    CiscoAVPs
+	Airspace AirspaceAVPs
+}
+
+func DecodeAccountingRecord(p *radius.Packet) (*AccountingRecord, error) {
+	if p.Code != radius.CodeAccountingRequest {
+		return nil, fmt.Errorf("unexpected packet code: %s (%d)", p.Code, p.Code)
+	}
+
+	statusType, err := rfc2866.AcctStatusType_Lookup(p)
+	if err != nil {
+		return nil, fmt.Errorf("attribute Acct-Status-Type not found")
+	}
+
+	record := AccountingRecord{
+		StatusType:       statusType.String(),
+		SessionID:        rfc2866.AcctSessionID_GetString(p),
+		MultiSessionID:   rfc2866.AcctMultiSessionID_GetString(p),
+		UserName:         rfc2865.UserName_GetString(p),
+		NASIdentifier:    rfc2865.NASIdentifier_GetString(p),
+		CallingStationID: rfc2865.CallingStationID_GetString(p),
+		CalledStationID:  rfc2865.CalledStationID_GetString(p),
+		Class:            rfc2865.Class_Get(p),
+		NASPort:          uint32(rfc2865.NASPort_Get(p)),
+	}
+
+	if v, err := rfc2865.NASIPAddress_Lookup(p); err == nil {
+		record.NASIPAddress = v
+	}
+	if v, err := rfc2865.FramedIPAddress_Lookup(p); err == nil {
+		record.FramedIPAddress = v
+	}
+	if v, err := rfc2865.NASPortType_Lookup(p); err == nil {
+		record.NASPortType = nasPortTypeName(v)
+	}
+
+	record.SessionTime = time.Duration(rfc2866.AcctSessionTime_Get(p)) * time.Second
+	record.DelayTime = time.Duration(rfc2866.AcctDelayTime_Get(p)) * time.Second
+	record.InterimInterval = time.Duration(rfc2869.AcctInterimInterval_Get(p)) * time.Second
+	if v, err := rfc2869.EventTimestamp_Lookup(p); err == nil {
+		record.EventTimestamp = v
+	}
+
+	record.InputOctets = uint64(rfc2869.AcctInputGigawords_Get(p))<<32 | uint64(rfc2866.AcctInputOctets_Get(p))
+	record.OutputOctets = uint64(rfc2869.AcctOutputGigawords_Get(p))<<32 | uint64(rfc2866.AcctOutputOctets_Get(p))
+	record.InputPackets = uint64(rfc2866.AcctInputPackets_Get(p))
+	record.OutputPackets = uint64(rfc2866.AcctOutputPackets_Get(p))
+
+	if v, err := rfc2866.AcctTerminateCause_Lookup(p); err == nil {
+		record.TerminateCause = AcctTerminateCause(v.String())
+	}
+
+	// vendor attributes are optional in accounting, so a missing vendor
+	// is not an error here
+	if avps, err := DecodeWimarkAVPairsStruct(p); err == nil {
+		record.Wimark = avps
+	}
+	if avps, err := DecodeCiscoAVPairsStruct(p); err == nil {
+		record.Cisco = avps
+	}
+	if avps, err := DecodeAirspaceAVPairsStruct(p); err == nil {
+		record.Airspace = avps
+	}
+
+	return &record, nil
+}
+
+// StatusTime returns when the reported event happened: the Event-Timestamp
+// if present, otherwise the receive time corrected by Acct-Delay-Time.
+func (r *AccountingRecord) StatusTime(received time.Time) time.Time {
+	if !r.EventTimestamp.IsZero() {
+		return r.EventTimestamp
+	}
+	return received.Add(-r.DelayTime)
+}
+
+func EncodeAccountingRecord(record *AccountingRecord, secret []byte) (*radius.Packet, error) {
+	statusType, ok := lookupAcctStatusType(record.StatusType)
+	if !ok {
+		return nil, fmt.Errorf("unsupported Acct-Status-Type: %s", record.StatusType)
+	}
+
+	p := radius.New(radius.CodeAccountingRequest, secret)
+	rfc2866.AcctStatusType_Add(p, statusType)
+
+	if len(record.SessionID) > 0 {
+		rfc2866.AcctSessionID_AddString(p, record.SessionID)
+	}
+	if len(record.MultiSessionID) > 0 {
+		rfc2866.AcctMultiSessionID_AddString(p, record.MultiSessionID)
+	}
+	if len(record.UserName) > 0 {
+		rfc2865.UserName_AddString(p, record.UserName)
+	}
+	if record.NASIPAddress != nil {
+		if err := rfc2865.NASIPAddress_Add(p, record.NASIPAddress); err != nil {
+			return nil, err
+		}
+	}
+	if len(record.NASIdentifier) > 0 {
+		rfc2865.NASIdentifier_AddString(p, record.NASIdentifier)
+	}
+	if record.NASPort > 0 {
+		rfc2865.NASPort_Add(p, rfc2865.NASPort(record.NASPort))
+	}
+	if len(record.NASPortType) > 0 {
+		portType, ok := lookupNASPortType(record.NASPortType)
+		if !ok {
+			return nil, fmt.Errorf("unsupported NAS-Port-Type: %s", record.NASPortType)
+		}
+		rfc2865.NASPortType_Add(p, portType)
+	}
+	if len(record.CallingStationID) > 0 {
+		rfc2865.CallingStationID_AddString(p, record.CallingStationID)
+	}
+	if len(record.CalledStationID) > 0 {
+		rfc2865.CalledStationID_AddString(p, record.CalledStationID)
+	}
+	if record.FramedIPAddress != nil {
+		if err := rfc2865.FramedIPAddress_Add(p, record.FramedIPAddress); err != nil {
+			return nil, err
+		}
+	}
+	if len(record.Class) > 0 {
+		rfc2865.Class_Add(p, record.Class)
+	}
+
+	if record.SessionTime > 0 {
+		rfc2866.AcctSessionTime_Add(p, rfc2866.AcctSessionTime(record.SessionTime/time.Second))
+	}
+	rfc2866.AcctDelayTime_Add(p, rfc2866.AcctDelayTime(record.DelayTime/time.Second))
+	if record.InterimInterval > 0 {
+		rfc2869.AcctInterimInterval_Add(p, rfc2869.AcctInterimInterval(record.InterimInterval/time.Second))
+	}
+	if !record.EventTimestamp.IsZero() {
+		rfc2869.EventTimestamp_Add(p, record.EventTimestamp)
+	}
+
+	if record.StatusType != RadiusStart {
+		rfc2866.AcctInputOctets_Add(p, rfc2866.AcctInputOctets(record.InputOctets))
+		rfc2866.AcctOutputOctets_Add(p, rfc2866.AcctOutputOctets(record.OutputOctets))
+		if record.InputOctets>>32 > 0 {
+			rfc2869.AcctInputGigawords_Add(p, rfc2869.AcctInputGigawords(record.InputOctets>>32))
+		}
+		if record.OutputOctets>>32 > 0 {
+			rfc2869.AcctOutputGigawords_Add(p, rfc2869.AcctOutputGigawords(record.OutputOctets>>32))
+		}
+		rfc2866.AcctInputPackets_Add(p, rfc2866.AcctInputPackets(record.InputPackets))
+		rfc2866.AcctOutputPackets_Add(p, rfc2866.AcctOutputPackets(record.OutputPackets))
+	}
+
+	if len(record.TerminateCause) > 0 {
+		cause, ok := lookupAcctTerminateCause(record.TerminateCause)
+		if !ok {
+			return nil, fmt.Errorf("unsupported Acct-Terminate-Cause: %s", record.TerminateCause)
+		}
+		rfc2866.AcctTerminateCause_Add(p, cause)
+	}
+
+	if len(record.Wimark.ClientStr) > 0 {
+		AddVSAString(p, VendorWimark, uint8(WimarkAVPTypeClientStr), record.Wimark.ClientStr)
+	}
+	if record.Wimark.SessionInt > 0 {
+		AddVSAInt(p, VendorWimark, uint8(WimarkAVPTypeSessionInt), record.Wimark.SessionInt)
+	}
+	if len(record.Wimark.WLANID) > 0 {
+		AddVSAString(p, VendorWimark, uint8(WimarkIdentifierWLANType), record.Wimark.WLANID)
+	}
+	if len(record.Wimark.CPEID) > 0 {
+		AddVSAString(p, VendorWimark, uint8(WimarkAuthCPEIDType), record.Wimark.CPEID)
+	}
+	if len(record.Cisco.AccountInfo) > 0 {
+		AddVSAString(p, VendorCisco, uint8(CiscoAVPTypeAccountInfo), record.Cisco.AccountInfo)
+	}
+	for _, avp := range record.Cisco.AVPList {
+		AddVSAString(p, VendorCisco, uint8(CiscoAVPTypeDefault), avp)
+	}
+	if len(record.Airspace.ACLName) > 0 {
+		AddVSAString(p, VendorAirspace, uint8(AirspaceAVPTypeACLName), record.Airspace.ACLName)
+	}
+
+	return p, nil
+}
+
+// SendAccounting sends the record as an Accounting-Request and waits for an
+// authentic Accounting-Response. Acct-Delay-Time grows with every
+// retransmission, which therefore goes out with a new Identifier.
+func SendAccounting(ctx context.Context, addr, secret string, record AccountingRecord) error {
+	packet, err := EncodeAccountingRecord(&record, []byte(secret))
+	if err != nil {
+		return err
+	}
+
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, defaultSendRadiusPacketTimeout)
+		defer cancel()
+	}
+
+	response, err := exchange(ctx, radius.DefaultClient, packet, addr, func(p *radius.Packet, elapsed time.Duration) {
+		delay := (record.DelayTime + elapsed) / time.Second
+		rfc2866.AcctDelayTime_Set(p, rfc2866.AcctDelayTime(delay))
+	})
+	if err != nil {
+		return err
+	}
+
+	if response.Code != radius.CodeAccountingResponse {
+		return fmt.Errorf("unexpected accounting response: %s (%d)", response.Code, response.Code)
+	}
+
+	return nil
+}
+
+func lookupAcctStatusType(name string) (rfc2866.AcctStatusType, bool) {
+	for value, s := range rfc2866.AcctStatusType_Strings {
+		if s == name {
+			return value, true
+		}
+	}
+	return 0, false
+}
+
+func lookupAcctTerminateCause(cause AcctTerminateCause) (rfc2866.AcctTerminateCause, bool) {
+	for value, s := range rfc2866.AcctTerminateCause_Strings {
+		if s == string(cause) {
+			return value, true
+		}
+	}
+	return 0, false
+}
+
+func lookupNASPortType(name string) (rfc2865.NASPortType, bool) {
+	switch name {
+	case RadiusNASPortTypeWifi:
+		return rfc2865.NASPortType_Value_Wireless80211, true
+	case RadiusNASPortTypeEthernet:
+		return rfc2865.NASPortType_Value_Ethernet, true
+	}
+
+	for value, s := range rfc2865.NASPortType_Strings {
+		if s == name {
+			return value, true
+		}
+	}
+	return 0, false
+}
+
+func nasPortTypeName(t rfc2865.NASPortType) string {
+	switch t {
+	case rfc2865.NASPortType_Value_Wireless80211, rfc2865.NASPortType_Value_WirelessOther:
+		return RadiusNASPortTypeWifi
+	case rfc2865.NASPortType_Value_Ethernet:
+		return RadiusNASPortTypeEthernet
+	default:
+		return t.String()
+	}
+}
@@ -0,0 +1,55 @@
+package libradius
+
+import (
+	"testing"
+
+	"layeh.com/radius/rfc2866"
+)
+
+func TestAcctTerminateCause(t *testing.T) {
+	tests := []struct {
+		cause AcctTerminateCause
+		value rfc2866.AcctTerminateCause
+	}{
+		{AcctTerminateCauseUserRequest, rfc2866.AcctTerminateCause_Value_UserRequest},
+		{AcctTerminateCauseLostCarrier, rfc2866.AcctTerminateCause_Value_LostCarrier},
+		{AcctTerminateCauseLostService, rfc2866.AcctTerminateCause_Value_LostService},
+		{AcctTerminateCauseIdleTimeout, rfc2866.AcctTerminateCause_Value_IdleTimeout},
+		{AcctTerminateCauseSessionTimeout, rfc2866.AcctTerminateCause_Value_SessionTimeout},
+		{AcctTerminateCauseAdminReset, rfc2866.AcctTerminateCause_Value_AdminReset},
+		{AcctTerminateCauseAdminReboot, rfc2866.AcctTerminateCause_Value_AdminReboot},
+		{AcctTerminateCausePortError, rfc2866.AcctTerminateCause_Value_PortError},
+		{AcctTerminateCauseNASError, rfc2866.AcctTerminateCause_Value_NASError},
+		{AcctTerminateCauseNASRequest, rfc2866.AcctTerminateCause_Value_NASRequest},
+		{AcctTerminateCauseNASReboot, rfc2866.AcctTerminateCause_Value_NASReboot},
+		{AcctTerminateCausePortUnneeded, rfc2866.AcctTerminateCause_Value_PortUnneeded},
+		{AcctTerminateCausePortPreempted, rfc2866.AcctTerminateCause_Value_PortPreempted},
+		{AcctTerminateCausePortSuspended, rfc2866.AcctTerminateCause_Value_PortSuspended},
+		{AcctTerminateCauseServiceUnavailable, rfc2866.AcctTerminateCause_Value_ServiceUnavailable},
+		{AcctTerminateCauseCallback, rfc2866.AcctTerminateCause_Value_Callback},
+		{AcctTerminateCauseUserError, rfc2866.AcctTerminateCause_Value_UserError},
+		{AcctTerminateCauseHostRequest, rfc2866.AcctTerminateCause_Value_HostRequest},
+	}
+	for _, test := range tests {
+		record := &AccountingRecord{
+			StatusType:     RadiusStop,
+			SessionID:      "1",
+			TerminateCause: test.cause,
+		}
+		p, err := EncodeAccountingRecord(record, []byte("testing123"))
+		if err != nil {
+			t.Fatalf("%s: %s", test.cause, err)
+		}
+		if value := rfc2866.AcctTerminateCause_Get(p); value != test.value {
+			t.Errorf("%s: encoded as %d, want %d", test.cause, value, test.value)
+		}
+
+		decoded, err := DecodeAccountingRecord(p)
+		if err != nil {
+			t.Fatalf("%s: %s", test.cause, err)
+		}
+		if decoded.TerminateCause != test.cause {
+			t.Errorf("%d: decoded as %s, want %s", test.value, decoded.TerminateCause, test.cause)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package libradius
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"layeh.com/radius"
+	"layeh.com/radius/dictionary"
+)
+
+// ParseAttribute adds the attribute of a FreeRADIUS line like
+// `User-Name = "bob"` or `Tunnel-Type:1 = VLAN` to the packet, the operators
+// :=, += and == are taken as =. The values are the ones printed by Describe:
+// integers by number or name, octets in hex after 0x, strings quoted or
+// not. Hidden attributes are given in clear and encrypted with the secret
+// and Authenticator of the packet, which must be set before.
+func ParseAttribute(p *radius.Packet, dict *dictionary.Dictionary, line string) error {
+	if dict == nil {
+		dict = DefaultDictionary
+	}
+
+	name, text, ok := splitAttributeLine(line)
+	if !ok {
+		return fmt.Errorf("invalid attribute line: %q", line)
+	}
+
+	a := &jsonAttribute{Name: name}
+	if i := strings.IndexByte(name, ':'); i > 0 {
+		tag, err := strconv.ParseUint(name[i+1:], 10, 8)
+		if err != nil || tag > 0x1F {
+			return fmt.Errorf("invalid tag: %q", name)
+		}
+		a.Name = name[:i]
+		t := uint8(tag)
+		a.Tag = &t
+	}
+
+	attr, vendor := lookupAttribute(dict, a.Name)
+	if attr == nil {
+		return fmt.Errorf("unknown attribute: %q", a.Name)
+	}
+	if vendor != nil {
+		a.Vendor = uint32(vendor.Number)
+	}
+
+	if unquoted, err := strconv.Unquote(text); err == nil {
+		text = unquoted
+		if attr.Type == dictionary.AttributeOctets {
+			text = "0x" + fmt.Sprintf("%x", unquoted)
+		}
+	}
+
+	if attr.FlagEncrypt.Valid {
+		// RFC 2868, section 3.5: the tag of Tunnel-Password is not optional,
+		// 0 when none is given
+		if a.Tag == nil && attr.FlagEncrypt.Int == dictionary.EncryptTunnelPassword {
+			a.Tag = new(uint8)
+		}
+		value, err := encryptAttribute(p, attr, text)
+		if err != nil {
+			return fmt.Errorf("attribute %s: %s", a.Name, err)
+		}
+		a.Raw = fmt.Sprintf("%x", value)
+		return addJSONAttribute(p, dict, a)
+	}
+
+	// numbers are given as is, names of integer values and the other types
+	// as strings
+	a.Value, _ = json.Marshal(text)
+	switch attr.Type {
+	case dictionary.AttributeInteger, dictionary.AttributeInteger64, dictionary.AttributeShort, dictionary.AttributeByte, dictionary.AttributeSigned:
+		if _, err := strconv.ParseInt(text, 10, 64); err == nil {
+			a.Value = json.RawMessage(text)
+		} else if _, err := strconv.ParseUint(text, 10, 64); err == nil {
+			a.Value = json.RawMessage(text)
+		}
+	}
+
+	return addJSONAttribute(p, dict, a)
+}
+
+func splitAttributeLine(line string) (name, value string, ok bool) {
+	i := strings.IndexByte(line, '=')
+	if i < 0 {
+		return "", "", false
+	}
+
+	name = strings.TrimSpace(line[:i])
+	// the operators :=, += and == are taken as =
+	name = strings.TrimSpace(strings.TrimRight(name, ":+="))
+	value = strings.TrimSpace(strings.TrimPrefix(line[i+1:], "="))
+	if len(name) == 0 || strings.ContainsAny(name, " \t") {
+		return "", "", false
+	}
+
+	return name, value, true
+}
+
+// lookupAttribute finds the attribute by name, vendor is nil for the ones
+// outside of Vendor-Specific.
+func lookupAttribute(dict *dictionary.Dictionary, name string) (attr *dictionary.Attribute, vendor *dictionary.Vendor) {
+	if attr = dictionary.AttributeByName(dict.Attributes, name); attr != nil {
+		return attr, nil
+	}
+	for _, vendor = range dict.Vendors {
+		if attr = dictionary.AttributeByName(vendor.Attributes, name); attr != nil {
+			return attr, vendor
+		}
+	}
+	return nil, nil
+}
+
+func encryptAttribute(p *radius.Packet, attr *dictionary.Attribute, text string) (radius.Attribute, error) {
+	if len(p.Secret) == 0 {
+		return nil, fmt.Errorf("the packet has no secret")
+	}
+
+	switch attr.FlagEncrypt.Int {
+	case dictionary.EncryptUserPassword:
+		if len(text) > maxUserPasswordLen {
+			return nil, fmt.Errorf("too long value: %d bytes", len(text))
+		}
+		return radius.NewUserPassword(padUserPassword(text), p.Secret, p.Authenticator[:])
+	case dictionary.EncryptTunnelPassword:
+		var salt [2]byte
+		if _, err := rand.Read(salt[:]); err != nil {
+			return nil, err
+		}
+		// RFC 2868, section 3.5: the most significant bit of the salt is set
+		salt[0] |= 0x80
+		return radius.NewTunnelPassword([]byte(text), salt[:], p.Secret, p.Authenticator[:])
+	}
+
+	return nil, fmt.Errorf("unsupported encryption: %d", attr.FlagEncrypt.Int)
+}
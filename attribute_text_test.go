@@ -0,0 +1,56 @@
+package libradius
+
+import (
+	"testing"
+
+	"layeh.com/radius"
+	"layeh.com/radius/rfc2868"
+)
+
+func TestParseAttributeTunnel(t *testing.T) {
+	p := radius.New(radius.CodeAccessAccept, []byte("testing123"))
+	for _, line := range []string{
+		`Tunnel-Type:1 = VLAN`,
+		`Tunnel-Medium-Type:1 = IEEE-802`,
+		`Tunnel-Private-Group-Id:1 = "100"`,
+	} {
+		if err := ParseAttribute(p, DefaultDictionary, line); err != nil {
+			t.Fatalf("%s: %s", line, err)
+		}
+	}
+
+	// VLAN is defined by RFC 3580, it has no constant in rfc2868
+	if tag, value, err := rfc2868.TunnelType_Lookup(p); err != nil || tag != 1 || value != rfc2868.TunnelType(13) {
+		t.Errorf("Tunnel-Type = %d:%d (%v)", tag, value, err)
+	}
+	if tag, value, err := rfc2868.TunnelMediumType_Lookup(p); err != nil || tag != 1 || value != rfc2868.TunnelMediumType_Value_IEEE802 {
+		t.Errorf("Tunnel-Medium-Type = %d:%d (%v)", tag, value, err)
+	}
+	if tag, value, err := rfc2868.TunnelPrivateGroupID_LookupString(p); err != nil || tag != 1 || value != "100" {
+		t.Errorf("Tunnel-Private-Group-Id = %d:%q (%v)", tag, value, err)
+	}
+}
+
+func TestParseAttributeTunnelPassword(t *testing.T) {
+	tests := []struct {
+		line string
+		tag  byte
+	}{
+		{`Tunnel-Password = "secret"`, 0},
+		{`Tunnel-Password:3 = "secret"`, 3},
+	}
+	for _, test := range tests {
+		p := radius.New(radius.CodeAccessAccept, []byte("testing123"))
+		if err := ParseAttribute(p, DefaultDictionary, test.line); err != nil {
+			t.Fatalf("%s: %s", test.line, err)
+		}
+
+		tag, value, err := rfc2868.TunnelPassword_Lookup(p, p)
+		if err != nil {
+			t.Fatalf("%s: %s", test.line, err)
+		}
+		if tag != test.tag || string(value) != "secret" {
+			t.Errorf("%s: got tag %d and %q", test.line, tag, value)
+		}
+	}
+}
@@ -2,9 +2,13 @@ package libradius
 
 import (
 	"fmt"
+	"net"
+	"strings"
+	"time"
 
 	"layeh.com/radius"
 	"layeh.com/radius/rfc2865"
+	"layeh.com/radius/rfc2868"
 )
 
 type RadiusUserData struct {
@@ -13,9 +17,101 @@ type RadiusUserData struct {
 	UserLocationName string
 }
 
+type ExternalAuthResult struct {
+	RadiusUserData
+
+	SessionTimeout  time.Duration
+	IdleTimeout     time.Duration
+	Class           [][]byte
+	FilterID        []string
+	ReplyMessage    string
+	FramedIPAddress net.IP
+	VLAN            string
+
+	ClientGroup          string
+	WimarkSessionTimeout time.Duration
+	AlwaysRedirect       bool
+	WLANID               string
+	CPEID                string
+}
+
+// ExternalAuthRules describe which attributes an external RADIUS server must
+// return for its Access-Accept to be usable in a deployment.
+type ExternalAuthRules struct {
+	RequireUserRole        bool     `json:"require_user_role"`
+	RequireUserLocation    bool     `json:"require_user_location"`
+	RequireClientGroup     bool     `json:"require_client_group"`
+	RequireVLAN            bool     `json:"require_vlan"`
+	RequireFramedIPAddress bool     `json:"require_framed_ip_address"`
+	AllowedUserRoles       []string `json:"allowed_user_roles"`
+}
+
+// NewExternalAuthRules returns the rules LookupExternalRadiusAuthAttrs has
+// always applied: a user role and a location or location name.
+func NewExternalAuthRules() *ExternalAuthRules {
+	return &ExternalAuthRules{
+		RequireUserRole:     true,
+		RequireUserLocation: true,
+	}
+}
+
+func (r *ExternalAuthRules) requiresAttributes() bool {
+	return r.RequireUserRole || r.RequireUserLocation || r.RequireClientGroup ||
+		r.RequireVLAN || r.RequireFramedIPAddress
+}
+
+func (r *ExternalAuthRules) Validate(result *ExternalAuthResult) error {
+	if r.RequireUserRole && len(result.UserRole) == 0 {
+		return fmt.Errorf("attribute UserRole not found")
+	}
+
+	if r.RequireUserLocation && len(result.UserLocation) == 0 && len(result.UserLocationName) == 0 {
+		return fmt.Errorf("attribute UserLocation or UserLocationName not found")
+	}
+
+	if r.RequireClientGroup && len(result.ClientGroup) == 0 {
+		return fmt.Errorf("attribute Wimark-Client-Group not found")
+	}
+
+	if r.RequireVLAN && len(result.VLAN) == 0 {
+		return fmt.Errorf("attribute Tunnel-Private-Group-Id not found")
+	}
+
+	if r.RequireFramedIPAddress && result.FramedIPAddress == nil {
+		return fmt.Errorf("attribute Framed-IP-Address not found")
+	}
+
+	if len(r.AllowedUserRoles) > 0 && len(result.UserRole) > 0 {
+		var allowed bool
+		for _, role := range r.AllowedUserRoles {
+			if role == result.UserRole {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return fmt.Errorf("user role %s is not allowed", result.UserRole)
+		}
+	}
+
+	return nil
+}
+
 func LookupExternalRadiusAuthAttrs(p *radius.Packet) (*RadiusUserData, error) {
-	var data RadiusUserData
-	if len(p.Attributes) == 0 {
+	result, err := DecodeExternalAuthResult(p, NewExternalAuthRules())
+	if err != nil {
+		return nil, err
+	}
+
+	return &result.RadiusUserData, nil
+}
+
+// DecodeExternalAuthResult decodes the reply attributes of an Access-Accept
+// received from an external RADIUS server and validates them with rules,
+// nil rules accept any result.
+func DecodeExternalAuthResult(p *radius.Packet, rules *ExternalAuthRules) (*ExternalAuthResult, error) {
+	var result ExternalAuthResult
+	if len(p.Attributes) == 0 && rules != nil && rules.requiresAttributes() {
 		return nil, fmt.Errorf("attributes list from response RADIUS packet is empty")
 	}
 
@@ -36,32 +132,64 @@ func LookupExternalRadiusAuthAttrs(p *radius.Packet) (*RadiusUserData, error) {
 					break
 				}
 
-				if vsaType == byte(WimarkRadiusExternalAuthUserRoleType) {
-					data.UserRole = radius.String(vsa[2:int(vsaLen)])
-				}
-
-				if vsaType == byte(WimarkRadiusExternalAuthUserLocationType) {
-					data.UserLocation = radius.String(vsa[2:int(vsaLen)])
-				}
-
-				if vsaType == byte(WimarkRadiusExternalAuthUserLocationNameType) {
-					data.UserLocationName = radius.String(vsa[2:int(vsaLen)])
-				}
+				decodeWimarkAuthAttr(&result, AVPType(vsaType), vsa[2:int(vsaLen)])
 
 				vsa = vsa[int(vsaLen):]
 			}
 		}
 	}
 
-	if len(data.UserRole) == 0 {
-		err := fmt.Errorf("attribute UserRole not found")
-		return nil, err
+	if v, err := rfc2865.SessionTimeout_Lookup(p); err == nil {
+		result.SessionTimeout = time.Duration(v) * time.Second
+	}
+	if v, err := rfc2865.IdleTimeout_Lookup(p); err == nil {
+		result.IdleTimeout = time.Duration(v) * time.Second
+	}
+	if v, err := rfc2865.FramedIPAddress_Lookup(p); err == nil {
+		result.FramedIPAddress = v
+	}
+	if _, v, err := rfc2868.TunnelPrivateGroupID_LookupString(p); err == nil {
+		result.VLAN = v
 	}
 
-	if len(data.UserLocation) == 0 && len(data.UserLocationName) == 0 {
-		err := fmt.Errorf("attribute UserLocation or UserLocationName not found")
-		return nil, err
+	result.Class, _ = rfc2865.Class_Gets(p)
+	result.FilterID, _ = rfc2865.FilterID_GetStrings(p)
+
+	// RFC 2865, section 5.18: multiple Reply-Messages are displayed
+	// concatenated
+	messages, _ := rfc2865.ReplyMessage_GetStrings(p)
+	result.ReplyMessage = strings.Join(messages, "")
+
+	if rules != nil {
+		if err := rules.Validate(&result); err != nil {
+			return nil, err
+		}
 	}
 
-	return &data, nil
+	return &result, nil
+}
+
+func decodeWimarkAuthAttr(result *ExternalAuthResult, vsaType AVPType, value []byte) {
+	switch vsaType {
+	case WimarkAVPTypeClientStr:
+		result.ClientGroup = radius.String(value)
+	case WimarkAVPTypeSessionInt:
+		if v, err := radius.Integer(value); err == nil {
+			result.WimarkSessionTimeout = time.Duration(v) * time.Second
+		}
+	case WimarkAVPTypeAlwaysRedirect:
+		if v, err := radius.Integer(value); err == nil {
+			result.AlwaysRedirect = v != 0
+		}
+	case WimarkRadiusExternalAuthUserRoleType:
+		result.UserRole = radius.String(value)
+	case WimarkRadiusExternalAuthUserLocationType:
+		result.UserLocation = radius.String(value)
+	case WimarkRadiusExternalAuthUserLocationNameType:
+		result.UserLocationName = radius.String(value)
+	case WimarkIdentifierWLANType:
+		result.WLANID = radius.String(value)
+	case WimarkAuthCPEIDType:
+		result.CPEID = radius.String(value)
+	}
 }
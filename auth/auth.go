@@ -0,0 +1,145 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"layeh.com/radius"
+	"layeh.com/radius/rfc2865"
+	"layeh.com/radius/vendors/microsoft"
+)
+
+const (
+	MethodPAP      = "PAP"
+	MethodCHAP     = "CHAP"
+	MethodMSCHAPv1 = "MS-CHAPv1"
+	MethodMSCHAPv2 = "MS-CHAPv2"
+)
+
+var (
+	ErrUnknownUser        = errors.New("unknown user")
+	ErrInvalidCredentials = errors.New("invalid credentials")
+	ErrUnsupportedMethod  = errors.New("unsupported authentication method")
+	ErrMissingCredentials = errors.New("credentials do not support the authentication method")
+)
+
+// Credentials hold what is known about a user secret. A cleartext Password
+// allows every method, an NTHash alone is enough for PAP and MS-CHAP and a
+// PasswordHash in htpasswd format (bcrypt, {SHA} or $apr1$) only for PAP.
+type Credentials struct {
+	Password     string
+	NTHash       []byte
+	PasswordHash string
+}
+
+type CredentialLookup func(ctx context.Context, username string) (*Credentials, error)
+
+type Result struct {
+	Method   string
+	UserName string
+
+	ident                 byte
+	authenticatorResponse string
+	sendKey               []byte
+	recvKey               []byte
+	mppeKeys              []byte
+}
+
+func DetectMethod(p *radius.Packet) string {
+	if _, ok := p.Lookup(rfc2865.UserPassword_Type); ok {
+		return MethodPAP
+	}
+	if _, ok := p.Lookup(rfc2865.CHAPPassword_Type); ok {
+		return MethodCHAP
+	}
+	if _, err := microsoft.MSCHAP2Response_Lookup(p); err == nil {
+		return MethodMSCHAPv2
+	}
+	if _, err := microsoft.MSCHAPResponse_Lookup(p); err == nil {
+		return MethodMSCHAPv1
+	}
+	return ""
+}
+
+// Verify authenticates an Access-Request with whichever method it carries.
+// A lookup returning nil credentials is treated as ErrUnknownUser.
+func Verify(ctx context.Context, p *radius.Packet, lookup CredentialLookup) (*Result, error) {
+	if p.Code != radius.CodeAccessRequest {
+		return nil, fmt.Errorf("unexpected packet code: %s (%d)", p.Code, p.Code)
+	}
+
+	username := rfc2865.UserName_GetString(p)
+	if len(username) == 0 {
+		return nil, fmt.Errorf("attribute User-Name not found")
+	}
+
+	method := DetectMethod(p)
+	if len(method) == 0 {
+		return nil, ErrUnsupportedMethod
+	}
+
+	creds, err := lookup(ctx, username)
+	if err != nil {
+		return nil, err
+	}
+	if creds == nil {
+		return nil, ErrUnknownUser
+	}
+
+	switch method {
+	case MethodPAP:
+		err = VerifyPAP(p, creds)
+	case MethodCHAP:
+		err = VerifyCHAP(p, creds)
+	case MethodMSCHAPv1:
+		return VerifyMSCHAPv1(p, creds)
+	case MethodMSCHAPv2:
+		return VerifyMSCHAPv2(p, creds)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &Result{Method: method, UserName: username}, nil
+}
+
+// AddAcceptAttributes adds what the method needs in the Access-Accept:
+// MS-CHAP2-Success and the MPPE keys for MS-CHAP, nothing otherwise.
+// response must be created with Response() of the request.
+func (r *Result) AddAcceptAttributes(response *radius.Packet) error {
+	switch r.Method {
+	case MethodMSCHAPv1:
+		if err := microsoft.MSCHAPMPPEKeys_Add(response, r.mppeKeys); err != nil {
+			return err
+		}
+	case MethodMSCHAPv2:
+		success := append([]byte{r.ident}, r.authenticatorResponse...)
+		if err := microsoft.MSCHAP2Success_Add(response, success); err != nil {
+			return err
+		}
+		if err := microsoft.MSMPPERecvKey_Add(response, r.recvKey); err != nil {
+			return err
+		}
+		if err := microsoft.MSMPPESendKey_Add(response, r.sendKey); err != nil {
+			return err
+		}
+	default:
+		return nil
+	}
+
+	if err := microsoft.MSMPPEEncryptionPolicy_Add(response, microsoft.MSMPPEEncryptionPolicy_Value_EncryptionAllowed); err != nil {
+		return err
+	}
+	return microsoft.MSMPPEEncryptionTypes_Add(response, microsoft.MSMPPEEncryptionTypes_Value_RC440or128BitAllowed)
+}
+
+// MPPEKeys returns the MS-MPPE-Send-Key and MS-MPPE-Recv-Key derived by a
+// successful MS-CHAPv2 verification.
+func (r *Result) MPPEKeys() (sendKey, recvKey []byte) {
+	return r.sendKey, r.recvKey
+}
+
+func (r *Result) AuthenticatorResponse() string {
+	return r.authenticatorResponse
+}
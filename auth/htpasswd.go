@@ -0,0 +1,162 @@
+package auth
+
+import (
+	"bufio"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const apr1Magic = "$apr1$"
+
+// LoadHtpasswd reads users from an htpasswd file. Only bcrypt, {SHA} and
+// $apr1$ hashes are accepted, so the users can authenticate with PAP only.
+func LoadHtpasswd(path string) (*StaticUserStore, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return ParseHtpasswd(f)
+}
+
+func ParseHtpasswd(r io.Reader) (*StaticUserStore, error) {
+	store := NewStaticUserStore()
+
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		name, hash, ok := strings.Cut(line, ":")
+		if !ok || len(name) == 0 {
+			return nil, fmt.Errorf("line %d: invalid htpasswd entry", n)
+		}
+		if !isSupportedHash(hash) {
+			return nil, fmt.Errorf("line %d: unsupported password hash for %s", n, name)
+		}
+
+		store.users[name] = &User{
+			Name:        name,
+			Credentials: Credentials{PasswordHash: hash},
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return store, nil
+}
+
+func isSupportedHash(hash string) bool {
+	return strings.HasPrefix(hash, "$2") ||
+		strings.HasPrefix(hash, "{SHA}") ||
+		strings.HasPrefix(hash, apr1Magic)
+}
+
+func checkPasswordHash(hash string, password []byte) error {
+	var ok bool
+	switch {
+	case strings.HasPrefix(hash, "$2"):
+		ok = bcrypt.CompareHashAndPassword([]byte(hash), password) == nil
+	case strings.HasPrefix(hash, "{SHA}"):
+		sum := sha1.Sum(password)
+		expected := "{SHA}" + base64.StdEncoding.EncodeToString(sum[:])
+		ok = subtle.ConstantTimeCompare([]byte(expected), []byte(hash)) == 1
+	case strings.HasPrefix(hash, apr1Magic):
+		salt := strings.TrimPrefix(hash, apr1Magic)
+		if i := strings.IndexByte(salt, '$'); i >= 0 {
+			salt = salt[:i]
+		}
+		ok = subtle.ConstantTimeCompare([]byte(apr1(password, []byte(salt))), []byte(hash)) == 1
+	default:
+		return fmt.Errorf("unsupported password hash")
+	}
+
+	if !ok {
+		return ErrInvalidCredentials
+	}
+	return nil
+}
+
+const apr1Alphabet = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+// apr1 is the MD5-based crypt of Apache, which differs from FreeBSD's
+// md5crypt only by the magic string.
+func apr1(password, salt []byte) string {
+	if len(salt) > 8 {
+		salt = salt[:8]
+	}
+
+	alternate := md5.New()
+	alternate.Write(password)
+	alternate.Write(salt)
+	alternate.Write(password)
+	sum := alternate.Sum(nil)
+
+	ctx := md5.New()
+	ctx.Write(password)
+	ctx.Write([]byte(apr1Magic))
+	ctx.Write(salt)
+	for i := len(password); i > 0; i -= md5.Size {
+		ctx.Write(sum[:min(i, md5.Size)])
+	}
+	for i := len(password); i > 0; i >>= 1 {
+		if i&1 != 0 {
+			ctx.Write([]byte{0})
+		} else {
+			ctx.Write(password[:1])
+		}
+	}
+	sum = ctx.Sum(nil)
+
+	for i := 0; i < 1000; i++ {
+		round := md5.New()
+		if i&1 != 0 {
+			round.Write(password)
+		} else {
+			round.Write(sum)
+		}
+		if i%3 != 0 {
+			round.Write(salt)
+		}
+		if i%7 != 0 {
+			round.Write(password)
+		}
+		if i&1 != 0 {
+			round.Write(sum)
+		} else {
+			round.Write(password)
+		}
+		sum = round.Sum(nil)
+	}
+
+	var b strings.Builder
+	b.WriteString(apr1Magic)
+	b.Write(salt)
+	b.WriteByte('$')
+
+	encode := func(v uint32, n int) {
+		for ; n > 0; n-- {
+			b.WriteByte(apr1Alphabet[v&0x3f])
+			v >>= 6
+		}
+	}
+	for _, i := range [][3]int{{0, 6, 12}, {1, 7, 13}, {2, 8, 14}, {3, 9, 15}, {4, 10, 5}} {
+		encode(uint32(sum[i[0]])<<16|uint32(sum[i[1]])<<8|uint32(sum[i[2]]), 4)
+	}
+	encode(uint32(sum[11]), 2)
+
+	return b.String()
+}
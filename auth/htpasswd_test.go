@@ -0,0 +1,70 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// the password of every user is "secret", the $apr1$ hash is the one of
+// openssl passwd -apr1
+const testHtpasswd = `
+# users
+bcrypt:$2a$04$0WWOj1HJV4qnRYNik4f.fOEuDvNa8IgkhMWu6KYjRKc8A9YSAmRIS
+sha:{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=
+apr1:$apr1$Wimark12$G8VTo0LkNb8MRJrBSgdGM0
+`
+
+func TestParseHtpasswd(t *testing.T) {
+	store, err := ParseHtpasswd(strings.NewReader(testHtpasswd))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if store.Len() != 3 {
+		t.Fatalf("%d users, want 3", store.Len())
+	}
+
+	tests := []struct {
+		user     string
+		password string
+		err      error
+	}{
+		{"bcrypt", "secret", nil},
+		{"bcrypt", "Secret", ErrInvalidCredentials},
+		{"sha", "secret", nil},
+		{"sha", "secret ", ErrInvalidCredentials},
+		{"apr1", "secret", nil},
+		{"apr1", "secrets", ErrInvalidCredentials},
+	}
+	for _, test := range tests {
+		user, err := store.LookupUser(context.Background(), test.user)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := checkPasswordHash(user.Credentials.PasswordHash, []byte(test.password)); !errors.Is(err, test.err) {
+			t.Errorf("%s with %q: got %v, want %v", test.user, test.password, err, test.err)
+		}
+	}
+
+	for _, file := range []string{"nohash\n", ":$apr1$abc$x\n", "md5:$1$abc$def\n", "plain:secret\n"} {
+		if _, err := ParseHtpasswd(strings.NewReader(file)); err == nil {
+			t.Errorf("%q parsed", file)
+		}
+	}
+}
+
+func TestAPR1(t *testing.T) {
+	// openssl passwd -apr1 -salt <salt> <password>
+	tests := []struct {
+		password, salt, hash string
+	}{
+		{"secret", "Wimark12", "$apr1$Wimark12$G8VTo0LkNb8MRJrBSgdGM0"},
+		{"pässwörd-long-enough-to-span-16+", "abc", "$apr1$abc$NikYPV06gEkXldln8BWKM1"},
+	}
+	for _, test := range tests {
+		if hash := apr1([]byte(test.password), []byte(test.salt)); hash != test.hash {
+			t.Errorf("apr1(%q, %q) = %s, want %s", test.password, test.salt, hash, test.hash)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const defaultHTTPUserStoreTimeout = 5 * time.Second
+
+type httpUserRequest struct {
+	UserName string `json:"user_name"`
+}
+
+type httpUserResponse struct {
+	Password       string `json:"password"`
+	NTHash         string `json:"nt_hash"`
+	PasswordHash   string `json:"password_hash"`
+	ClientGroup    string `json:"client_group"`
+	SessionTimeout int    `json:"session_timeout"`
+	ACLName        string `json:"acl_name"`
+}
+
+// HTTPUserStore asks an HTTP service for users. The user name is POSTed as
+// {"user_name": "..."}, the service answers 404 for unknown users and
+// otherwise a JSON object with the credentials and reply attributes:
+//
+//	{"password": "...", "nt_hash": "hex", "password_hash": "...",
+//	 "client_group": "...", "session_timeout": 3600, "acl_name": "..."}
+type HTTPUserStore struct {
+	URL    string
+	Header http.Header
+	Client *http.Client
+}
+
+func NewHTTPUserStore(url string) *HTTPUserStore {
+	return &HTTPUserStore{
+		URL:    url,
+		Header: make(http.Header),
+		Client: &http.Client{Timeout: defaultHTTPUserStoreTimeout},
+	}
+}
+
+func (s *HTTPUserStore) LookupUser(ctx context.Context, name string) (*User, error) {
+	body, err := json.Marshal(httpUserRequest{UserName: name})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for key, values := range s.Header {
+		req.Header[key] = values
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, ErrUnknownUser
+	default:
+		return nil, fmt.Errorf("unexpected user store response: %s", resp.Status)
+	}
+
+	var data httpUserResponse
+	if err := json.NewDecoder(resp.Body).Decode(&data); err != nil {
+		return nil, fmt.Errorf("unable to decode user store response: %w", err)
+	}
+
+	user := &User{
+		Name: name,
+		Credentials: Credentials{
+			Password:     data.Password,
+			PasswordHash: data.PasswordHash,
+		},
+		Reply: Reply{
+			ClientGroup:    data.ClientGroup,
+			SessionTimeout: time.Duration(data.SessionTimeout) * time.Second,
+			ACLName:        data.ACLName,
+		},
+	}
+
+	if len(data.NTHash) > 0 {
+		if user.Credentials.NTHash, err = hex.DecodeString(data.NTHash); err != nil {
+			return nil, fmt.Errorf("invalid nt_hash of %s: %w", name, err)
+		}
+	}
+
+	return user, nil
+}
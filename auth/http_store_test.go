@@ -0,0 +1,123 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"layeh.com/radius"
+	"layeh.com/radius/rfc2865"
+)
+
+type responseRecorder struct {
+	response *radius.Packet
+}
+
+func (r *responseRecorder) Write(p *radius.Packet) error {
+	r.response = p
+	return nil
+}
+
+func newPAPRequest(t *testing.T, user, password string) *radius.Request {
+	t.Helper()
+
+	p := radius.New(radius.CodeAccessRequest, []byte("testing123"))
+	rfc2865.UserName_SetString(p, user)
+	// radius.NewUserPassword expects passwords padded to 16 bytes
+	padded := make([]byte, (len(password)+15)/16*16)
+	copy(padded, password)
+	if err := rfc2865.UserPassword_Set(p, padded); err != nil {
+		t.Fatal(err)
+	}
+	return (&radius.Request{Packet: p}).WithContext(context.Background())
+}
+
+func TestHTTPUserStore(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		var request httpUserRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		switch request.UserName {
+		case "alice":
+			w.Write([]byte(`{"password": "secret", "nt_hash": "44ebba8d5312b8d611474411f56989ae",
+				"client_group": "staff", "session_timeout": 3600, "acl_name": "acl"}`))
+		case "slow":
+			select {
+			case <-time.After(time.Second):
+			case <-r.Context().Done():
+			}
+		case "broken":
+			w.WriteHeader(http.StatusInternalServerError)
+		case "garbage":
+			w.Write([]byte(`{"password":`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	store := NewHTTPUserStore(server.URL)
+	store.Header.Set("Authorization", "Bearer token")
+	store.Client.Timeout = 100 * time.Millisecond
+
+	user, err := store.LookupUser(context.Background(), "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Name != "alice" || user.Credentials.Password != "secret" ||
+		!bytes.Equal(user.Credentials.NTHash, []byte{0x44, 0xEB, 0xBA, 0x8D, 0x53, 0x12, 0xB8, 0xD6, 0x11, 0x47, 0x44, 0x11, 0xF5, 0x69, 0x89, 0xAE}) {
+		t.Errorf("got %+v", user)
+	}
+	if want := (Reply{ClientGroup: "staff", SessionTimeout: time.Hour, ACLName: "acl"}); user.Reply != want {
+		t.Errorf("reply %+v, want %+v", user.Reply, want)
+	}
+
+	if _, err := store.LookupUser(context.Background(), "bob"); !errors.Is(err, ErrUnknownUser) {
+		t.Errorf("unknown user: got %v", err)
+	}
+	for _, name := range []string{"slow", "broken", "garbage"} {
+		if _, err := store.LookupUser(context.Background(), name); err == nil || errors.Is(err, ErrUnknownUser) {
+			t.Errorf("%s: got %v, want a backend error", name, err)
+		}
+	}
+
+	for _, test := range []struct {
+		user, password string
+		code           radius.Code
+	}{
+		{"alice", "secret", radius.CodeAccessAccept},
+		{"alice", "wrong", radius.CodeAccessReject},
+		{"bob", "secret", radius.CodeAccessReject},
+	} {
+		w := &responseRecorder{}
+		NewHandler(store).ServeRADIUS(w, newPAPRequest(t, test.user, test.password))
+		if w.response == nil || w.response.Code != test.code {
+			t.Errorf("%s with %q: got %v, want %s", test.user, test.password, w.response, test.code)
+			continue
+		}
+		if test.code == radius.CodeAccessAccept && rfc2865.SessionTimeout_Get(w.response) != 3600 {
+			t.Errorf("Session-Timeout = %d", rfc2865.SessionTimeout_Get(w.response))
+		}
+	}
+
+	// a backend error is not answered, so that the NAS retries
+	request := newPAPRequest(t, "slow", "secret")
+	w := &responseRecorder{}
+	NewHandler(store).ServeRADIUS(w, request)
+	if w.response != nil {
+		t.Errorf("answered %s after a backend timeout", w.response.Code)
+	}
+}
@@ -0,0 +1,173 @@
+package auth
+
+import (
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"layeh.com/radius"
+	"layeh.com/radius/rfc2759"
+	"layeh.com/radius/rfc2865"
+	"layeh.com/radius/rfc3079"
+	"layeh.com/radius/vendors/microsoft"
+)
+
+const (
+	msCHAPErrorAuthenticationFailure = 691
+)
+
+var (
+	// RFC 2759, section 8.7
+	authResponseMagic1 = []byte("Magic server to client signing constant")
+	authResponseMagic2 = []byte("Pad to make it do more than one iteration")
+)
+
+func NTHash(password string) ([]byte, error) {
+	ucs2, err := rfc2759.ToUTF16([]byte(password))
+	if err != nil {
+		return nil, err
+	}
+	return rfc2759.NTPasswordHash(ucs2), nil
+}
+
+func (c *Credentials) PasswordNTHash() ([]byte, error) {
+	if len(c.NTHash) > 0 {
+		return c.NTHash, nil
+	}
+	if len(c.Password) > 0 {
+		return NTHash(c.Password)
+	}
+	return nil, ErrMissingCredentials
+}
+
+func VerifyMSCHAPv1(p *radius.Packet, creds *Credentials) (*Result, error) {
+	challenge := microsoft.MSCHAPChallenge_Get(p)
+	response := microsoft.MSCHAPResponse_Get(p)
+	if len(challenge) != 8 || len(response) != 50 {
+		return nil, fmt.Errorf("invalid MS-CHAP challenge or response length")
+	}
+	if response[1] != 1 {
+		return nil, fmt.Errorf("MS-CHAP LM responses are not supported")
+	}
+
+	ntHash, err := creds.PasswordNTHash()
+	if err != nil {
+		return nil, err
+	}
+
+	expected := rfc2759.ChallengeResponse(challenge, ntHash)
+	if subtle.ConstantTimeCompare(expected, response[26:50]) != 1 {
+		return nil, ErrInvalidCredentials
+	}
+
+	// RFC 2548, section 2.4.1: LM session key followed by the NT key,
+	// the LM part is left empty as LM hashes are never used
+	mppeKeys := make([]byte, 24)
+	copy(mppeKeys[8:], rfc2759.NTPasswordHash(ntHash))
+
+	return &Result{
+		Method:   MethodMSCHAPv1,
+		UserName: rfc2865.UserName_GetString(p),
+		ident:    response[0],
+		mppeKeys: mppeKeys,
+	}, nil
+}
+
+func VerifyMSCHAPv2(p *radius.Packet, creds *Credentials) (*Result, error) {
+	challenge := microsoft.MSCHAPChallenge_Get(p)
+	response := microsoft.MSCHAP2Response_Get(p)
+	if len(challenge) != 16 || len(response) != 50 {
+		return nil, fmt.Errorf("invalid MS-CHAPv2 challenge or response length")
+	}
+
+	ntHash, err := creds.PasswordNTHash()
+	if err != nil {
+		return nil, err
+	}
+
+	username := rfc2865.UserName_GetString(p)
+	ident, peerChallenge, ntResponse := response[0], response[2:18], response[26:50]
+
+	result, err := VerifyMSCHAPv2Response(challenge, peerChallenge, ntResponse, username, ntHash)
+	if err != nil {
+		return nil, err
+	}
+
+	result.UserName = username
+	result.ident = ident
+	return result, nil
+}
+
+// VerifyMSCHAPv2Response checks an NT-Response against the NT hash of the
+// user password. It is shared with EAP-MSCHAPv2, which carries the same
+// values outside of RADIUS attributes.
+func VerifyMSCHAPv2Response(authChallenge, peerChallenge, ntResponse []byte, username string, ntHash []byte) (*Result, error) {
+	candidates := []string{username}
+	// Windows peers hash the user name without the NT domain
+	if i := strings.LastIndexByte(username, '\\'); i >= 0 {
+		candidates = append(candidates, username[i+1:])
+	}
+
+	for _, name := range candidates {
+		challengeHash := rfc2759.ChallengeHash(peerChallenge, authChallenge, []byte(name))
+		expected := rfc2759.ChallengeResponse(challengeHash, ntHash)
+		if subtle.ConstantTimeCompare(expected, ntResponse) != 1 {
+			continue
+		}
+
+		ntHashHash := rfc2759.NTPasswordHash(ntHash)
+		masterKey := rfc3079.GetMasterKey(ntHashHash, ntResponse)
+		sendKey, err := rfc3079.GetAsymmetricStartKey(masterKey, rfc3079.KeyLength128Bit, true)
+		if err != nil {
+			return nil, err
+		}
+		recvKey, err := rfc3079.GetAsymmetricStartKey(masterKey, rfc3079.KeyLength128Bit, false)
+		if err != nil {
+			return nil, err
+		}
+
+		return &Result{
+			Method:                MethodMSCHAPv2,
+			authenticatorResponse: authenticatorResponse(ntHashHash, ntResponse, challengeHash),
+			sendKey:               sendKey,
+			recvKey:               recvKey,
+		}, nil
+	}
+
+	return nil, ErrInvalidCredentials
+}
+
+func authenticatorResponse(ntHashHash, ntResponse, challengeHash []byte) string {
+	sha := sha1.New()
+	sha.Write(ntHashHash)
+	sha.Write(ntResponse)
+	sha.Write(authResponseMagic1)
+	digest := sha.Sum(nil)
+
+	sha = sha1.New()
+	sha.Write(digest)
+	sha.Write(challengeHash)
+	sha.Write(authResponseMagic2)
+
+	return "S=" + strings.ToUpper(hex.EncodeToString(sha.Sum(nil)))
+}
+
+// AddMSCHAPError adds an MS-CHAP-Error to an Access-Reject answering an
+// MS-CHAP request, so that the peer reports an authentication failure.
+func AddMSCHAPError(request, reply *radius.Packet) error {
+	var response []byte
+	switch DetectMethod(request) {
+	case MethodMSCHAPv1:
+		response = microsoft.MSCHAPResponse_Get(request)
+	case MethodMSCHAPv2:
+		response = microsoft.MSCHAP2Response_Get(request)
+	}
+	if len(response) == 0 {
+		return nil
+	}
+
+	message := fmt.Sprintf("E=%d R=0 V=3", msCHAPErrorAuthenticationFailure)
+	return microsoft.MSCHAPError_Add(reply, append([]byte{response[0]}, message...))
+}
@@ -0,0 +1,125 @@
+package auth
+
+import (
+	"bytes"
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"layeh.com/radius"
+	"layeh.com/radius/rfc2865"
+	"layeh.com/radius/vendors/microsoft"
+)
+
+func unhex(t *testing.T, s string) []byte {
+	t.Helper()
+
+	b, err := hex.DecodeString(strings.ReplaceAll(s, " ", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// RFC 2759, section 9.2 and RFC 3079, section 3.5.2
+const (
+	vectorUserName      = "User"
+	vectorPassword      = "clientPass"
+	vectorAuthChallenge = "5B 5D 7C 7D 7B 3F 2F 3E 3C 2C 60 21 32 26 26 28"
+	vectorPeerChallenge = "21 40 23 24 25 5E 26 2A 28 29 5F 2B 3A 33 7C 7E"
+	vectorNTHash        = "44 EB BA 8D 53 12 B8 D6 11 47 44 11 F5 69 89 AE"
+	vectorNTResponse    = "82 30 9E CD 8D 70 8B 5E A0 8F AA 39 81 CD 83 54 42 33 11 4A 3D 85 D6 DF"
+	vectorAuthResponse  = "S=407A5589115FD0D6209F510FE9C04566932CDA56"
+	// SendStartKey128, the RFC derives it as the server
+	vectorSendKey = "8B 7C DC 14 9B 99 3A 1B A1 18 CB 15 3F 56 DC CB"
+)
+
+func TestNTHash(t *testing.T) {
+	ntHash, err := NTHash(vectorPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := unhex(t, vectorNTHash); !bytes.Equal(ntHash, want) {
+		t.Errorf("NTHash = %X, want %X", ntHash, want)
+	}
+}
+
+func TestVerifyMSCHAPv2Response(t *testing.T) {
+	tests := []struct {
+		name     string
+		username string
+		password string
+		err      error
+	}{
+		{"rfc vector", vectorUserName, vectorPassword, nil},
+		{"nt domain", `DOMAIN\` + vectorUserName, vectorPassword, nil},
+		{"wrong password", vectorUserName, "serverPass", ErrInvalidCredentials},
+		{"wrong user", "Other", vectorPassword, ErrInvalidCredentials},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ntHash, err := NTHash(test.password)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			result, err := VerifyMSCHAPv2Response(unhex(t, vectorAuthChallenge), unhex(t, vectorPeerChallenge), unhex(t, vectorNTResponse), test.username, ntHash)
+			if err != test.err {
+				t.Fatalf("err = %v, want %v", err, test.err)
+			}
+			if err != nil {
+				return
+			}
+
+			if result.AuthenticatorResponse() != vectorAuthResponse {
+				t.Errorf("AuthenticatorResponse = %s, want %s", result.AuthenticatorResponse(), vectorAuthResponse)
+			}
+			sendKey, recvKey := result.MPPEKeys()
+			if want := unhex(t, vectorSendKey); !bytes.Equal(sendKey, want) {
+				t.Errorf("send key = %X, want %X", sendKey, want)
+			}
+			if len(recvKey) != 16 || bytes.Equal(sendKey, recvKey) {
+				t.Errorf("recv key = %X", recvKey)
+			}
+		})
+	}
+}
+
+func TestVerifyMSCHAPv2(t *testing.T) {
+	request := radius.New(radius.CodeAccessRequest, []byte("testing123"))
+	rfc2865.UserName_SetString(request, vectorUserName)
+	microsoft.MSCHAPChallenge_Set(request, unhex(t, vectorAuthChallenge))
+
+	response := make([]byte, 50)
+	response[0] = 7
+	copy(response[2:18], unhex(t, vectorPeerChallenge))
+	copy(response[26:50], unhex(t, vectorNTResponse))
+	microsoft.MSCHAP2Response_Set(request, response)
+
+	result, err := VerifyMSCHAPv2(request, &Credentials{NTHash: unhex(t, vectorNTHash)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	accept := request.Response(radius.CodeAccessAccept)
+	if err := result.AddAcceptAttributes(accept); err != nil {
+		t.Fatal(err)
+	}
+	if success := string(microsoft.MSCHAP2Success_Get(accept)); success != "\x07"+vectorAuthResponse {
+		t.Errorf("MS-CHAP2-Success = %q", success)
+	}
+
+	// the keys are salted and encrypted with the secret
+	sendKey, err := microsoft.MSMPPESendKey_Lookup(accept, request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := unhex(t, vectorSendKey); !bytes.Equal(sendKey, want) {
+		t.Errorf("MS-MPPE-Send-Key = %X, want %X", sendKey, want)
+	}
+
+	if _, err := VerifyMSCHAPv2(request, &Credentials{Password: "serverPass"}); err != ErrInvalidCredentials {
+		t.Errorf("wrong password: err = %v", err)
+	}
+}
@@ -0,0 +1,74 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/subtle"
+	"fmt"
+
+	"layeh.com/radius"
+	"layeh.com/radius/rfc2865"
+)
+
+func VerifyPAP(p *radius.Packet, creds *Credentials) error {
+	password, err := rfc2865.UserPassword_Lookup(p)
+	if err != nil {
+		return fmt.Errorf("attribute User-Password not found")
+	}
+	// the decrypted value keeps the padding when the NAS pads with zeros
+	return CheckPassword(creds, bytes.TrimRight(password, "\x00"))
+}
+
+// CheckPassword compares a cleartext password with the credentials.
+func CheckPassword(creds *Credentials, password []byte) error {
+	if len(creds.Password) > 0 {
+		if subtle.ConstantTimeCompare(password, []byte(creds.Password)) != 1 {
+			return ErrInvalidCredentials
+		}
+		return nil
+	}
+
+	if len(creds.NTHash) > 0 {
+		hash, err := NTHash(string(password))
+		if err != nil {
+			return err
+		}
+		if subtle.ConstantTimeCompare(hash, creds.NTHash) != 1 {
+			return ErrInvalidCredentials
+		}
+		return nil
+	}
+
+	if len(creds.PasswordHash) > 0 {
+		return checkPasswordHash(creds.PasswordHash, password)
+	}
+
+	return ErrMissingCredentials
+}
+
+func VerifyCHAP(p *radius.Packet, creds *Credentials) error {
+	chapPassword := rfc2865.CHAPPassword_Get(p)
+	if len(chapPassword) != 17 {
+		return fmt.Errorf("invalid CHAP-Password length: %d", len(chapPassword))
+	}
+
+	if len(creds.Password) == 0 {
+		return ErrMissingCredentials
+	}
+
+	challenge := rfc2865.CHAPChallenge_Get(p)
+	if len(challenge) == 0 {
+		challenge = p.Authenticator[:]
+	}
+
+	hash := md5.New()
+	hash.Write(chapPassword[:1])
+	hash.Write([]byte(creds.Password))
+	hash.Write(challenge)
+
+	if subtle.ConstantTimeCompare(hash.Sum(nil), chapPassword[1:]) != 1 {
+		return ErrInvalidCredentials
+	}
+
+	return nil
+}
@@ -0,0 +1,129 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/wimark/libradius"
+	"layeh.com/radius"
+	"layeh.com/radius/rfc2865"
+)
+
+// Reply holds the authorization attributes returned with an Access-Accept.
+type Reply struct {
+	ClientGroup    string
+	SessionTimeout time.Duration
+	ACLName        string
+}
+
+func (r *Reply) Apply(p *radius.Packet) error {
+	if len(r.ClientGroup) > 0 {
+		if err := libradius.WimarkClientGroup_AddString(p, r.ClientGroup); err != nil {
+			return err
+		}
+	}
+	if r.SessionTimeout > 0 {
+		if err := rfc2865.SessionTimeout_Add(p, rfc2865.SessionTimeout(r.SessionTimeout/time.Second)); err != nil {
+			return err
+		}
+	}
+	if len(r.ACLName) > 0 {
+		libradius.AddVSAString(p, libradius.VendorAirspace, uint8(libradius.AirspaceAVPTypeACLName), r.ACLName)
+	}
+	return nil
+}
+
+type User struct {
+	Name        string
+	Credentials Credentials
+	Reply       Reply
+}
+
+// UserStore finds users by name. A missing user is reported as
+// ErrUnknownUser.
+type UserStore interface {
+	LookupUser(ctx context.Context, name string) (*User, error)
+}
+
+// StoreLookup adapts a UserStore to the CredentialLookup used by Verify and
+// the EAP methods.
+func StoreLookup(store UserStore) CredentialLookup {
+	return func(ctx context.Context, name string) (*Credentials, error) {
+		user, err := store.LookupUser(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		return &user.Credentials, nil
+	}
+}
+
+type StaticUserStore struct {
+	users map[string]*User
+}
+
+func NewStaticUserStore(users ...*User) *StaticUserStore {
+	s := &StaticUserStore{users: make(map[string]*User, len(users))}
+	for _, user := range users {
+		s.users[user.Name] = user
+	}
+	return s
+}
+
+func (s *StaticUserStore) LookupUser(ctx context.Context, name string) (*User, error) {
+	user, ok := s.users[name]
+	if !ok {
+		return nil, ErrUnknownUser
+	}
+
+	result := *user
+	return &result, nil
+}
+
+func (s *StaticUserStore) Len() int {
+	return len(s.users)
+}
+
+// NewHandler answers Access-Requests with PAP, CHAP or MS-CHAP credentials
+// from the store, adding the reply attributes of the user on success.
+func NewHandler(store UserStore) radius.Handler {
+	return radius.HandlerFunc(func(w radius.ResponseWriter, r *radius.Request) {
+		if r.Code != radius.CodeAccessRequest {
+			return
+		}
+
+		var user *User
+		var backendErr error
+		result, err := Verify(r.Context(), r.Packet, func(ctx context.Context, name string) (*Credentials, error) {
+			var err error
+			if user, err = store.LookupUser(ctx, name); err != nil {
+				if !errors.Is(err, ErrUnknownUser) {
+					backendErr = err
+				}
+				return nil, err
+			}
+			return &user.Credentials, nil
+		})
+		if err != nil {
+			if backendErr != nil {
+				// backend failures are not answered so the NAS retries or
+				// fails over to another server
+				return
+			}
+
+			reject := r.Response(radius.CodeAccessReject)
+			AddMSCHAPError(r.Packet, reject)
+			w.Write(reject)
+			return
+		}
+
+		accept := r.Response(radius.CodeAccessAccept)
+		if err := result.AddAcceptAttributes(accept); err != nil {
+			return
+		}
+		if err := user.Reply.Apply(accept); err != nil {
+			return
+		}
+		w.Write(accept)
+	})
+}
@@ -0,0 +1,288 @@
+package auth
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LoadUsersFile reads a subset of the FreeRADIUS users file:
+//
+//	alice   Cleartext-Password := "secret"
+//	        Wimark-Client-Group = "staff",
+//	        Session-Timeout = 3600,
+//	        Fall-Through = Yes
+//
+//	DEFAULT
+//	        Session-Timeout = 600
+//
+// Check items are Cleartext-Password, NT-Password and Crypt-Password, reply
+// items are Wimark-Client-Group, Session-Timeout and Airspace-ACL-Name. The
+// entries of a user and the DEFAULT ones apply in file order until one
+// without Fall-Through = Yes, "=" only sets a reply item not set yet.
+func LoadUsersFile(path string) (*StaticUserStore, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return ParseUsersFile(f)
+}
+
+type usersItem struct {
+	attr, op, value string
+}
+
+type usersEntry struct {
+	name        string
+	checks      []usersItem
+	reply       []usersItem
+	fallThrough bool
+}
+
+func (e *usersEntry) addCheck(attr, op, value string) error {
+	if err := setCheckItem(&Credentials{}, attr, value); err != nil {
+		return err
+	}
+	e.checks = append(e.checks, usersItem{attr, op, value})
+	return nil
+}
+
+func (e *usersEntry) addReply(attr, op, value string) error {
+	if attr == "Fall-Through" {
+		switch strings.ToLower(value) {
+		case "yes":
+			e.fallThrough = true
+		case "no":
+			e.fallThrough = false
+		default:
+			return fmt.Errorf("invalid Fall-Through: %s", value)
+		}
+		return nil
+	}
+
+	if err := setReplyItem(&Reply{}, attr, value); err != nil {
+		return err
+	}
+	e.reply = append(e.reply, usersItem{attr, op, value})
+	return nil
+}
+
+func ParseUsersFile(r io.Reader) (*StaticUserStore, error) {
+	var entries []*usersEntry
+	var entry *usersEntry
+	names := make(map[string]bool)
+
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := scanner.Text()
+		trimmed := strings.TrimSpace(line)
+		if len(trimmed) == 0 || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+
+		if line[0] == ' ' || line[0] == '\t' {
+			if entry == nil {
+				return nil, fmt.Errorf("line %d: reply item without a user", n)
+			}
+			if err := parseUsersItems(trimmed, entry.addReply); err != nil {
+				return nil, fmt.Errorf("line %d: %w", n, err)
+			}
+			continue
+		}
+
+		name, checks := splitUsersName(trimmed)
+		if name != "DEFAULT" {
+			if names[name] {
+				return nil, fmt.Errorf("line %d: duplicate user %s", n, name)
+			}
+			names[name] = true
+		}
+
+		entry = &usersEntry{name: name}
+		if err := parseUsersItems(checks, entry.addCheck); err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+		entries = append(entries, entry)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	store := NewStaticUserStore()
+	for name := range names {
+		user, err := resolveUser(entries, name)
+		if err != nil {
+			return nil, err
+		}
+		store.users[name] = user
+	}
+
+	return store, nil
+}
+
+// resolveUser applies the entries matching the user like FreeRADIUS does,
+// DEFAULT entries only add to users named in the file.
+func resolveUser(entries []*usersEntry, name string) (*User, error) {
+	user := &User{Name: name}
+	for _, entry := range entries {
+		if entry.name != name && entry.name != "DEFAULT" {
+			continue
+		}
+
+		for _, item := range entry.checks {
+			if err := setCheckItem(&user.Credentials, item.attr, item.value); err != nil {
+				return nil, err
+			}
+		}
+		for _, item := range entry.reply {
+			if item.op == "=" && hasReplyItem(&user.Reply, item.attr) {
+				continue
+			}
+			if err := setReplyItem(&user.Reply, item.attr, item.value); err != nil {
+				return nil, err
+			}
+		}
+
+		if !entry.fallThrough {
+			break
+		}
+	}
+	return user, nil
+}
+
+func splitUsersName(line string) (string, string) {
+	if strings.HasPrefix(line, `"`) {
+		if end := strings.IndexByte(line[1:], '"'); end >= 0 {
+			return line[1 : end+1], strings.TrimSpace(line[end+2:])
+		}
+	}
+
+	i := strings.IndexAny(line, " \t")
+	if i < 0 {
+		return line, ""
+	}
+	return line[:i], strings.TrimSpace(line[i:])
+}
+
+// parseUsersItems splits "Attr op value, Attr op value" outside of quotes.
+func parseUsersItems(line string, set func(attr, op, value string) error) error {
+	var items []string
+	var quoted bool
+	start := 0
+	for i := 0; i < len(line); i++ {
+		switch {
+		case line[i] == '\\' && quoted:
+			i++
+		case line[i] == '"':
+			quoted = !quoted
+		case line[i] == ',' && !quoted:
+			items = append(items, line[start:i])
+			start = i + 1
+		}
+	}
+	if quoted {
+		return fmt.Errorf("unterminated string")
+	}
+	items = append(items, line[start:])
+
+	for _, item := range items {
+		item = strings.TrimSpace(item)
+		if len(item) == 0 {
+			continue
+		}
+
+		attr, op, value, err := splitUsersItem(item)
+		if err != nil {
+			return err
+		}
+		if err := set(attr, op, value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func splitUsersItem(item string) (string, string, string, error) {
+	i := strings.IndexAny(item, ":=+!<>")
+	if i <= 0 {
+		return "", "", "", fmt.Errorf("invalid item: %s", item)
+	}
+
+	attr := strings.TrimSpace(item[:i])
+	rest := item[i:]
+	for _, op := range []string{":=", "==", "+=", "="} {
+		if strings.HasPrefix(rest, op) {
+			value := strings.TrimSpace(rest[len(op):])
+			if strings.HasPrefix(value, `"`) {
+				unquoted, err := strconv.Unquote(value)
+				if err != nil {
+					return "", "", "", fmt.Errorf("invalid value of %s: %s", attr, value)
+				}
+				value = unquoted
+			}
+			return attr, op, value, nil
+		}
+	}
+
+	return "", "", "", fmt.Errorf("unsupported operator in item: %s", item)
+}
+
+func setCheckItem(creds *Credentials, attr, value string) error {
+	switch attr {
+	case "Cleartext-Password":
+		creds.Password = value
+	case "NT-Password":
+		hash, err := hex.DecodeString(strings.TrimPrefix(value, "0x"))
+		if err != nil || len(hash) != 16 {
+			return fmt.Errorf("invalid NT-Password: %s", value)
+		}
+		creds.NTHash = hash
+	case "Crypt-Password":
+		if !isSupportedHash(value) {
+			return fmt.Errorf("unsupported Crypt-Password hash")
+		}
+		creds.PasswordHash = value
+	default:
+		return fmt.Errorf("unsupported check item: %s", attr)
+	}
+	return nil
+}
+
+func hasReplyItem(reply *Reply, attr string) bool {
+	switch attr {
+	case "Wimark-Client-Group":
+		return len(reply.ClientGroup) > 0
+	case "Session-Timeout":
+		return reply.SessionTimeout > 0
+	case "Airspace-ACL-Name":
+		return len(reply.ACLName) > 0
+	}
+	return false
+}
+
+func setReplyItem(reply *Reply, attr, value string) error {
+	switch attr {
+	case "Wimark-Client-Group":
+		reply.ClientGroup = value
+	case "Session-Timeout":
+		seconds, err := strconv.ParseUint(value, 10, 32)
+		if err != nil {
+			return fmt.Errorf("invalid Session-Timeout: %s", value)
+		}
+		reply.SessionTimeout = time.Duration(seconds) * time.Second
+	case "Airspace-ACL-Name":
+		reply.ACLName = value
+	default:
+		return fmt.Errorf("unsupported reply item: %s", attr)
+	}
+	return nil
+}
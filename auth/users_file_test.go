@@ -0,0 +1,146 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseUsersFile(t *testing.T) {
+	tests := []struct {
+		name  string
+		file  string
+		users map[string]User
+		err   bool
+	}{
+		{
+			name: "check and reply items",
+			file: `
+# comment
+alice	Cleartext-Password := "secret"
+	Wimark-Client-Group = "staff",
+	Session-Timeout = 3600,
+	Airspace-ACL-Name = "acl"
+"bob smith"	NT-Password := 0x44EBBA8D5312B8D611474411F56989AE
+carol	Crypt-Password := "{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ="
+`,
+			users: map[string]User{
+				"alice": {
+					Credentials: Credentials{Password: "secret"},
+					Reply:       Reply{ClientGroup: "staff", SessionTimeout: time.Hour, ACLName: "acl"},
+				},
+				"bob smith": {
+					Credentials: Credentials{NTHash: []byte{0x44, 0xEB, 0xBA, 0x8D, 0x53, 0x12, 0xB8, 0xD6, 0x11, 0x47, 0x44, 0x11, 0xF5, 0x69, 0x89, 0xAE}},
+				},
+				"carol": {
+					Credentials: Credentials{PasswordHash: "{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ="},
+				},
+			},
+		},
+		{
+			name: "DEFAULT after a user without Fall-Through",
+			file: `
+alice	Cleartext-Password := "secret"
+	Session-Timeout = 3600
+DEFAULT
+	Wimark-Client-Group = "guests"
+`,
+			users: map[string]User{
+				"alice": {
+					Credentials: Credentials{Password: "secret"},
+					Reply:       Reply{SessionTimeout: time.Hour},
+				},
+			},
+		},
+		{
+			name: "DEFAULT after a user with Fall-Through",
+			file: `
+alice	Cleartext-Password := "secret"
+	Session-Timeout = 3600,
+	Fall-Through = Yes
+bob	Cleartext-Password := "other"
+DEFAULT
+	Wimark-Client-Group = "guests",
+	Session-Timeout = 600
+`,
+			users: map[string]User{
+				"alice": {
+					Credentials: Credentials{Password: "secret"},
+					Reply:       Reply{ClientGroup: "guests", SessionTimeout: time.Hour},
+				},
+				"bob": {
+					Credentials: Credentials{Password: "other"},
+				},
+			},
+		},
+		{
+			name: "DEFAULT with Fall-Through before the users",
+			file: `
+DEFAULT
+	Session-Timeout = 600,
+	Fall-Through = Yes
+alice	Cleartext-Password := "secret"
+	Session-Timeout := 3600
+bob	Cleartext-Password := "other"
+	Session-Timeout = 3600
+`,
+			users: map[string]User{
+				"alice": {
+					Credentials: Credentials{Password: "secret"},
+					Reply:       Reply{SessionTimeout: time.Hour},
+				},
+				"bob": {
+					Credentials: Credentials{Password: "other"},
+					Reply:       Reply{SessionTimeout: 10 * time.Minute},
+				},
+			},
+		},
+		{name: "reply item without a user", file: "\tSession-Timeout = 60\n", err: true},
+		{name: "duplicate user", file: "alice Cleartext-Password := \"a\"\nalice Cleartext-Password := \"b\"\n", err: true},
+		{name: "unsupported check item", file: "alice Auth-Type := Accept\n", err: true},
+		{name: "unsupported reply item", file: "alice\n\tReply-Message = \"hi\"\n", err: true},
+		{name: "invalid Fall-Through", file: "alice\n\tFall-Through = maybe\n", err: true},
+		{name: "invalid NT-Password", file: "alice NT-Password := 0x1234\n", err: true},
+		{name: "unterminated string", file: "alice Cleartext-Password := \"secret\n", err: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			store, err := ParseUsersFile(strings.NewReader(test.file))
+			if test.err {
+				if err == nil {
+					t.Fatal("no error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if store.Len() != len(test.users) {
+				t.Errorf("%d users, want %d", store.Len(), len(test.users))
+			}
+			for name, want := range test.users {
+				user, err := store.LookupUser(context.Background(), name)
+				if err != nil {
+					t.Fatalf("%s: %s", name, err)
+				}
+				if user.Credentials.Password != want.Credentials.Password ||
+					user.Credentials.PasswordHash != want.Credentials.PasswordHash ||
+					!bytes.Equal(user.Credentials.NTHash, want.Credentials.NTHash) {
+					t.Errorf("%s: credentials %+v, want %+v", name, user.Credentials, want.Credentials)
+				}
+				if user.Reply != want.Reply {
+					t.Errorf("%s: reply %+v, want %+v", name, user.Reply, want.Reply)
+				}
+			}
+
+			if _, err := store.LookupUser(context.Background(), "DEFAULT"); !errors.Is(err, ErrUnknownUser) {
+				t.Errorf("DEFAULT is a user: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,161 @@
+package libradius
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"strings"
+
+	"layeh.com/radius"
+	"layeh.com/radius/rfc2865"
+)
+
+const maxUserPasswordLen = 128
+
+type AuthenticateStatus string
+
+const (
+	AuthenticateAccept    AuthenticateStatus = "accept"
+	AuthenticateReject    AuthenticateStatus = "reject"
+	AuthenticateChallenge AuthenticateStatus = "challenge"
+)
+
+type AuthenticateOptions struct {
+	NASIdentifier    string
+	NASIPAddress     net.IP
+	CallingStationID string
+	CalledStationID  string
+
+	// Challenge answers an Access-Challenge returned by a previous call,
+	// the password of the follow-up call is the response of the user.
+	Challenge string
+
+	// Rules validate the attributes of an Access-Accept, nil accepts any.
+	Rules *ExternalAuthRules
+}
+
+type AuthenticateResult struct {
+	Status       AuthenticateStatus
+	ReplyMessage string
+
+	// UserData and Attributes are set for AuthenticateAccept.
+	UserData   *RadiusUserData
+	Attributes *ExternalAuthResult
+
+	// Challenge is set for AuthenticateChallenge and carries the State of
+	// the server to the follow-up call.
+	Challenge string
+}
+
+// Authenticate sends a PAP Access-Request for user to an external RADIUS
+// server. OTP and MFA servers may answer with a challenge, which is
+// answered by calling Authenticate again with the token in opts.
+func Authenticate(ctx context.Context, server *RadiusServerConfig, user, password string, opts *AuthenticateOptions) (*AuthenticateResult, error) {
+	if opts == nil {
+		opts = &AuthenticateOptions{}
+	}
+
+	packet, err := newAuthenticateRequest(server, user, password, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, defaultSendRadiusPacketTimeout)
+		defer cancel()
+	}
+
+	response, err := exchange(ctx, radius.DefaultClient, packet, server.GetAddr(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if HasMessageAuthenticator(response) && !VerifyMessageAuthenticator(response, packet.Authenticator[:]) {
+		return nil, fmt.Errorf("invalid Message-Authenticator in %s", response.Code)
+	}
+
+	messages, _ := rfc2865.ReplyMessage_GetStrings(response)
+	result := AuthenticateResult{
+		ReplyMessage: strings.Join(messages, ""),
+	}
+
+	switch response.Code {
+	case radius.CodeAccessAccept:
+		attrs, err := DecodeExternalAuthResult(response, opts.Rules)
+		if err != nil {
+			return nil, err
+		}
+		result.Status = AuthenticateAccept
+		result.UserData = &attrs.RadiusUserData
+		result.Attributes = attrs
+	case radius.CodeAccessReject:
+		result.Status = AuthenticateReject
+	case radius.CodeAccessChallenge:
+		state := rfc2865.State_Get(response)
+		if len(state) == 0 {
+			return nil, fmt.Errorf("attribute State not found")
+		}
+		result.Status = AuthenticateChallenge
+		result.Challenge = base64.RawURLEncoding.EncodeToString(state)
+	default:
+		return nil, fmt.Errorf("unexpected access response: %s (%d)", response.Code, response.Code)
+	}
+
+	return &result, nil
+}
+
+func newAuthenticateRequest(server *RadiusServerConfig, user, password string, opts *AuthenticateOptions) (*radius.Packet, error) {
+	if len(password) > maxUserPasswordLen {
+		return nil, fmt.Errorf("too long password: %d bytes", len(password))
+	}
+
+	packet := radius.New(radius.CodeAccessRequest, []byte(server.Secret))
+	if err := rfc2865.UserName_SetString(packet, user); err != nil {
+		return nil, err
+	}
+	if err := rfc2865.UserPassword_Set(packet, padUserPassword(password)); err != nil {
+		return nil, err
+	}
+
+	if len(opts.NASIdentifier) > 0 {
+		rfc2865.NASIdentifier_SetString(packet, opts.NASIdentifier)
+	}
+	if opts.NASIPAddress != nil {
+		if err := rfc2865.NASIPAddress_Set(packet, opts.NASIPAddress); err != nil {
+			return nil, err
+		}
+	}
+	if len(opts.CallingStationID) > 0 {
+		rfc2865.CallingStationID_SetString(packet, opts.CallingStationID)
+	}
+	if len(opts.CalledStationID) > 0 {
+		rfc2865.CalledStationID_SetString(packet, opts.CalledStationID)
+	}
+
+	if len(opts.Challenge) > 0 {
+		state, err := base64.RawURLEncoding.DecodeString(opts.Challenge)
+		if err != nil {
+			return nil, fmt.Errorf("invalid challenge token: %w", err)
+		}
+		rfc2865.State_Set(packet, state)
+	}
+
+	if err := AddMessageAuthenticator(packet); err != nil {
+		return nil, err
+	}
+
+	return packet, nil
+}
+
+// padUserPassword pads the password with zeros to a multiple of 16 bytes as
+// radius.NewUserPassword expects (RFC 2865, section 5.2).
+func padUserPassword(password string) []byte {
+	padded := make([]byte, (len(password)+15)/16*16)
+	if len(padded) == 0 {
+		padded = make([]byte, 16)
+	}
+	copy(padded, password)
+	return padded
+}
@@ -0,0 +1,50 @@
+package libradius
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"layeh.com/radius"
+)
+
+func TestAuthenticateBareAccept(t *testing.T) {
+	host, port, err := net.SplitHostPort(startTestServer(t, "testing123"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := NewRadiusServerConfig(host, port, "testing123")
+
+	tests := []struct {
+		name  string
+		rules *ExternalAuthRules
+		err   bool
+	}{
+		{"without rules", nil, false},
+		{"with rules requiring nothing", &ExternalAuthRules{AllowedUserRoles: []string{"guest"}}, false},
+		{"with the default rules", NewExternalAuthRules(), true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result, err := Authenticate(context.Background(), server, "bob", "password", &AuthenticateOptions{Rules: test.rules})
+			if test.err {
+				if err == nil {
+					t.Fatalf("got %s without the required attributes", result.Status)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.Status != AuthenticateAccept {
+				t.Errorf("got %s", result.Status)
+			}
+		})
+	}
+
+	// the legacy lookup keeps requiring a user role and location
+	if _, err := LookupExternalRadiusAuthAttrs(radius.New(radius.CodeAccessAccept, nil)); err == nil {
+		t.Error("LookupExternalRadiusAuthAttrs accepted a bare Access-Accept")
+	}
+}
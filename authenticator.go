@@ -0,0 +1,83 @@
+package libradius
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+
+	"layeh.com/radius"
+	"layeh.com/radius/rfc2869"
+)
+
+const messageAuthenticatorLen = 16
+
+// AddMessageAuthenticator sets the Message-Authenticator (RFC 3579) of p. It
+// must be called after every other attribute is added. Responses must be
+// created with Response() so that they carry the request authenticator.
+func AddMessageAuthenticator(p *radius.Packet) error {
+	p.Set(rfc2869.MessageAuthenticator_Type, make(radius.Attribute, messageAuthenticatorLen))
+
+	sum, err := messageAuthenticator(p, requestAuthenticatorOf(p))
+	if err != nil {
+		return err
+	}
+
+	p.Set(rfc2869.MessageAuthenticator_Type, sum)
+	return nil
+}
+
+// VerifyMessageAuthenticator checks the Message-Authenticator of a received
+// packet. For responses requestAuthenticator is the authenticator of the
+// request they answer, for requests it must be nil.
+func VerifyMessageAuthenticator(p *radius.Packet, requestAuthenticator []byte) bool {
+	received, ok := p.Lookup(rfc2869.MessageAuthenticator_Type)
+	if !ok || len(received) != messageAuthenticatorLen {
+		return false
+	}
+
+	if requestAuthenticator == nil {
+		requestAuthenticator = requestAuthenticatorOf(p)
+	}
+
+	sum, err := messageAuthenticator(p, requestAuthenticator)
+	if err != nil {
+		return false
+	}
+
+	return hmac.Equal(sum, received)
+}
+
+func HasMessageAuthenticator(p *radius.Packet) bool {
+	_, ok := p.Lookup(rfc2869.MessageAuthenticator_Type)
+	return ok
+}
+
+func requestAuthenticatorOf(p *radius.Packet) []byte {
+	switch p.Code {
+	case radius.CodeAccountingRequest, radius.CodeCoARequest, radius.CodeDisconnectRequest:
+		return make([]byte, 16)
+	default:
+		return p.Authenticator[:]
+	}
+}
+
+func messageAuthenticator(p *radius.Packet, authenticator []byte) ([]byte, error) {
+	q := *p
+	copy(q.Authenticator[:], authenticator)
+
+	q.Attributes = make(radius.Attributes, 0, len(p.Attributes))
+	for _, avp := range p.Attributes {
+		if avp.Type == rfc2869.MessageAuthenticator_Type {
+			avp = &radius.AVP{Type: avp.Type, Attribute: make(radius.Attribute, messageAuthenticatorLen)}
+		}
+		q.Attributes = append(q.Attributes, avp)
+	}
+
+	b, err := q.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+
+	mac := hmac.New(md5.New, p.Secret)
+	mac.Write(b)
+	return mac.Sum(nil), nil
+}
@@ -0,0 +1,142 @@
+package libradius
+
+import (
+	"testing"
+
+	"layeh.com/radius"
+	"layeh.com/radius/rfc2865"
+	"layeh.com/radius/rfc2869"
+)
+
+func TestMessageAuthenticator(t *testing.T) {
+	secret := []byte("testing123")
+
+	newRequest := func(code radius.Code) *radius.Packet {
+		p := radius.New(code, secret)
+		rfc2865.UserName_SetString(p, "bob")
+		return p
+	}
+
+	tests := []struct {
+		name   string
+		packet func() (p *radius.Packet, requestAuthenticator []byte)
+		valid  bool
+	}{
+		{
+			name: "Access-Request",
+			packet: func() (*radius.Packet, []byte) {
+				p := newRequest(radius.CodeAccessRequest)
+				AddMessageAuthenticator(p)
+				return p, nil
+			},
+			valid: true,
+		},
+		{
+			name: "Accounting-Request",
+			packet: func() (*radius.Packet, []byte) {
+				p := newRequest(radius.CodeAccountingRequest)
+				AddMessageAuthenticator(p)
+				return p, nil
+			},
+			valid: true,
+		},
+		{
+			name: "CoA-Request",
+			packet: func() (*radius.Packet, []byte) {
+				p := newRequest(radius.CodeCoARequest)
+				AddMessageAuthenticator(p)
+				return p, nil
+			},
+			valid: true,
+		},
+		{
+			name: "Access-Accept",
+			packet: func() (*radius.Packet, []byte) {
+				request := newRequest(radius.CodeAccessRequest)
+				p := request.Response(radius.CodeAccessAccept)
+				AddMessageAuthenticator(p)
+				return p, request.Authenticator[:]
+			},
+			valid: true,
+		},
+		{
+			name: "Access-Accept of another request",
+			packet: func() (*radius.Packet, []byte) {
+				p := newRequest(radius.CodeAccessRequest).Response(radius.CodeAccessAccept)
+				AddMessageAuthenticator(p)
+				return p, newRequest(radius.CodeAccessRequest).Authenticator[:]
+			},
+		},
+		{
+			name: "modified attribute",
+			packet: func() (*radius.Packet, []byte) {
+				p := newRequest(radius.CodeAccessRequest)
+				AddMessageAuthenticator(p)
+				rfc2865.UserName_SetString(p, "eve")
+				return p, nil
+			},
+		},
+		{
+			name: "other secret",
+			packet: func() (*radius.Packet, []byte) {
+				p := newRequest(radius.CodeAccessRequest)
+				AddMessageAuthenticator(p)
+				p.Secret = []byte("other")
+				return p, nil
+			},
+		},
+		{
+			name: "missing",
+			packet: func() (*radius.Packet, []byte) {
+				return newRequest(radius.CodeAccessRequest), nil
+			},
+		},
+		{
+			name: "short",
+			packet: func() (*radius.Packet, []byte) {
+				p := newRequest(radius.CodeAccessRequest)
+				rfc2869.MessageAuthenticator_Set(p, make([]byte, 8))
+				return p, nil
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p, requestAuthenticator := test.packet()
+			if valid := VerifyMessageAuthenticator(p, requestAuthenticator); valid != test.valid {
+				t.Errorf("VerifyMessageAuthenticator = %t, want %t", valid, test.valid)
+			}
+		})
+	}
+}
+
+func TestMessageAuthenticatorWire(t *testing.T) {
+	// the attribute survives encoding and is verified on the parsed packet
+	p := radius.New(radius.CodeAccessRequest, []byte("testing123"))
+	rfc2865.UserName_SetString(p, "bob")
+	if err := AddMessageAuthenticator(p); err != nil {
+		t.Fatal(err)
+	}
+	// set again after another attribute, it is computed over the whole packet
+	rfc2865.NASIdentifier_SetString(p, "nas")
+	if err := AddMessageAuthenticator(p); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := p.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := radius.Parse(b, []byte("testing123"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(parsed.Attributes) != 3 {
+		t.Errorf("%d attributes, want a single Message-Authenticator", len(parsed.Attributes))
+	}
+	if !VerifyMessageAuthenticator(parsed, nil) {
+		t.Error("invalid Message-Authenticator after parsing")
+	}
+}
@@ -2,6 +2,9 @@ package libradius
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
+	"sync"
 	"time"
 
 	"layeh.com/radius"
@@ -10,6 +13,14 @@ import (
 type RadiusClientConfig struct {
 	MaxPacketErrors int
 	Retry           time.Duration
+
+	// Transport, TLSConfig, MaxConns and KeepAlive are used by Client only,
+	// MaxConns by MuxClient as well. Idle TCP and TLS connections are checked
+	// with Status-Server every KeepAlive.
+	Transport Transport
+	TLSConfig *tls.Config
+	MaxConns  int
+	KeepAlive time.Duration
 }
 
 func NewRadiusClientConfig(maxPacketErrors int, retry time.Duration) *RadiusClientConfig {
@@ -26,14 +37,132 @@ func NewRadiusClient(cfg *RadiusClientConfig) *radius.Client {
 	}
 }
 
+// SendPacket sends the packet over UDP, Client.Exchange sends it over TCP,
+// TLS or DTLS.
 func SendPacket(addr string, packet *radius.Packet) (*radius.Packet, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), defaultSendRadiusPacketTimeout)
 	defer cancel()
 
-	response, err := radius.Exchange(ctx, packet, addr)
+	return SendPacketContext(ctx, addr, packet)
+}
+
+func SendPacketContext(ctx context.Context, addr string, packet *radius.Packet) (*radius.Packet, error) {
+	response, err := exchange(ctx, radius.DefaultClient, packet, addr, nil)
 	if err != nil {
 		return nil, err
 	}
 
 	return response, nil
 }
+
+// retransmitFunc updates a request before it is sent again, elapsed after
+// the first transmission. The packet gets a new Identifier afterwards, so
+// every retransmission is a new request on the wire.
+type retransmitFunc func(packet *radius.Packet, elapsed time.Duration)
+
+// exchange mirrors radius.Client.Exchange over UDP, reporting every step of
+// the exchange to the installed Metrics.
+func exchange(
+	ctx context.Context,
+	client *radius.Client,
+	packet *radius.Packet,
+	addr string,
+	retransmit retransmitFunc,
+) (*radius.Packet, error) {
+	wire, err := packet.Encode()
+	if err != nil {
+		return nil, err
+	}
+
+	var sentMu sync.Mutex
+	sent := map[byte][]byte{packet.Identifier: wire}
+
+	conn, err := client.Dialer.DialContext(ctx, "udp", addr)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, err
+	}
+	defer conn.Close()
+
+	metrics().ClientRequest(addr, packet.Code)
+	start := time.Now()
+	conn.Write(wire)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var retryTimer <-chan time.Time
+	if client.Retry > 0 {
+		retry := time.NewTicker(client.Retry)
+		defer retry.Stop()
+		retryTimer = retry.C
+	}
+
+	go func() {
+		defer conn.Close()
+		wire, current := wire, ClonePacket(packet)
+		for {
+			select {
+			case <-retryTimer:
+				metrics().ClientRetransmit(addr, packet.Code)
+				if retransmit != nil {
+					retransmit(current, time.Since(start))
+					current.Identifier++
+
+					var err error
+					if wire, err = current.Encode(); err != nil {
+						return
+					}
+					sentMu.Lock()
+					sent[current.Identifier] = wire
+					sentMu.Unlock()
+				}
+				conn.Write(wire)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	var packetErrorCount int
+	var incoming [radius.MaxPacketLength]byte
+	for {
+		n, err := conn.Read(incoming[:])
+		if err != nil {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				if errors.Is(ctxErr, context.DeadlineExceeded) {
+					metrics().ClientTimeout(addr, packet.Code)
+				}
+				return nil, ctxErr
+			}
+			return nil, err
+		}
+
+		received, err := radius.Parse(incoming[:n], packet.Secret)
+		if err != nil {
+			packetErrorCount++
+			if client.MaxPacketErrors > 0 && packetErrorCount >= client.MaxPacketErrors {
+				return nil, err
+			}
+			continue
+		}
+
+		sentMu.Lock()
+		request, ok := sent[received.Identifier]
+		sentMu.Unlock()
+
+		if !ok || !client.InsecureSkipVerify && !radius.IsAuthenticResponse(incoming[:n], request, packet.Secret) {
+			metrics().ClientBadAuthenticator(addr, packet.Code)
+			packetErrorCount++
+			if client.MaxPacketErrors > 0 && packetErrorCount >= client.MaxPacketErrors {
+				return nil, &radius.NonAuthenticResponseError{}
+			}
+			continue
+		}
+
+		metrics().ClientResponse(addr, packet.Code, received.Code, time.Since(start))
+		return received, nil
+	}
+}
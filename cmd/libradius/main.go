@@ -0,0 +1,34 @@
+// Command libradius sends RADIUS requests like FreeRADIUS radclient:
+//
+//	echo 'User-Name = "bob"
+//	User-Password = "secret"' | libradius 127.0.0.1 auth testing123
+//
+// Attributes are read as `Name = value` lines from stdin or a file, a blank
+// line starts another request. It exits with 0 when every request is
+// accepted or acknowledged, 1 when one is rejected or NAKed, 2 on invalid
+// usage and 3 when one gets no valid response.
+//
+// With the serve subcommand it is a test server instead, answering
+// Access-Requests from a users file and logging accounting and, optionally,
+// CoA and Disconnect requests as JSON lines:
+//
+//	libradius serve -secret testing123 -users users -coa ack
+package main
+
+import (
+	"os"
+)
+
+const (
+	exitOK = iota
+	exitRejected
+	exitUsage
+	exitFailed
+)
+
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "serve" {
+		os.Exit(runServe(os.Args[2:]))
+	}
+	os.Exit(runSend(os.Args[1:]))
+}
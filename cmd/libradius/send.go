@@ -0,0 +1,378 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/wimark/libradius"
+	"layeh.com/radius"
+	"layeh.com/radius/dictionary"
+)
+
+type sendCommand struct {
+	code radius.Code
+	port string
+}
+
+var sendCommands = map[string]sendCommand{
+	"auth":       {radius.CodeAccessRequest, "1812"},
+	"acct":       {radius.CodeAccountingRequest, "1813"},
+	"status":     {radius.CodeStatusServer, "1812"},
+	"coa":        {radius.CodeCoARequest, "3799"},
+	"disconnect": {radius.CodeDisconnectRequest, "3799"},
+}
+
+type sender struct {
+	addr    string
+	code    radius.Code
+	secret  []byte
+	dict    *dictionary.Dictionary
+	retries int
+	timeout time.Duration
+
+	verbose bool
+	quiet   bool
+	json    bool
+
+	mu       sync.Mutex
+	out      io.Writer
+	accepted int
+	rejected int
+	failed   int
+}
+
+type sendResult struct {
+	Server   string                `json:"server"`
+	Request  libradius.JSONPacket  `json:"request"`
+	Response *libradius.JSONPacket `json:"response,omitempty"`
+	Duration time.Duration         `json:"duration"`
+	Error    string                `json:"error,omitempty"`
+}
+
+func runSend(args []string) int {
+	flags := flag.NewFlagSet("libradius", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: libradius [options] server[:port] {auth|acct|status|coa|disconnect} secret")
+		flags.PrintDefaults()
+	}
+
+	file := flags.String("f", "-", "file to read attributes from, - for stdin")
+	dicts := flags.String("d", "", "comma separated FreeRADIUS dictionaries added to the built-in one")
+	retries := flags.Int("r", 3, "attempts after a timeout")
+	timeout := flags.Duration("t", 3*time.Second, "timeout of every attempt")
+	retry := flags.Duration("i", time.Second, "retransmission interval within an attempt")
+	parallel := flags.Int("p", 1, "requests in flight")
+	count := flags.Int("c", 1, "times every request is sent")
+	verbose := flags.Bool("x", false, "print requests along with responses")
+	quiet := flags.Bool("q", false, "print nothing, only exit with the status")
+	jsonOut := flags.Bool("j", false, "print JSON lines")
+	summary := flags.Bool("s", false, "print a summary at the end")
+
+	if err := flags.Parse(args); err != nil {
+		return exitUsage
+	}
+	if flags.NArg() != 3 {
+		flags.Usage()
+		return exitUsage
+	}
+
+	command, ok := sendCommands[flags.Arg(1)]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command: %s\n", flags.Arg(1))
+		return exitUsage
+	}
+
+	addr := flags.Arg(0)
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, command.port)
+	}
+
+	dict, err := loadDictionaries(*dicts)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitUsage
+	}
+
+	var requests [][]string
+	if command.code == radius.CodeStatusServer && *file == "-" {
+		requests = [][]string{nil}
+	} else if requests, err = readRequests(*file); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitUsage
+	}
+
+	s := &sender{
+		addr:    addr,
+		code:    command.code,
+		secret:  []byte(flags.Arg(2)),
+		dict:    dict,
+		retries: *retries,
+		timeout: *timeout,
+		verbose: *verbose,
+		quiet:   *quiet,
+		json:    *jsonOut,
+		out:     os.Stdout,
+	}
+
+	// every request is checked before anything is sent
+	for _, lines := range requests {
+		if _, err := s.newPacket(lines); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitUsage
+		}
+	}
+
+	radius.DefaultClient.Retry = *retry
+
+	jobs := make(chan []string)
+	var wg sync.WaitGroup
+	for i := 0; i < *parallel || i == 0; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for lines := range jobs {
+				s.send(lines)
+			}
+		}()
+	}
+	for i := 0; i < *count; i++ {
+		for _, lines := range requests {
+			jobs <- lines
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	if *summary && !s.quiet {
+		fmt.Fprintf(os.Stderr, "accepted: %d, rejected: %d, failed: %d\n", s.accepted, s.rejected, s.failed)
+	}
+
+	switch {
+	case s.failed > 0:
+		return exitFailed
+	case s.rejected > 0:
+		return exitRejected
+	}
+	return exitOK
+}
+
+func loadDictionaries(files string) (*dictionary.Dictionary, error) {
+	dict := libradius.DefaultDictionary
+	if len(files) == 0 {
+		return dict, nil
+	}
+
+	for _, name := range strings.Split(files, ",") {
+		f, err := os.Open(name)
+		if err != nil {
+			return nil, err
+		}
+		parsed, err := libradius.ParseDictionary(name, f)
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+		if dict, err = dictionary.Merge(dict, parsed); err != nil {
+			return nil, fmt.Errorf("%s: %s", name, err)
+		}
+	}
+
+	return dict, nil
+}
+
+// readRequests reads the attribute lines of every request, the requests are
+// separated by blank lines and # starts a comment.
+func readRequests(name string) ([][]string, error) {
+	var r io.Reader = os.Stdin
+	if name != "-" {
+		f, err := os.Open(name)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	var requests [][]string
+	var lines []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case strings.HasPrefix(line, "#"):
+		case len(line) == 0:
+			if len(lines) > 0 {
+				requests = append(requests, lines)
+				lines = nil
+			}
+		default:
+			// radclient accepts attributes separated by commas as well
+			lines = append(lines, splitAttributes(line)...)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(lines) > 0 {
+		requests = append(requests, lines)
+	}
+	if len(requests) == 0 {
+		requests = [][]string{nil}
+	}
+
+	return requests, nil
+}
+
+// splitAttributes splits the line at the commas outside of quoted values.
+func splitAttributes(line string) []string {
+	var attrs []string
+	var quoted, escaped bool
+	start := 0
+	for i, c := range line {
+		switch {
+		case escaped:
+			escaped = false
+		case c == '\\' && quoted:
+			escaped = true
+		case c == '"':
+			quoted = !quoted
+		case c == ',' && !quoted:
+			attrs = append(attrs, strings.TrimSpace(line[start:i]))
+			start = i + 1
+		}
+	}
+	if attr := strings.TrimSpace(line[start:]); len(attr) > 0 {
+		attrs = append(attrs, attr)
+	}
+	return attrs
+}
+
+func (s *sender) newPacket(lines []string) (*radius.Packet, error) {
+	packet := radius.New(s.code, s.secret)
+	for _, line := range lines {
+		if err := libradius.ParseAttribute(packet, s.dict, line); err != nil {
+			return nil, err
+		}
+	}
+
+	// Status-Server requires a Message-Authenticator (RFC 5997), and
+	// Access-Requests get one against forged responses
+	if s.code == radius.CodeAccessRequest || s.code == radius.CodeStatusServer {
+		if err := libradius.AddMessageAuthenticator(packet); err != nil {
+			return nil, err
+		}
+	}
+
+	return packet, nil
+}
+
+func (s *sender) send(lines []string) {
+	packet, err := s.newPacket(lines)
+	if err != nil {
+		s.report(&sendResult{Server: s.addr, Request: s.jsonPacket(packet), Error: err.Error()}, nil, nil)
+		return
+	}
+
+	start := time.Now()
+	var response *radius.Packet
+	for attempt := 0; attempt <= s.retries; attempt++ {
+		if attempt > 0 {
+			// every attempt is a new request, with its own Identifier,
+			// Authenticator and so hidden attributes
+			if packet, err = s.newPacket(lines); err != nil {
+				break
+			}
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+		response, err = libradius.SendPacketContext(ctx, s.addr, packet)
+		cancel()
+		if err == nil || ctx.Err() == nil {
+			break
+		}
+	}
+
+	if err == nil && libradius.HasMessageAuthenticator(response) && !libradius.VerifyMessageAuthenticator(response, packet.Authenticator[:]) {
+		err = fmt.Errorf("invalid Message-Authenticator in %s", response.Code)
+	}
+
+	result := &sendResult{
+		Server:   s.addr,
+		Request:  s.jsonPacket(packet),
+		Duration: time.Since(start),
+	}
+	if err != nil {
+		result.Error = err.Error()
+		response = nil
+	} else {
+		jsonResponse := s.jsonPacket(response)
+		result.Response = &jsonResponse
+	}
+	s.report(result, packet, response)
+}
+
+func (s *sender) jsonPacket(p *radius.Packet) libradius.JSONPacket {
+	return libradius.JSONPacket{Packet: p, Dictionary: s.dict}
+}
+
+func (s *sender) report(result *sendResult, request, response *radius.Packet) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch {
+	case response == nil:
+		s.failed++
+	case isRejection(response.Code):
+		s.rejected++
+	default:
+		s.accepted++
+	}
+
+	if s.quiet {
+		return
+	}
+
+	if s.json {
+		b, err := json.Marshal(result)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return
+		}
+		fmt.Fprintln(s.out, string(b))
+		return
+	}
+
+	if s.verbose && request != nil {
+		fmt.Fprintf(s.out, "Sent to %s: %s", s.addr, libradius.Describe(request, s.dict))
+	}
+	if response == nil {
+		fmt.Fprintf(os.Stderr, "No reply from %s: %s\n", s.addr, result.Error)
+		return
+	}
+
+	// hidden attributes of responses are encrypted with the Authenticator of
+	// the request
+	decrypted := *response
+	decrypted.Authenticator = request.Authenticator
+	description := libradius.Describe(&decrypted, s.dict)
+	description.Authenticator = "0x" + hex.EncodeToString(response.Authenticator[:])
+	fmt.Fprintf(s.out, "Received from %s in %s: %s", s.addr, result.Duration.Round(time.Microsecond), description)
+}
+
+func isRejection(code radius.Code) bool {
+	switch code {
+	case radius.CodeAccessReject, radius.CodeCoANAK, radius.CodeDisconnectNAK:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/wimark/libradius"
+	"github.com/wimark/libradius/auth"
+	"gopkg.in/yaml.v3"
+	"layeh.com/radius"
+	"layeh.com/radius/rfc3576"
+)
+
+// serveConfig is read from the YAML file given by -config, flags set on the
+// command line take precedence.
+type serveConfig struct {
+	Listen   string `yaml:"listen"`
+	Secret   string `yaml:"secret"`
+	AuthPort string `yaml:"auth_port"`
+	AcctPort string `yaml:"acct_port"`
+	CoaPort  string `yaml:"coa_port"`
+	// Users is a FreeRADIUS users file, every Access-Request is rejected
+	// without one.
+	Users string `yaml:"users"`
+	// Log receives the JSON lines of accounting and dynamic authorization
+	// requests, - for stdout.
+	Log string `yaml:"log"`
+	// Coa answers CoA and Disconnect requests with ack or nak, they are not
+	// listened to when empty.
+	Coa           string `yaml:"coa"`
+	CoaErrorCause int    `yaml:"coa_error_cause"`
+}
+
+// serveEvent is a JSON line of the log.
+type serveEvent struct {
+	Time     time.Time            `json:"time"`
+	Type     string               `json:"type"`
+	Remote   string               `json:"remote"`
+	Packet   libradius.JSONPacket `json:"packet"`
+	Response string               `json:"response,omitempty"`
+}
+
+type eventLog struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+func (l *eventLog) write(event *serveEvent) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if err := l.enc.Encode(event); err != nil {
+		log.Printf("failed to log %s: %s", event.Type, err)
+	}
+}
+
+func runServe(args []string) int {
+	cfg := serveConfig{
+		Listen:        "0.0.0.0",
+		AuthPort:      "1812",
+		AcctPort:      "1813",
+		CoaPort:       "3799",
+		Log:           "-",
+		CoaErrorCause: int(rfc3576.ErrorCause_Value_SessionContextNotFound),
+	}
+
+	flags := flag.NewFlagSet("libradius serve", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: libradius serve [options]")
+		flags.PrintDefaults()
+	}
+
+	config := flags.String("config", "", "YAML configuration file")
+	flags.StringVar(&cfg.Listen, "listen", cfg.Listen, "address to listen on")
+	flags.StringVar(&cfg.Secret, "secret", cfg.Secret, "shared secret")
+	flags.StringVar(&cfg.AuthPort, "auth-port", cfg.AuthPort, "authentication port")
+	flags.StringVar(&cfg.AcctPort, "acct-port", cfg.AcctPort, "accounting port")
+	flags.StringVar(&cfg.CoaPort, "coa-port", cfg.CoaPort, "CoA and Disconnect port")
+	flags.StringVar(&cfg.Users, "users", cfg.Users, "FreeRADIUS users file")
+	flags.StringVar(&cfg.Log, "log", cfg.Log, "file to append JSON lines of requests to, - for stdout")
+	flags.StringVar(&cfg.Coa, "coa", cfg.Coa, "answer CoA and Disconnect requests with ack or nak")
+	flags.IntVar(&cfg.CoaErrorCause, "coa-error-cause", cfg.CoaErrorCause, "Error-Cause of NAKs")
+
+	if err := flags.Parse(args); err != nil {
+		return exitUsage
+	}
+	if flags.NArg() != 0 {
+		flags.Usage()
+		return exitUsage
+	}
+
+	if len(*config) > 0 {
+		if err := loadServeConfig(*config, &cfg, flags); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitUsage
+		}
+	}
+
+	if len(cfg.Secret) == 0 {
+		fmt.Fprintln(os.Stderr, "a secret is required")
+		return exitUsage
+	}
+	switch cfg.Coa {
+	case "", "ack", "nak":
+	default:
+		fmt.Fprintf(os.Stderr, "invalid coa answer: %s\n", cfg.Coa)
+		return exitUsage
+	}
+
+	store := auth.NewStaticUserStore()
+	if len(cfg.Users) > 0 {
+		var err error
+		if store, err = auth.LoadUsersFile(cfg.Users); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitUsage
+		}
+	}
+
+	var out io.Writer = os.Stdout
+	if cfg.Log != "-" {
+		f, err := os.OpenFile(cfg.Log, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitUsage
+		}
+		defer f.Close()
+		out = f
+	}
+	events := &eventLog{enc: json.NewEncoder(out)}
+
+	errs := make(chan error, 3)
+	run := func(port string, handler func(w radius.ResponseWriter, r *radius.Request)) {
+		serverCfg := libradius.NewRadiusServerConfig(cfg.Listen, port, cfg.Secret)
+		go func() {
+			errs <- fmt.Errorf("%s: %w", serverCfg.GetAddr(), libradius.ServerRun(serverCfg, handler))
+		}()
+	}
+
+	run(cfg.AuthPort, auth.NewHandler(store).ServeRADIUS)
+	run(cfg.AcctPort, accountingHandler(events))
+	if len(cfg.Coa) > 0 {
+		run(cfg.CoaPort, dynamicAuthHandler(events, &cfg))
+	}
+
+	log.Printf("serving %d users on %s", store.Len(), cfg.Listen)
+
+	log.Print(<-errs)
+	return exitFailed
+}
+
+// loadServeConfig reads the YAML file into cfg, except for the flags set on
+// the command line.
+func loadServeConfig(path string, cfg *serveConfig, flags *flag.FlagSet) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	set := make(map[string]string)
+	flags.Visit(func(f *flag.Flag) {
+		set[f.Name] = f.Value.String()
+	})
+
+	// a misspelt key is an error rather than a default silently kept
+	decoder := yaml.NewDecoder(bytes.NewReader(b))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(cfg); err != nil && err != io.EOF {
+		return fmt.Errorf("%s: %s", path, err)
+	}
+
+	for name, value := range set {
+		if err := flags.Set(name, value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func accountingHandler(events *eventLog) func(w radius.ResponseWriter, r *radius.Request) {
+	return func(w radius.ResponseWriter, r *radius.Request) {
+		if r.Code != radius.CodeAccountingRequest {
+			return
+		}
+
+		events.write(&serveEvent{
+			Time:   time.Now(),
+			Type:   "accounting",
+			Remote: r.RemoteAddr.String(),
+			Packet: libradius.JSONPacket{Packet: r.Packet},
+		})
+
+		w.Write(r.Response(radius.CodeAccountingResponse))
+	}
+}
+
+func dynamicAuthHandler(events *eventLog, cfg *serveConfig) func(w radius.ResponseWriter, r *radius.Request) {
+	server := libradius.NewDynamicAuthServer(func(_ context.Context, r *libradius.DynamicAuthRequest) error {
+		if cfg.Coa == "nak" {
+			return &libradius.DynamicAuthError{Cause: rfc3576.ErrorCause(cfg.CoaErrorCause)}
+		}
+		return nil
+	})
+
+	return func(w radius.ResponseWriter, r *radius.Request) {
+		writer := &recordingWriter{ResponseWriter: w}
+		server.ServeRADIUS(writer, r)
+
+		event := &serveEvent{
+			Time:   time.Now(),
+			Type:   "coa",
+			Remote: r.RemoteAddr.String(),
+			Packet: libradius.JSONPacket{Packet: r.Packet},
+		}
+		if r.Code == radius.CodeDisconnectRequest {
+			event.Type = "disconnect"
+		}
+		if writer.code != 0 {
+			event.Response = writer.code.String()
+		}
+		events.write(event)
+	}
+}
+
+// recordingWriter records the code of the response written.
+type recordingWriter struct {
+	radius.ResponseWriter
+	code radius.Code
+}
+
+func (w *recordingWriter) Write(p *radius.Packet) error {
+	w.code = p.Code
+	return w.ResponseWriter.Write(p)
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/wimark/libradius"
+	"layeh.com/radius"
+	"layeh.com/radius/rfc2865"
+	"layeh.com/radius/rfc2866"
+	"layeh.com/radius/rfc3576"
+)
+
+const testUsers = `alice	Cleartext-Password := "password12345678"
+	Session-Timeout = 3600
+`
+
+// freePorts returns n UDP ports free on the loopback address.
+func freePorts(t *testing.T, n int) []string {
+	t.Helper()
+
+	var ports []string
+	for i := 0; i < n; i++ {
+		conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		ports = append(ports, strconv.Itoa(conn.LocalAddr().(*net.UDPAddr).Port))
+	}
+	return ports
+}
+
+// waitForServer pings addr until it answers Status-Server.
+func waitForServer(t *testing.T, addr string) {
+	t.Helper()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		_, err := libradius.Ping(ctx, addr, "testing123")
+		cancel()
+		if err == nil {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%s is not answering: %v", addr, err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// readEvents returns the JSON lines of the log once it holds n of them.
+func readEvents(t *testing.T, path string, n int) []serveEvent {
+	t.Helper()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var events []serveEvent
+		scanner := bufio.NewScanner(bytes.NewReader(b))
+		for scanner.Scan() {
+			event := serveEvent{Packet: libradius.JSONPacket{Packet: &radius.Packet{}}}
+			if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+				t.Fatalf("%s: %v", scanner.Text(), err)
+			}
+			events = append(events, event)
+		}
+		if len(events) >= n {
+			return events
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d events logged, want %d", len(events), n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestServe(t *testing.T) {
+	dir := t.TempDir()
+	users := filepath.Join(dir, "users")
+	if err := os.WriteFile(users, []byte(testUsers), 0644); err != nil {
+		t.Fatal(err)
+	}
+	logPath := filepath.Join(dir, "events.log")
+
+	ports := freePorts(t, 3)
+	// runServe has no way to stop, its servers end with the test binary
+	go runServe([]string{
+		"-listen", "127.0.0.1",
+		"-secret", "testing123",
+		"-auth-port", ports[0],
+		"-acct-port", ports[1],
+		"-coa-port", ports[2],
+		"-users", users,
+		"-log", logPath,
+		"-coa", "nak",
+		"-coa-error-cause", strconv.Itoa(int(rfc3576.ErrorCause_Value_AdministrativelyProhibited)),
+	})
+	authAddr := net.JoinHostPort("127.0.0.1", ports[0])
+	acctAddr := net.JoinHostPort("127.0.0.1", ports[1])
+	coaAddr := net.JoinHostPort("127.0.0.1", ports[2])
+	for _, addr := range []string{authAddr, acctAddr, coaAddr} {
+		waitForServer(t, addr)
+	}
+
+	// a user of the users file is accepted with its reply items
+	request := radius.New(radius.CodeAccessRequest, []byte("testing123"))
+	rfc2865.UserName_SetString(request, "alice")
+	rfc2865.UserPassword_SetString(request, "password12345678")
+	response, err := libradius.SendPacket(authAddr, request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.Code != radius.CodeAccessAccept || rfc2865.SessionTimeout_Get(response) != 3600 {
+		t.Errorf("got %s with Session-Timeout %d", response.Code, rfc2865.SessionTimeout_Get(response))
+	}
+
+	request = radius.New(radius.CodeAccessRequest, []byte("testing123"))
+	rfc2865.UserName_SetString(request, "alice")
+	rfc2865.UserPassword_SetString(request, "wrong-password!!")
+	if response, err := libradius.SendPacket(authAddr, request); err != nil || response.Code != radius.CodeAccessReject {
+		t.Errorf("wrong password: got %v, %v", response, err)
+	}
+
+	// an accounting request is answered and logged
+	request = radius.New(radius.CodeAccountingRequest, []byte("testing123"))
+	rfc2866.AcctStatusType_Set(request, rfc2866.AcctStatusType_Value_Start)
+	rfc2866.AcctSessionID_SetString(request, "session")
+	if response, err := libradius.SendPacket(acctAddr, request); err != nil || response.Code != radius.CodeAccountingResponse {
+		t.Fatalf("accounting: got %v, %v", response, err)
+	}
+
+	// a CoA request is NAKed with the configured Error-Cause
+	err = libradius.SendCoAContext(context.Background(), coaAddr, "testing123", libradius.CoaRequest{AcctSessionID: "session"})
+	var nakErr *libradius.CoaNAKError
+	if !errors.As(err, &nakErr) || nakErr.Cause != rfc3576.ErrorCause_Value_AdministrativelyProhibited {
+		t.Fatalf("CoA: got %v", err)
+	}
+
+	events := readEvents(t, logPath, 2)
+	if len(events) != 2 {
+		t.Fatalf("%d events logged, want 2", len(events))
+	}
+	accounting, coa := events[0], events[1]
+	if accounting.Type != "accounting" || rfc2866.AcctSessionID_GetString(accounting.Packet.Packet) != "session" ||
+		rfc2866.AcctStatusType_Get(accounting.Packet.Packet) != rfc2866.AcctStatusType_Value_Start {
+		t.Errorf("accounting event %+v", accounting)
+	}
+	if coa.Type != "coa" || coa.Response != "CoA-NAK" || rfc2866.AcctSessionID_GetString(coa.Packet.Packet) != "session" {
+		t.Errorf("CoA event %+v", coa)
+	}
+}
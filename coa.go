@@ -1,6 +1,7 @@
 package libradius
 
 import (
+	"context"
 	"fmt"
 	"net"
 	"time"
@@ -9,14 +10,21 @@ import (
 	"layeh.com/radius/rfc2865"
 	"layeh.com/radius/rfc2866"
 	"layeh.com/radius/rfc2869"
+	"layeh.com/radius/rfc3576"
 )
 
 type CoaRequest struct {
 	FramedIPAddress string
 	AcctSessionID   string
-	SessionTimeout  int
-	IdleTimeout     int
-	VSAList         []VSAEntity
+	// SessionTimeout and IdleTimeout are in seconds, zero ones are not sent.
+	SessionTimeout int
+	IdleTimeout    int
+	VSAList        []VSAEntity
+
+	// EventTimestamp is sent instead of the current time, and
+	// OmitEventTimestamp leaves it out for NASes that reject it.
+	EventTimestamp     time.Time
+	OmitEventTimestamp bool
 }
 
 type VSAEntity struct {
@@ -26,14 +34,74 @@ type VSAEntity struct {
 	ValueInt    int
 }
 
+// CoaNAKError is returned when the NAS answers a CoA or Disconnect request
+// with anything but an ACK, usually a NAK with an Error-Cause.
+type CoaNAKError struct {
+	Code  radius.Code
+	Cause rfc3576.ErrorCause
+}
+
+func (e *CoaNAKError) Error() string {
+	if e.Cause != 0 {
+		return fmt.Sprintf("unexpected CoA response: %s (%d): %s", e.Code, e.Code, e.Cause)
+	}
+	return fmt.Sprintf("unexpected CoA response: %s (%d)", e.Code, e.Code)
+}
+
+// SendCoA sends a CoA-Request over UDP, Client.SendCoA sends it over TCP,
+// TLS or DTLS.
 func SendCoA(addr, secret string, request CoaRequest) error {
-	packet := radius.New(radius.CodeCoARequest, []byte(""))
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSendRadiusPacketTimeout)
+	defer cancel()
+
+	return SendCoAContext(ctx, addr, secret, request)
+}
+
+func SendCoAContext(ctx context.Context, addr, secret string, request CoaRequest) error {
+	return sendDynamicAuth(ctx, addr, newCoAPacket(radius.CodeCoARequest, secret, request))
+}
+
+// SendDisconnect sends a Disconnect-Request for the session over UDP, the
+// timeouts of the request are not sent. Client.SendDisconnect sends it over
+// the other transports.
+func SendDisconnect(ctx context.Context, addr, secret string, request CoaRequest) error {
+	return sendDynamicAuth(ctx, addr, newCoAPacket(radius.CodeDisconnectRequest, secret, request))
+}
+
+func sendDynamicAuth(ctx context.Context, addr string, packet *radius.Packet) error {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, defaultSendRadiusPacketTimeout)
+		defer cancel()
+	}
+
+	response, err := SendPacketContext(ctx, addr, packet)
+	if err != nil {
+		return err
+	}
+
+	return checkCoAResponse(packet, response)
+}
+
+func newCoAPacket(code radius.Code, secret string, request CoaRequest) *radius.Packet {
+	packet := radius.New(code, []byte(""))
 	packet.Secret = []byte(secret)
 	rfc2865.FramedIPAddress_Add(packet, net.ParseIP(request.FramedIPAddress))
 	rfc2866.AcctSessionID_AddString(packet, request.AcctSessionID)
-	rfc2869.EventTimestamp_Add(packet, time.Now())
-	rfc2865.IdleTimeout_Add(packet, rfc2865.IdleTimeout(request.IdleTimeout))
-	rfc2865.SessionTimeout_Add(packet, rfc2865.SessionTimeout(request.SessionTimeout))
+	if !request.OmitEventTimestamp {
+		timestamp := request.EventTimestamp
+		if timestamp.IsZero() {
+			timestamp = time.Now()
+		}
+		rfc2869.EventTimestamp_Add(packet, timestamp)
+	}
+	// a zero timeout would end the session on some NASes, so it is left out
+	if code == radius.CodeCoARequest && request.IdleTimeout > 0 {
+		rfc2865.IdleTimeout_Add(packet, rfc2865.IdleTimeout(request.IdleTimeout))
+	}
+	if code == radius.CodeCoARequest && request.SessionTimeout > 0 {
+		rfc2865.SessionTimeout_Add(packet, rfc2865.SessionTimeout(request.SessionTimeout))
+	}
 
 	for _, vsa := range request.VSAList {
 		if len(vsa.ValueString) > 0 {
@@ -43,13 +111,21 @@ func SendCoA(addr, secret string, request CoaRequest) error {
 		}
 	}
 
-	response, err := SendPacket(addr, packet)
-	if err != nil {
-		return err
+	return packet
+}
+
+func checkCoAResponse(request, response *radius.Packet) error {
+	ack := radius.CodeCoAACK
+	if request.Code == radius.CodeDisconnectRequest {
+		ack = radius.CodeDisconnectACK
 	}
 
-	if response != nil && response.Code != radius.CodeCoAACK {
-		return fmt.Errorf("unexpected CoA response: %s (%d)", response.Code, response.Code)
+	if response != nil && response.Code != ack {
+		nakErr := &CoaNAKError{Code: response.Code}
+		if cause, err := rfc3576.ErrorCause_Lookup(response); err == nil {
+			nakErr.Cause = cause
+		}
+		return nakErr
 	}
 
 	return nil
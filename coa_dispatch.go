@@ -0,0 +1,298 @@
+package libradius
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	defaultCoaConcurrency       = 64
+	defaultCoaPerNASConcurrency = 4
+	defaultCoaRetries           = 2
+	defaultCoaBackoff           = 500 * time.Millisecond
+	defaultCoaMaxBackoff        = 10 * time.Second
+)
+
+type CoaTarget struct {
+	Addr       string     `json:"addr"`
+	Secret     string     `json:"-"`
+	Disconnect bool       `json:"disconnect"`
+	Request    CoaRequest `json:"request"`
+}
+
+type CoaResult struct {
+	Target   CoaTarget     `json:"target"`
+	Attempts int           `json:"attempts"`
+	Duration time.Duration `json:"duration"`
+	Error    string        `json:"error,omitempty"`
+	Err      error         `json:"-"`
+}
+
+func (r *CoaResult) Acked() bool {
+	return r.Err == nil
+}
+
+// Naked tells a request the NAS refused, as opposed to one that failed.
+func (r *CoaResult) Naked() bool {
+	var nakErr *CoaNAKError
+	return errors.As(r.Err, &nakErr)
+}
+
+type CoaReport struct {
+	Results []CoaResult `json:"results"`
+	Acked   int         `json:"acked"`
+	Naked   int         `json:"naked"`
+	Failed  int         `json:"failed"`
+}
+
+type CoaDispatcherConfig struct {
+	// Concurrency bounds the requests in flight, PerNASConcurrency the ones
+	// in flight to a single NAS.
+	Concurrency       int `json:"concurrency"`
+	PerNASConcurrency int `json:"per_nas_concurrency"`
+	// Rate bounds the requests sent per second, 0 does not limit them.
+	Rate float64 `json:"rate"`
+	// Retries is how many times a request is sent again after a timeout,
+	// waiting Backoff before the first retry and twice as long before every
+	// next one, up to MaxBackoff. NAKs are never retried.
+	Retries    int           `json:"retries"`
+	Backoff    time.Duration `json:"backoff"`
+	MaxBackoff time.Duration `json:"max_backoff"`
+	// Timeout bounds each attempt.
+	Timeout time.Duration `json:"timeout"`
+}
+
+func NewCoaDispatcherConfig() *CoaDispatcherConfig {
+	return &CoaDispatcherConfig{
+		Concurrency:       defaultCoaConcurrency,
+		PerNASConcurrency: defaultCoaPerNASConcurrency,
+		Retries:           defaultCoaRetries,
+		Backoff:           defaultCoaBackoff,
+		MaxBackoff:        defaultCoaMaxBackoff,
+		Timeout:           defaultSendRadiusPacketTimeout,
+	}
+}
+
+// CoaDispatcher sends CoA and Disconnect requests to many sessions at once,
+// e.g. after a change of a WLAN policy.
+type CoaDispatcher struct {
+	cfg CoaDispatcherConfig
+
+	global chan struct{}
+
+	mu      sync.Mutex
+	nas     map[string]*nasSemaphore
+	nextAt  time.Time
+	limiter time.Duration
+}
+
+// nasSemaphore bounds the requests to a NAS, it is dropped once no request
+// holds or waits for it.
+type nasSemaphore struct {
+	slots chan struct{}
+	refs  int
+}
+
+func NewCoaDispatcher(cfg *CoaDispatcherConfig) *CoaDispatcher {
+	d := &CoaDispatcher{
+		cfg: *cfg,
+		nas: make(map[string]*nasSemaphore),
+	}
+
+	if d.cfg.Concurrency <= 0 {
+		d.cfg.Concurrency = defaultCoaConcurrency
+	}
+	if d.cfg.PerNASConcurrency <= 0 {
+		d.cfg.PerNASConcurrency = defaultCoaPerNASConcurrency
+	}
+	if d.cfg.Retries < 0 {
+		d.cfg.Retries = 0
+	}
+	if d.cfg.Backoff <= 0 {
+		d.cfg.Backoff = defaultCoaBackoff
+	}
+	if d.cfg.MaxBackoff <= 0 {
+		d.cfg.MaxBackoff = defaultCoaMaxBackoff
+	}
+	if d.cfg.Timeout <= 0 {
+		d.cfg.Timeout = defaultSendRadiusPacketTimeout
+	}
+	if d.cfg.Rate > 0 {
+		d.limiter = time.Duration(float64(time.Second) / d.cfg.Rate)
+	}
+
+	d.global = make(chan struct{}, d.cfg.Concurrency)
+
+	return d
+}
+
+func sendCoaTarget(ctx context.Context, target CoaTarget) error {
+	if target.Disconnect {
+		return SendDisconnect(ctx, target.Addr, target.Secret, target.Request)
+	}
+	return SendCoAContext(ctx, target.Addr, target.Secret, target.Request)
+}
+
+// Dispatch sends a request to every target and reports the results in the
+// order of targets. Targets not sent before ctx is done fail with its error.
+func (d *CoaDispatcher) Dispatch(ctx context.Context, targets []CoaTarget) *CoaReport {
+	report := &CoaReport{
+		Results: make([]CoaResult, len(targets)),
+	}
+
+	var wg sync.WaitGroup
+	for i := range targets {
+		wg.Add(1)
+		go func(result *CoaResult, target CoaTarget) {
+			defer wg.Done()
+			d.dispatch(ctx, result, target)
+		}(&report.Results[i], targets[i])
+	}
+	wg.Wait()
+
+	for i := range report.Results {
+		result := &report.Results[i]
+		switch {
+		case result.Acked():
+			report.Acked++
+		case result.Naked():
+			report.Naked++
+		default:
+			report.Failed++
+		}
+	}
+
+	return report
+}
+
+func (d *CoaDispatcher) dispatch(ctx context.Context, result *CoaResult, target CoaTarget) {
+	start := time.Now()
+	defer func() {
+		result.Target = target
+		result.Duration = time.Since(start)
+		if result.Err != nil {
+			result.Error = result.Err.Error()
+		}
+	}()
+
+	backoff := d.cfg.Backoff
+	for {
+		var sent bool
+		sent, result.Err = d.attempt(ctx, target)
+		if sent {
+			result.Attempts++
+		}
+
+		if result.Err == nil || !sent || !isCoaTimeout(result.Err) || result.Attempts > d.cfg.Retries || ctx.Err() != nil {
+			return
+		}
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		if backoff *= 2; backoff > d.cfg.MaxBackoff {
+			backoff = d.cfg.MaxBackoff
+		}
+	}
+}
+
+// attempt sends the request once the limits allow it, sent is false when ctx
+// was done before.
+func (d *CoaDispatcher) attempt(ctx context.Context, target CoaTarget) (sent bool, err error) {
+	nas := d.acquireNAS(target.Addr)
+	defer d.releaseNAS(target.Addr)
+
+	select {
+	case nas.slots <- struct{}{}:
+	case <-ctx.Done():
+		return false, ctx.Err()
+	}
+	defer func() { <-nas.slots }()
+
+	select {
+	case d.global <- struct{}{}:
+	case <-ctx.Done():
+		return false, ctx.Err()
+	}
+	defer func() { <-d.global }()
+
+	if err := d.wait(ctx); err != nil {
+		return false, err
+	}
+
+	attemptCtx, cancel := context.WithTimeout(ctx, d.cfg.Timeout)
+	defer cancel()
+
+	return true, sendCoaTarget(attemptCtx, target)
+}
+
+func (d *CoaDispatcher) acquireNAS(addr string) *nasSemaphore {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	nas, ok := d.nas[addr]
+	if !ok {
+		nas = &nasSemaphore{slots: make(chan struct{}, d.cfg.PerNASConcurrency)}
+		d.nas[addr] = nas
+	}
+	nas.refs++
+	return nas
+}
+
+func (d *CoaDispatcher) releaseNAS(addr string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	nas := d.nas[addr]
+	nas.refs--
+	if nas.refs == 0 {
+		delete(d.nas, addr)
+	}
+}
+
+// wait spaces the requests by the configured rate.
+func (d *CoaDispatcher) wait(ctx context.Context) error {
+	if d.limiter == 0 {
+		return nil
+	}
+
+	d.mu.Lock()
+	now := time.Now()
+	at := d.nextAt
+	if at.Before(now) {
+		at = now
+	}
+	d.nextAt = at.Add(d.limiter)
+	d.mu.Unlock()
+
+	delay := time.Until(at)
+	if delay <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+func isCoaTimeout(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
@@ -0,0 +1,178 @@
+package libradius
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"layeh.com/radius"
+	"layeh.com/radius/rfc3576"
+)
+
+// countingNAS answers CoA requests with code and cause after a delay, or
+// not at all when code is 0, and records how many of them were in flight at
+// once.
+type countingNAS struct {
+	addr  string
+	delay time.Duration
+	code  radius.Code
+	cause rfc3576.ErrorCause
+
+	mu          sync.Mutex
+	inFlight    int
+	maxInFlight int
+	received    int
+}
+
+func startCountingNAS(t *testing.T, delay time.Duration, code radius.Code, cause rfc3576.ErrorCause) *countingNAS {
+	t.Helper()
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	nas := &countingNAS{
+		addr:  conn.LocalAddr().String(),
+		delay: delay,
+		code:  code,
+		cause: cause,
+	}
+	server := &radius.PacketServer{
+		SecretSource: radius.StaticSecretSource([]byte("testing123")),
+		Handler:      nas,
+	}
+	go server.Serve(conn)
+	t.Cleanup(func() { server.Shutdown(context.Background()) })
+
+	return nas
+}
+
+func (n *countingNAS) ServeRADIUS(w radius.ResponseWriter, r *radius.Request) {
+	n.mu.Lock()
+	n.received++
+	n.inFlight++
+	if n.inFlight > n.maxInFlight {
+		n.maxInFlight = n.inFlight
+	}
+	n.mu.Unlock()
+
+	time.Sleep(n.delay)
+
+	n.mu.Lock()
+	n.inFlight--
+	n.mu.Unlock()
+
+	if n.code == 0 {
+		return
+	}
+	response := r.Response(n.code)
+	if n.cause != 0 {
+		rfc3576.ErrorCause_Set(response, n.cause)
+	}
+	w.Write(response)
+}
+
+func (n *countingNAS) stats() (received, maxInFlight int) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.received, n.maxInFlight
+}
+
+func coaTargets(addr string, n int) []CoaTarget {
+	targets := make([]CoaTarget, n)
+	for i := range targets {
+		targets[i] = CoaTarget{
+			Addr:    addr,
+			Secret:  "testing123",
+			Request: CoaRequest{AcctSessionID: "session"},
+		}
+	}
+	return targets
+}
+
+func TestCoaDispatcherConcurrency(t *testing.T) {
+	first := startCountingNAS(t, 20*time.Millisecond, radius.CodeCoAACK, 0)
+	second := startCountingNAS(t, 20*time.Millisecond, radius.CodeCoAACK, 0)
+
+	d := NewCoaDispatcher(&CoaDispatcherConfig{Concurrency: 3, PerNASConcurrency: 2})
+	targets := append(coaTargets(first.addr, 10), coaTargets(second.addr, 10)...)
+	report := d.Dispatch(context.Background(), targets)
+
+	if report.Acked != len(targets) || report.Naked != 0 || report.Failed != 0 {
+		t.Fatalf("%d acked, %d naked, %d failed", report.Acked, report.Naked, report.Failed)
+	}
+	for i, nas := range []*countingNAS{first, second} {
+		received, maxInFlight := nas.stats()
+		if received != 10 {
+			t.Errorf("NAS %d received %d requests, want 10", i, received)
+		}
+		if maxInFlight > 2 {
+			t.Errorf("NAS %d had %d requests in flight, want at most 2", i, maxInFlight)
+		}
+	}
+
+	// the semaphores of idle NASes are not kept
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if len(d.nas) != 0 {
+		t.Errorf("%d NAS semaphores left after the dispatch", len(d.nas))
+	}
+}
+
+func TestCoaDispatcherRate(t *testing.T) {
+	nas := startCountingNAS(t, 0, radius.CodeCoAACK, 0)
+
+	d := NewCoaDispatcher(&CoaDispatcherConfig{Rate: 20})
+	start := time.Now()
+	report := d.Dispatch(context.Background(), coaTargets(nas.addr, 5))
+	elapsed := time.Since(start)
+
+	if report.Acked != 5 {
+		t.Fatalf("%d acked, want 5", report.Acked)
+	}
+	// 5 requests at 20 per second are spread over at least 200ms
+	if elapsed < 190*time.Millisecond {
+		t.Errorf("5 requests sent in %s at 20 per second", elapsed)
+	}
+}
+
+func TestCoaDispatcherRetries(t *testing.T) {
+	silent := startCountingNAS(t, 0, 0, 0)
+	nak := startCountingNAS(t, 0, radius.CodeCoANAK, rfc3576.ErrorCause_Value_SessionContextNotFound)
+
+	d := NewCoaDispatcher(&CoaDispatcherConfig{
+		Retries: 2,
+		Backoff: 10 * time.Millisecond,
+		Timeout: 50 * time.Millisecond,
+	})
+	report := d.Dispatch(context.Background(), append(coaTargets(silent.addr, 1), coaTargets(nak.addr, 1)...))
+
+	// a timeout is retried
+	timedOut := report.Results[0]
+	if timedOut.Attempts != 3 || !isCoaTimeout(timedOut.Err) {
+		t.Errorf("silent NAS: %d attempts, %v", timedOut.Attempts, timedOut.Err)
+	}
+	if received, _ := silent.stats(); received < 3 {
+		t.Errorf("silent NAS received %d requests, want 3", received)
+	}
+
+	// a NAK is not
+	naked := report.Results[1]
+	var nakErr *CoaNAKError
+	if !errors.As(naked.Err, &nakErr) || nakErr.Code != radius.CodeCoANAK || nakErr.Cause != rfc3576.ErrorCause_Value_SessionContextNotFound {
+		t.Errorf("NAK: got %v", naked.Err)
+	}
+	if naked.Attempts != 1 || !naked.Naked() {
+		t.Errorf("NAK: %d attempts", naked.Attempts)
+	}
+	if received, _ := nak.stats(); received != 1 {
+		t.Errorf("NAK NAS received %d requests, want 1", received)
+	}
+
+	if report.Acked != 0 || report.Naked != 1 || report.Failed != 1 {
+		t.Errorf("%d acked, %d naked, %d failed", report.Acked, report.Naked, report.Failed)
+	}
+}
@@ -0,0 +1,341 @@
+package libradius
+
+import (
+	_ "embed"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"layeh.com/radius"
+	"layeh.com/radius/debug"
+	"layeh.com/radius/dictionary"
+	"layeh.com/radius/rfc2865"
+)
+
+//go:embed dictionary.wimark
+var wimarkDictionary string
+
+//go:embed dictionary.rfc2868
+var tunnelDictionary string
+
+// the vendor attributes decoded by DecodeAVPairsVSA and the Microsoft ones
+// of MS-CHAP and EAP replies
+const vendorDictionary = `
+VENDOR		Cisco		9
+BEGIN-VENDOR	Cisco
+ATTRIBUTE	Cisco-AVPair		1	string
+ATTRIBUTE	Cisco-Account-Info	250	string
+ATTRIBUTE	Cisco-Command-Code	252	octets
+END-VENDOR	Cisco
+
+VENDOR		Airspace	14179
+BEGIN-VENDOR	Airspace
+ATTRIBUTE	Airspace-ACL-Name	6	string
+END-VENDOR	Airspace
+
+VENDOR		Microsoft	311
+BEGIN-VENDOR	Microsoft
+ATTRIBUTE	MS-CHAP-Response		1	octets[50]
+ATTRIBUTE	MS-CHAP-Error			2	string
+ATTRIBUTE	MS-MPPE-Encryption-Policy	7	integer
+ATTRIBUTE	MS-MPPE-Encryption-Types	8	integer
+ATTRIBUTE	MS-CHAP-Challenge		11	octets
+ATTRIBUTE	MS-CHAP-MPPE-Keys		12	octets[24]	encrypt=1
+ATTRIBUTE	MS-MPPE-Send-Key		16	octets	encrypt=2
+ATTRIBUTE	MS-MPPE-Recv-Key		17	octets	encrypt=2
+ATTRIBUTE	MS-CHAP2-Response		25	octets[50]
+ATTRIBUTE	MS-CHAP2-Success		26	octets
+
+VALUE	MS-MPPE-Encryption-Policy	Encryption-Allowed	1
+VALUE	MS-MPPE-Encryption-Policy	Encryption-Required	2
+
+VALUE	MS-MPPE-Encryption-Types	RC4-40bit-Allowed	1
+VALUE	MS-MPPE-Encryption-Types	RC4-128bit-Allowed	2
+VALUE	MS-MPPE-Encryption-Types	RC4-40or128-bit-Allowed	6
+END-VENDOR	Microsoft
+`
+
+// DefaultDictionary holds the RFC attributes, the tunnel ones of RFC 2868
+// included, along with the Wimark, Cisco, Airspace and Microsoft ones.
+var DefaultDictionary = newDefaultDictionary()
+
+type dictionaryFile struct {
+	io.Reader
+	name string
+}
+
+func (f *dictionaryFile) Name() string { return f.name }
+func (f *dictionaryFile) Close() error { return nil }
+
+// ParseDictionary parses a FreeRADIUS dictionary, $INCLUDE directives are
+// resolved relative to the working directory.
+func ParseDictionary(name string, r io.Reader) (*dictionary.Dictionary, error) {
+	parser := &dictionary.Parser{
+		Opener:                    &dictionary.FileSystemOpener{},
+		IgnoreIdenticalAttributes: true,
+	}
+	return parser.Parse(&dictionaryFile{Reader: r, name: name})
+}
+
+func newDefaultDictionary() *dictionary.Dictionary {
+	dict := debug.IncludedDictionary
+	for _, file := range []struct{ name, text string }{
+		{"dictionary.rfc2868", tunnelDictionary},
+		{"dictionary.wimark", wimarkDictionary},
+		{"dictionary.vendors", vendorDictionary},
+	} {
+		vendors, err := ParseDictionary(file.name, strings.NewReader(file.text))
+		if err == nil {
+			dict, err = dictionary.Merge(dict, vendors)
+		}
+		if err != nil {
+			panic(fmt.Sprintf("libradius: %s: %s", file.name, err))
+		}
+	}
+	return dict
+}
+
+// PacketDescription is a packet decoded with a dictionary, for logs and
+// debugging. JSONPacket is its JSON form.
+type PacketDescription struct {
+	Code          string
+	Identifier    uint8
+	Authenticator string
+	Attributes    []AttributeDescription
+}
+
+// AttributeDescription is an attribute, a Vendor-Specific attribute is
+// described by each of the attributes it carries.
+type AttributeDescription struct {
+	Name   string
+	Vendor uint32
+	Type   uint8
+	Tag    *uint8
+	Value  string
+}
+
+func (a *AttributeDescription) String() string {
+	name := a.Name
+	if a.Tag != nil {
+		name += ":" + strconv.Itoa(int(*a.Tag))
+	}
+	return name + " = " + a.Value
+}
+
+// String renders the packet like radclient -x does.
+func (d *PacketDescription) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s Id %d Authenticator %s\n", d.Code, d.Identifier, d.Authenticator)
+	for i := range d.Attributes {
+		b.WriteString("\t")
+		b.WriteString(d.Attributes[i].String())
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// Describe decodes the attributes of the packet with dict, DefaultDictionary
+// when nil. Hidden attributes are decrypted when the packet has its secret,
+// which for responses needs the Authenticator of the request in place of the
+// one of the packet.
+func Describe(p *radius.Packet, dict *dictionary.Dictionary) *PacketDescription {
+	if dict == nil {
+		dict = DefaultDictionary
+	}
+
+	d := &PacketDescription{
+		Code:          p.Code.String(),
+		Identifier:    p.Identifier,
+		Authenticator: "0x" + hex.EncodeToString(p.Authenticator[:]),
+		Attributes:    make([]AttributeDescription, 0, len(p.Attributes)),
+	}
+
+	for _, a := range dictAttributes(p, dict) {
+		description := AttributeDescription{
+			Name:   a.name,
+			Vendor: a.vendor,
+			Type:   a.typ,
+			Tag:    a.tag,
+		}
+		if a.attr == nil {
+			description.Value = "0x" + hex.EncodeToString(a.value)
+		} else {
+			description.Value = describeValue(p, a.attr, a.values, a.value)
+		}
+		d.Attributes = append(d.Attributes, description)
+	}
+
+	return d
+}
+
+// dictAttribute is an attribute of a packet along with its definition, which
+// is nil for the ones missing from the dictionary.
+type dictAttribute struct {
+	name   string
+	vendor uint32
+	typ    uint8
+	tag    *uint8
+	attr   *dictionary.Attribute
+	values []*dictionary.Value
+	// value is without the tag, which is zeroed in tagged integers
+	value radius.Attribute
+}
+
+func dictAttributes(p *radius.Packet, dict *dictionary.Dictionary) []dictAttribute {
+	attrs := make([]dictAttribute, 0, len(p.Attributes))
+	for _, avp := range p.Attributes {
+		if avp.Type == rfc2865.VendorSpecific_Type {
+			if vsas, ok := splitVendorSpecific(dict, avp.Attribute); ok {
+				attrs = append(attrs, vsas...)
+				continue
+			}
+		}
+
+		attr := dictionary.AttributeByOID(dict.Attributes, dictionary.OID{int(avp.Type)})
+		attrs = append(attrs, newDictAttribute(attr, dict.Values, "Attr-"+strconv.Itoa(int(avp.Type)), 0, uint8(avp.Type), avp.Attribute))
+	}
+	return attrs
+}
+
+// splitVendorSpecific splits the attribute into the ones of its vendor, ok is
+// false when it does not hold valid Type-Length-Value ones.
+func splitVendorSpecific(dict *dictionary.Dictionary, a radius.Attribute) ([]dictAttribute, bool) {
+	vendorID, value, err := radius.VendorSpecific(a)
+	if err != nil {
+		return nil, false
+	}
+
+	vendor := dictionary.VendorByNumber(dict.Vendors, int(vendorID))
+	if vendor != nil && (vendor.GetTypeOctets() != 1 || vendor.GetLengthOctets() != 1) {
+		return nil, false
+	}
+
+	var vsas []dictAttribute
+	for len(value) > 0 {
+		if len(value) < 2 || int(value[1]) < 2 || int(value[1]) > len(value) {
+			return nil, false
+		}
+		typ, data := value[0], value[2:value[1]]
+		value = value[value[1]:]
+
+		var attr *dictionary.Attribute
+		var values []*dictionary.Value
+		if vendor != nil {
+			attr = dictionary.AttributeByOID(vendor.Attributes, dictionary.OID{int(typ)})
+			values = vendor.Values
+		}
+		unknown := fmt.Sprintf("Attr-26.%d.%d", vendorID, typ)
+		vsas = append(vsas, newDictAttribute(attr, values, unknown, vendorID, typ, data))
+	}
+
+	return vsas, true
+}
+
+func newDictAttribute(attr *dictionary.Attribute, values []*dictionary.Value, unknown string, vendor uint32, typ uint8, a radius.Attribute) dictAttribute {
+	d := dictAttribute{
+		name:   unknown,
+		vendor: vendor,
+		typ:    typ,
+		attr:   attr,
+		values: values,
+		value:  a,
+	}
+	if attr == nil {
+		return d
+	}
+	d.name = attr.Name
+
+	if attr.HasTag() && len(a) > 0 && a[0] <= 0x1F {
+		// RFC 2868, section 3.5: the tag of Tunnel-Password is not optional
+		if a[0] > 0 || attr.FlagEncrypt.Int == dictionary.EncryptTunnelPassword {
+			tag := a[0]
+			d.tag = &tag
+			if attr.Type == dictionary.AttributeInteger {
+				d.value = append(radius.Attribute{0}, a[1:]...)
+			} else {
+				d.value = a[1:]
+			}
+		}
+	}
+
+	return d
+}
+
+func describeValue(p *radius.Packet, attr *dictionary.Attribute, values []*dictionary.Value, a radius.Attribute) string {
+	if attr.FlagEncrypt.Valid {
+		switch secret := p.Secret; {
+		case len(secret) == 0:
+		case attr.FlagEncrypt.Int == dictionary.EncryptUserPassword:
+			if plaintext, err := radius.UserPassword(a, secret, p.Authenticator[:]); err == nil {
+				return strconv.Quote(string(plaintext))
+			}
+		case attr.FlagEncrypt.Int == dictionary.EncryptTunnelPassword:
+			if plaintext, _, err := radius.TunnelPassword(a, secret, p.Authenticator[:]); err == nil {
+				return strconv.Quote(string(plaintext))
+			}
+		}
+		return "0x" + hex.EncodeToString(a)
+	}
+
+	switch attr.Type {
+	case dictionary.AttributeString:
+		return strconv.Quote(string(a))
+	case dictionary.AttributeInteger:
+		if i, err := radius.Integer(a); err == nil {
+			var names []string
+			for _, value := range dictionary.ValuesByAttribute(values, attr.Name) {
+				if value.Number == uint64(i) {
+					names = append(names, value.Name)
+				}
+			}
+			if len(names) > 0 {
+				sort.Strings(names)
+				return strings.Join(names, " / ")
+			}
+			return strconv.FormatUint(uint64(i), 10)
+		}
+	case dictionary.AttributeInteger64:
+		if i, err := radius.Integer64(a); err == nil {
+			return strconv.FormatUint(i, 10)
+		}
+	case dictionary.AttributeDate:
+		if t, err := radius.Date(a); err == nil {
+			return t.UTC().Format(time.RFC3339)
+		}
+	case dictionary.AttributeIPAddr:
+		if ip, err := radius.IPAddr(a); err == nil {
+			return ip.String()
+		}
+	case dictionary.AttributeIPv6Addr:
+		if ip, err := radius.IPv6Addr(a); err == nil {
+			return ip.String()
+		}
+	case dictionary.AttributeIPv6Prefix:
+		if prefix, err := radius.IPv6Prefix(a); err == nil {
+			return prefix.String()
+		}
+	case dictionary.AttributeIFID:
+		if id, err := radius.IFID(a); err == nil {
+			return id.String()
+		}
+	case dictionary.AttributeEther:
+		if len(a) == 6 {
+			return net.HardwareAddr(a).String()
+		}
+	case dictionary.AttributeShort:
+		if i, err := radius.Short(a); err == nil {
+			return strconv.FormatUint(uint64(i), 10)
+		}
+	case dictionary.AttributeByte:
+		if len(a) == 1 {
+			return strconv.Itoa(int(a[0]))
+		}
+	}
+
+	return "0x" + hex.EncodeToString(a)
+}
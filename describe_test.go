@@ -0,0 +1,66 @@
+package libradius
+
+import (
+	"strings"
+	"testing"
+
+	"layeh.com/radius"
+	"layeh.com/radius/rfc2865"
+	"layeh.com/radius/rfc2866"
+	"layeh.com/radius/rfc2868"
+)
+
+func TestDescribe(t *testing.T) {
+	p := &radius.Packet{
+		Code:       radius.CodeAccessRequest,
+		Identifier: 7,
+		Secret:     []byte("testing123"),
+	}
+	copy(p.Authenticator[:], "0123456789abcdef")
+
+	rfc2865.UserName_SetString(p, "bob")
+	rfc2865.UserPassword_SetString(p, "password12345678")
+	rfc2865.NASIPAddress_Set(p, []byte{10, 0, 0, 1})
+	rfc2866.AcctStatusType_Set(p, rfc2866.AcctStatusType_Value_InterimUpdate)
+	rfc2868.TunnelType_Set(p, 1, 13)
+	rfc2868.TunnelPrivateGroupID_SetString(p, 1, "100")
+	rfc2868.TunnelPassword_SetString(p, 0, "tunnel")
+	vsa, err := radius.NewVendorSpecific(VendorWimark, radius.Attribute{3, 8, 's', 't', 'a', 'f', 'f', 's', 4, 6, 0, 0, 0x0e, 0x10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Add(rfc2865.VendorSpecific_Type, vsa)
+	p.Add(200, radius.Attribute{1, 2, 3})
+
+	want := "Access-Request Id 7 Authenticator 0x30313233343536373839616263646566\n" +
+		"\tUser-Name = \"bob\"\n" +
+		"\tUser-Password = \"password12345678\"\n" +
+		"\tNAS-IP-Address = 10.0.0.1\n" +
+		"\tAcct-Status-Type = Alive / Interim-Update\n" +
+		"\tTunnel-Type:1 = VLAN\n" +
+		"\tTunnel-Private-Group-Id:1 = \"100\"\n" +
+		"\tTunnel-Password:0 = \"tunnel\"\n" +
+		"\tWimark-Client-Group = \"staffs\"\n" +
+		"\tWimark-Session-Timeout = 3600\n" +
+		"\tAttr-200 = 0x010203\n"
+	if got := Describe(p, nil).String(); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+
+	// without the secret hidden attributes stay encrypted
+	p.Secret = nil
+	d := Describe(p, nil)
+	for _, a := range d.Attributes {
+		if a.Name == "User-Password" || a.Name == "Tunnel-Password" {
+			if !strings.HasPrefix(a.Value, "0x") {
+				t.Errorf("%s = %s without the secret", a.Name, a.Value)
+			}
+		}
+	}
+	// the Vendor-Specific attribute is described by each of its attributes
+	for i, typ := range []uint8{3, 4} {
+		if vsa := d.Attributes[7+i]; vsa.Vendor != VendorWimark || vsa.Type != typ {
+			t.Errorf("vendor attribute %d is %+v", i, vsa)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package libradius
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+
+	"github.com/pion/dtls/v2"
+	"github.com/pion/transport/v2/udp"
+)
+
+const (
+	// RFC 7360, section 2.1: the shared secret of RADIUS/DTLS
+	dtlsSecret = "radius/dtls"
+
+	dtlsContentTypeHandshake = 22
+)
+
+// newDTLSConfig converts the TLS configuration of a server or client, so that
+// DTLS is configured like TLS.
+func newDTLSConfig(cfg *tls.Config) *dtls.Config {
+	if cfg == nil {
+		cfg = &tls.Config{}
+	}
+
+	return &dtls.Config{
+		Certificates:          cfg.Certificates,
+		ClientAuth:            dtls.ClientAuthType(cfg.ClientAuth),
+		ClientCAs:             cfg.ClientCAs,
+		RootCAs:               cfg.RootCAs,
+		ServerName:            cfg.ServerName,
+		InsecureSkipVerify:    cfg.InsecureSkipVerify,
+		VerifyPeerCertificate: cfg.VerifyPeerCertificate,
+		ExtendedMasterSecret:  dtls.RequireExtendedMasterSecret,
+		ConnectContextMaker: func() (context.Context, func()) {
+			return context.WithTimeout(context.Background(), defaultStreamHandshakeTimeout)
+		},
+	}
+}
+
+// listenDTLS accepts a connection for every peer starting a handshake, the
+// handshake itself is left to the server goroutine of the connection.
+func listenDTLS(addr string) (net.Listener, error) {
+	laddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	lc := udp.ListenConfig{
+		AcceptFilter: func(packet []byte) bool {
+			return len(packet) > 0 && packet[0] == dtlsContentTypeHandshake
+		},
+	}
+	return lc.Listen("udp", laddr)
+}
+
+func dialDTLS(ctx context.Context, addr string, cfg *tls.Config) (net.Conn, error) {
+	raddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	return dtls.DialWithContext(ctx, "udp", raddr, newDTLSConfig(cfg))
+}
+
+func (s *StreamServer) handshakeDTLS(conn net.Conn) (net.Conn, *StreamPeer, error) {
+	ctx, cancel := context.WithTimeout(s.ctx, defaultStreamHandshakeTimeout)
+	defer cancel()
+
+	dtlsConn, err := dtls.ServerWithContext(ctx, conn, s.DTLSConfig)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	peer := &StreamPeer{}
+	for _, raw := range dtlsConn.ConnectionState().PeerCertificates {
+		cert, err := x509.ParseCertificate(raw)
+		if err != nil {
+			dtlsConn.Close()
+			return nil, nil, err
+		}
+		peer.Certificates = append(peer.Certificates, cert)
+	}
+
+	if err := s.identify(peer); err != nil {
+		dtlsConn.Close()
+		return nil, nil, err
+	}
+
+	return dtlsConn, peer, nil
+}
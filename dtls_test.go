@@ -0,0 +1,45 @@
+package libradius
+
+import (
+	"testing"
+
+	"layeh.com/radius"
+	"layeh.com/radius/rfc2865"
+)
+
+func TestDTLSLoopback(t *testing.T) {
+	s := newStreamTest(t)
+
+	tests := []struct {
+		name string
+		cert bool
+		nas  string
+	}{
+		{"DTLS", true, "nas.example.com"},
+		{"DTLS without client certificate", false, ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			addr := s.serve(t, TransportDTLS, "")
+
+			response, err := exchangeStream(t, addr, "", s.clientConfig(TransportDTLS, test.cert))
+			if len(test.nas) == 0 {
+				if err == nil {
+					t.Fatalf("got %s from a server requiring a client certificate", response.Code)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if response.Code != radius.CodeAccessAccept {
+				t.Fatalf("got %s", response.Code)
+			}
+			if nas := rfc2865.ReplyMessage_GetString(response); nas != test.nas {
+				t.Errorf("NAS = %q, want %q", nas, test.nas)
+			}
+		})
+	}
+}
@@ -0,0 +1,366 @@
+package libradius
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"layeh.com/radius"
+	"layeh.com/radius/rfc2865"
+	"layeh.com/radius/rfc2866"
+	"layeh.com/radius/rfc2869"
+	"layeh.com/radius/rfc3576"
+)
+
+const (
+	// RFC 5176 recommends a window of 300 seconds
+	defaultEventTimestampWindow = 300 * time.Second
+)
+
+// DynamicAuthError is returned by a DynamicAuthHandler to answer with a NAK
+// carrying its Error-Cause.
+type DynamicAuthError struct {
+	Cause   rfc3576.ErrorCause
+	Message string
+}
+
+func (e *DynamicAuthError) Error() string {
+	if len(e.Message) > 0 {
+		return fmt.Sprintf("%s: %s", e.Cause, e.Message)
+	}
+	return e.Cause.String()
+}
+
+var (
+	ErrSessionContextNotFound     = &DynamicAuthError{Cause: rfc3576.ErrorCause_Value_SessionContextNotFound}
+	ErrSessionContextNotRemovable = &DynamicAuthError{Cause: rfc3576.ErrorCause_Value_SessionContextNotRemovable}
+	ErrAdministrativelyProhibited = &DynamicAuthError{Cause: rfc3576.ErrorCause_Value_AdministrativelyProhibited}
+	ErrUnsupportedAttribute       = &DynamicAuthError{Cause: rfc3576.ErrorCause_Value_UnsupportedAttribute}
+)
+
+// DynamicAuthRequest is a received CoA-Request or Disconnect-Request.
+type DynamicAuthRequest struct {
+	Code radius.Code
+	CoaRequest
+
+	UserName         string
+	NASIPAddress     net.IP
+	NASIdentifier    string
+	CallingStationID string
+	EventTimestamp   time.Time
+
+	Wimark   WimarkAVPs
+	Cisco    CiscoAVPs
+	Airspace AirspaceAVPs
+
+	Packet     *radius.Packet
+	RemoteAddr net.Addr
+}
+
+// DynamicAuthHandler applies a request to the sessions of the NAS. A nil
+// error answers with an ACK, a DynamicAuthError with a NAK carrying its
+// Error-Cause and other errors with Resources-Unavailable.
+type DynamicAuthHandler func(ctx context.Context, request *DynamicAuthRequest) error
+
+func DecodeDynamicAuthRequest(p *radius.Packet) (*DynamicAuthRequest, error) {
+	switch p.Code {
+	case radius.CodeCoARequest, radius.CodeDisconnectRequest:
+	default:
+		return nil, fmt.Errorf("unexpected packet code: %s (%d)", p.Code, p.Code)
+	}
+
+	request := &DynamicAuthRequest{
+		Code: p.Code,
+		CoaRequest: CoaRequest{
+			AcctSessionID:  rfc2866.AcctSessionID_GetString(p),
+			SessionTimeout: int(rfc2865.SessionTimeout_Get(p)),
+			IdleTimeout:    int(rfc2865.IdleTimeout_Get(p)),
+		},
+		UserName:         rfc2865.UserName_GetString(p),
+		NASIdentifier:    rfc2865.NASIdentifier_GetString(p),
+		CallingStationID: rfc2865.CallingStationID_GetString(p),
+		Packet:           p,
+	}
+
+	if v, err := rfc2865.FramedIPAddress_Lookup(p); err == nil {
+		request.FramedIPAddress = v.String()
+	}
+	if v, err := rfc2865.NASIPAddress_Lookup(p); err == nil {
+		request.NASIPAddress = v
+	}
+	if v, err := rfc2869.EventTimestamp_Lookup(p); err == nil {
+		request.EventTimestamp = v
+	}
+
+	avps, err := DecodeAVPairsVSA(p)
+	if err != nil {
+		return nil, err
+	}
+	for _, avp := range avps {
+		vsa := VSAEntity{Vendor: avp.VendorId, Attr: avp.TypeId}
+		if value, err := radius.Integer(avp.Value); err == nil && isIntegerVSA(avp) {
+			vsa.ValueInt = int(value)
+		} else {
+			vsa.ValueString = string(avp.Value)
+		}
+		request.VSAList = append(request.VSAList, vsa)
+	}
+
+	if avps, err := DecodeWimarkAVPairsStruct(p); err == nil {
+		request.Wimark = avps
+	}
+	for _, vsa := range request.VSAList {
+		if vsa.Vendor == VendorWimark && vsa.Attr == uint8(WimarkAVPTypeSessionInt) {
+			request.Wimark.SessionInt = vsa.ValueInt
+		}
+	}
+	if avps, err := DecodeCiscoAVPairsStruct(p); err == nil {
+		request.Cisco = avps
+		for _, avp := range avps.AVPList {
+			if strings.HasPrefix(avp, CiscoAuditSessionID) {
+				request.Cisco.AuditSessionID = strings.TrimPrefix(avp, CiscoAuditSessionID)
+			}
+		}
+	}
+	if avps, err := DecodeAirspaceAVPairsStruct(p); err == nil {
+		request.Airspace = avps
+	}
+
+	return request, nil
+}
+
+// isIntegerVSA tells the Wimark attributes added by AddVSAInt, the type of
+// other vendor attributes is unknown without a dictionary.
+func isIntegerVSA(avp *AVP) bool {
+	if avp.VendorId != VendorWimark {
+		return false
+	}
+	switch AVPType(avp.TypeId) {
+	case WimarkAVPTypeSessionInt, WimarkAVPTypeAlwaysRedirect:
+		return true
+	}
+	return false
+}
+
+// DynamicAuthServer is the NAS side of RFC 5176: it receives CoA and
+// Disconnect requests, usually on port 3799, and answers them with the
+// result of Handler. Its ServeRADIUS method can be passed to ServerRun.
+type DynamicAuthServer struct {
+	Handler DynamicAuthHandler
+	// NASIdentifier and NASIPAddress, when set, must match the ones of a
+	// request that carries them.
+	NASIdentifier string
+	NASIPAddress  net.IP
+	// EventTimestampWindow is the clock skew allowed to Event-Timestamp,
+	// requests outside of it are discarded. Requests seen within the window
+	// are not handled again: retransmissions get the same answer and
+	// replays none. RequireEventTimestamp discards requests without it.
+	EventTimestampWindow  time.Duration
+	RequireEventTimestamp bool
+	ErrorLog              *log.Logger
+
+	mu          sync.Mutex
+	seen        map[[16]byte]*dynamicAuthEntry
+	lastCleanup time.Time
+}
+
+type dynamicAuthEntry struct {
+	received time.Time
+	addr     string
+	response *radius.Packet
+}
+
+func NewDynamicAuthServer(handler DynamicAuthHandler) *DynamicAuthServer {
+	return &DynamicAuthServer{
+		Handler:              handler,
+		EventTimestampWindow: defaultEventTimestampWindow,
+	}
+}
+
+// NewDynamicAuthServerConfig is a server configuration on the CoA port.
+func NewDynamicAuthServerConfig(host, secret string) *RadiusServerConfig {
+	return NewRadiusServerConfig(host, defaultDynamicAuthPort, secret)
+}
+
+func (s *DynamicAuthServer) logf(format string, args ...interface{}) {
+	if s.ErrorLog != nil {
+		s.ErrorLog.Printf(format, args...)
+	} else {
+		log.Printf(format, args...)
+	}
+}
+
+func (s *DynamicAuthServer) ServeRADIUS(w radius.ResponseWriter, r *radius.Request) {
+	var ack, nak radius.Code
+	switch r.Code {
+	case radius.CodeCoARequest:
+		ack, nak = radius.CodeCoAACK, radius.CodeCoANAK
+	case radius.CodeDisconnectRequest:
+		ack, nak = radius.CodeDisconnectACK, radius.CodeDisconnectNAK
+	default:
+		return
+	}
+
+	// the request authenticator is checked by the server, a wrong
+	// Message-Authenticator is silently discarded as well
+	signed := HasMessageAuthenticator(r.Packet)
+	if signed && !VerifyMessageAuthenticator(r.Packet, nil) {
+		s.logf("dynauth: invalid Message-Authenticator from %s", r.RemoteAddr)
+		return
+	}
+
+	if err := s.checkEventTimestamp(r.Packet); err != nil {
+		s.logf("dynauth: %s from %s discarded: %v", r.Code, r.RemoteAddr, err)
+		return
+	}
+
+	if entry, seen := s.remember(r); seen {
+		if entry.response != nil && entry.addr == r.RemoteAddr.String() {
+			w.Write(entry.response)
+		} else if entry.addr != r.RemoteAddr.String() {
+			s.logf("dynauth: replayed %s from %s discarded", r.Code, r.RemoteAddr)
+		}
+		return
+	}
+
+	err := s.serve(r)
+
+	response := r.Response(ack)
+	if err != nil {
+		response = r.Response(nak)
+
+		var dynErr *DynamicAuthError
+		if !errors.As(err, &dynErr) {
+			s.logf("dynauth: %s from %s failed: %v", r.Code, r.RemoteAddr, err)
+			dynErr = &DynamicAuthError{Cause: rfc3576.ErrorCause_Value_ResourcesUnavailable}
+		}
+		rfc3576.ErrorCause_Add(response, dynErr.Cause)
+	}
+
+	if signed {
+		if err := AddMessageAuthenticator(response); err != nil {
+			s.logf("dynauth: unable to sign response: %v", err)
+			return
+		}
+	}
+
+	s.answered(r, response)
+	w.Write(response)
+}
+
+// checkEventTimestamp is the replay protection of RFC 5176.
+func (s *DynamicAuthServer) checkEventTimestamp(p *radius.Packet) error {
+	timestamp, err := rfc2869.EventTimestamp_Lookup(p)
+	if err != nil {
+		if s.RequireEventTimestamp {
+			return fmt.Errorf("attribute Event-Timestamp not found")
+		}
+		return nil
+	}
+
+	if s.EventTimestampWindow <= 0 {
+		return nil
+	}
+
+	skew := time.Since(timestamp)
+	if skew < 0 {
+		skew = -skew
+	}
+	if skew > s.EventTimestampWindow {
+		return fmt.Errorf("Event-Timestamp %s out of window", timestamp.Format(time.RFC3339))
+	}
+
+	return nil
+}
+
+// remember records a request until the timestamp window is over, seen tells
+// a request received before.
+func (s *DynamicAuthServer) remember(r *radius.Request) (entry *dynamicAuthEntry, seen bool) {
+	if s.EventTimestampWindow <= 0 {
+		return nil, false
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if s.seen == nil {
+		s.seen = make(map[[16]byte]*dynamicAuthEntry)
+	}
+	if now.Sub(s.lastCleanup) > s.EventTimestampWindow/10 {
+		s.lastCleanup = now
+		for key, entry := range s.seen {
+			if now.Sub(entry.received) > 2*s.EventTimestampWindow {
+				delete(s.seen, key)
+			}
+		}
+	}
+
+	if entry, ok := s.seen[r.Authenticator]; ok {
+		return entry, true
+	}
+
+	s.seen[r.Authenticator] = &dynamicAuthEntry{
+		received: now,
+		addr:     r.RemoteAddr.String(),
+	}
+	return nil, false
+}
+
+func (s *DynamicAuthServer) answered(r *radius.Request, response *radius.Packet) {
+	if s.EventTimestampWindow <= 0 {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if entry, ok := s.seen[r.Authenticator]; ok {
+		entry.response = response
+	}
+}
+
+func (s *DynamicAuthServer) serve(r *radius.Request) error {
+	request, err := DecodeDynamicAuthRequest(r.Packet)
+	if err != nil {
+		return &DynamicAuthError{Cause: rfc3576.ErrorCause_Value_InvalidRequest, Message: err.Error()}
+	}
+	request.RemoteAddr = r.RemoteAddr
+
+	if err := s.validate(request); err != nil {
+		return err
+	}
+
+	if s.Handler == nil {
+		return &DynamicAuthError{Cause: rfc3576.ErrorCause_Value_UnsupportedService}
+	}
+
+	return s.Handler(r.Context(), request)
+}
+
+func (s *DynamicAuthServer) validate(request *DynamicAuthRequest) error {
+	// RFC 5176, section 3: a request identifies the NAS and the session
+	if len(s.NASIdentifier) > 0 && len(request.NASIdentifier) > 0 && request.NASIdentifier != s.NASIdentifier ||
+		s.NASIPAddress != nil && request.NASIPAddress != nil && !request.NASIPAddress.Equal(s.NASIPAddress) {
+		return &DynamicAuthError{Cause: rfc3576.ErrorCause_Value_NASIdentificationMismatch}
+	}
+
+	if len(request.AcctSessionID) == 0 && len(request.UserName) == 0 &&
+		len(request.FramedIPAddress) == 0 && len(request.CallingStationID) == 0 &&
+		len(request.Cisco.AuditSessionID) == 0 {
+		return &DynamicAuthError{Cause: rfc3576.ErrorCause_Value_MissingAttribute, Message: "no session identification"}
+	}
+
+	// RFC 5176, section 3.2: Service-Type Authorize-Only asks the NAS for an
+	// Access-Request, which this server does not do
+	if serviceType, err := rfc2865.ServiceType_Lookup(request.Packet); err == nil && serviceType == rfc2865.ServiceType(rfc3576.ServiceType_Value_AuthorizeOnly) {
+		return &DynamicAuthError{Cause: rfc3576.ErrorCause_Value_UnsupportedService}
+	}
+
+	return nil
+}
@@ -0,0 +1,133 @@
+package libradius
+
+import (
+	"context"
+	"io"
+	"log"
+	"net"
+	"testing"
+	"time"
+
+	"layeh.com/radius"
+	"layeh.com/radius/rfc2866"
+	"layeh.com/radius/rfc2869"
+)
+
+func discardLog() *log.Logger {
+	return log.New(io.Discard, "", 0)
+}
+
+type dynAuthRecorder struct {
+	responses []*radius.Packet
+}
+
+func (w *dynAuthRecorder) Write(p *radius.Packet) error {
+	w.responses = append(w.responses, p)
+	return nil
+}
+
+func newDynAuthRequest(timestamp time.Time, remoteAddr string) *radius.Request {
+	p := radius.New(radius.CodeCoARequest, []byte("testing123"))
+	rfc2866.AcctSessionID_SetString(p, "session")
+	if !timestamp.IsZero() {
+		rfc2869.EventTimestamp_Set(p, timestamp)
+	}
+	addr, _ := net.ResolveUDPAddr("udp", remoteAddr)
+	return &radius.Request{Packet: p, RemoteAddr: addr}
+}
+
+func TestDynamicAuthServerEventTimestamp(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		name      string
+		timestamp time.Time
+		require   bool
+		window    time.Duration
+		answered  bool
+	}{
+		{"current", now, false, defaultEventTimestampWindow, true},
+		{"within the window", now.Add(-time.Minute), false, defaultEventTimestampWindow, true},
+		{"too old", now.Add(-10 * time.Minute), false, defaultEventTimestampWindow, false},
+		{"in the future", now.Add(10 * time.Minute), false, defaultEventTimestampWindow, false},
+		{"without window", now.Add(-10 * time.Minute), false, 0, true},
+		{"missing", time.Time{}, false, defaultEventTimestampWindow, true},
+		{"missing and required", time.Time{}, true, defaultEventTimestampWindow, false},
+		{"present and required", now, true, defaultEventTimestampWindow, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var handled int
+			s := NewDynamicAuthServer(func(ctx context.Context, request *DynamicAuthRequest) error {
+				handled++
+				return nil
+			})
+			s.EventTimestampWindow = test.window
+			s.RequireEventTimestamp = test.require
+			s.ErrorLog = discardLog()
+
+			w := &dynAuthRecorder{}
+			s.ServeRADIUS(w, newDynAuthRequest(test.timestamp, "127.0.0.1:1700"))
+
+			if answered := len(w.responses) == 1; answered != test.answered {
+				t.Fatalf("answered = %t, want %t", answered, test.answered)
+			}
+			if test.answered && w.responses[0].Code != radius.CodeCoAACK {
+				t.Errorf("got %s", w.responses[0].Code)
+			}
+			if test.answered != (handled == 1) {
+				t.Errorf("handled %d times", handled)
+			}
+		})
+	}
+}
+
+func TestDynamicAuthServerReplay(t *testing.T) {
+	tests := []struct {
+		name       string
+		window     time.Duration
+		remoteAddr string
+		responses  int
+		handled    int
+	}{
+		{"retransmission", defaultEventTimestampWindow, "127.0.0.1:1700", 2, 1},
+		{"replay", defaultEventTimestampWindow, "127.0.0.2:1700", 1, 1},
+		{"without window", 0, "127.0.0.2:1700", 2, 2},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var handled int
+			s := NewDynamicAuthServer(func(ctx context.Context, request *DynamicAuthRequest) error {
+				handled++
+				return ErrSessionContextNotFound
+			})
+			s.EventTimestampWindow = test.window
+			s.ErrorLog = discardLog()
+
+			request := newDynAuthRequest(time.Now(), "127.0.0.1:1700")
+			w := &dynAuthRecorder{}
+			s.ServeRADIUS(w, request)
+
+			again := newDynAuthRequest(time.Time{}, test.remoteAddr)
+			again.Packet = request.Packet
+			s.ServeRADIUS(w, again)
+
+			if len(w.responses) != test.responses {
+				t.Fatalf("%d responses, want %d", len(w.responses), test.responses)
+			}
+			if handled != test.handled {
+				t.Errorf("handled %d times, want %d", handled, test.handled)
+			}
+			for _, response := range w.responses {
+				if response.Code != radius.CodeCoANAK {
+					t.Errorf("got %s", response.Code)
+				}
+			}
+			if test.handled == 1 && test.responses == 2 && w.responses[0] != w.responses[1] {
+				t.Error("the retransmission got another response")
+			}
+		})
+	}
+}
@@ -0,0 +1,81 @@
+package eap
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+
+	"github.com/wimark/libradius/auth"
+)
+
+const md5ChallengeLen = 16
+
+type MD5Method struct {
+	lookup auth.CredentialLookup
+}
+
+func NewMD5Method(lookup auth.CredentialLookup) *MD5Method {
+	return &MD5Method{lookup: lookup}
+}
+
+func (m *MD5Method) Type() Type {
+	return TypeMD5Challenge
+}
+
+func (m *MD5Method) NewConversation(ctx context.Context, identity string) (Conversation, error) {
+	return &md5Conversation{
+		ctx:      ctx,
+		lookup:   m.lookup,
+		identity: identity,
+	}, nil
+}
+
+type md5Conversation struct {
+	ctx       context.Context
+	lookup    auth.CredentialLookup
+	identity  string
+	challenge []byte
+}
+
+func (c *md5Conversation) Next(response *Packet) ([]byte, *Result, error) {
+	if response == nil {
+		c.challenge = make([]byte, md5ChallengeLen)
+		if _, err := rand.Read(c.challenge); err != nil {
+			return nil, nil, err
+		}
+		return append([]byte{md5ChallengeLen}, c.challenge...), nil, nil
+	}
+
+	data := response.Data
+	if len(data) < 1 || int(data[0]) != md5.Size || len(data) < 1+md5.Size {
+		return nil, nil, fmt.Errorf("invalid EAP-MD5 response length: %d", len(data))
+	}
+
+	creds, err := c.lookup(c.ctx, c.identity)
+	if err != nil {
+		if errors.Is(err, auth.ErrUnknownUser) {
+			return nil, &Result{Identity: c.identity}, nil
+		}
+		return nil, nil, err
+	}
+	if creds == nil || len(creds.Password) == 0 {
+		return nil, &Result{Identity: c.identity}, nil
+	}
+
+	// RFC 3748, section 5.4: the response value is computed as for CHAP
+	// with the identifier of the request
+	hash := md5.New()
+	hash.Write([]byte{response.Identifier})
+	hash.Write([]byte(creds.Password))
+	hash.Write(c.challenge)
+
+	return nil, &Result{
+		Success:  subtle.ConstantTimeCompare(hash.Sum(nil), data[1:1+md5.Size]) == 1,
+		Identity: c.identity,
+	}, nil
+}
+
+func (c *md5Conversation) Close() {}
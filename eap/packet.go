@@ -0,0 +1,86 @@
+package eap
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+type Code uint8
+
+const (
+	CodeRequest  Code = 1
+	CodeResponse Code = 2
+	CodeSuccess  Code = 3
+	CodeFailure  Code = 4
+)
+
+type Type uint8
+
+const (
+	TypeIdentity     Type = 1
+	TypeNotification Type = 2
+	TypeNak          Type = 3
+	TypeMD5Challenge Type = 4
+	TypeTLS          Type = 13
+	TypeTTLS         Type = 21
+	TypePEAP         Type = 25
+	TypeMSCHAPv2     Type = 26
+	TypeExtensions   Type = 33
+)
+
+const headerLen = 4
+
+type Packet struct {
+	Code       Code
+	Identifier uint8
+	Type       Type
+	Data       []byte
+}
+
+func Parse(b []byte) (*Packet, error) {
+	if len(b) < headerLen {
+		return nil, fmt.Errorf("too short EAP packet: %d bytes", len(b))
+	}
+
+	length := int(binary.BigEndian.Uint16(b[2:4]))
+	if length < headerLen || length > len(b) {
+		return nil, fmt.Errorf("invalid EAP packet length: %d", length)
+	}
+
+	p := &Packet{
+		Code:       Code(b[0]),
+		Identifier: b[1],
+	}
+
+	switch p.Code {
+	case CodeRequest, CodeResponse:
+		if length < headerLen+1 {
+			return nil, fmt.Errorf("EAP %d packet without type", p.Code)
+		}
+		p.Type = Type(b[4])
+		p.Data = b[headerLen+1 : length]
+	case CodeSuccess, CodeFailure:
+	default:
+		return nil, fmt.Errorf("unknown EAP code: %d", p.Code)
+	}
+
+	return p, nil
+}
+
+func (p *Packet) Encode() []byte {
+	length := headerLen
+	if p.Code == CodeRequest || p.Code == CodeResponse {
+		length += 1 + len(p.Data)
+	}
+
+	b := make([]byte, length)
+	b[0] = byte(p.Code)
+	b[1] = p.Identifier
+	binary.BigEndian.PutUint16(b[2:4], uint16(length))
+	if length > headerLen {
+		b[4] = byte(p.Type)
+		copy(b[5:], p.Data)
+	}
+
+	return b
+}
@@ -0,0 +1,172 @@
+package eap
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/wimark/libradius/auth"
+)
+
+const (
+	mschapv2OpChallenge = 1
+	mschapv2OpResponse  = 2
+	mschapv2OpSuccess   = 3
+	mschapv2OpFailure   = 4
+
+	mschapv2ChallengeLen = 16
+	mschapv2ResponseLen  = 49
+	mschapv2ServerName   = "libradius"
+
+	resultTLVType    = 3
+	resultTLVSuccess = 1
+
+	maxInnerMessageLen = 4096
+)
+
+var errTunnelClosed = errors.New("tunnel closed by the peer")
+
+// NewPEAPMethod returns PEAPv0 with EAP-MSCHAPv2 as the inner method, as
+// implemented by Windows, Android and wpa_supplicant.
+func NewPEAPMethod(config *tls.Config, lookup auth.CredentialLookup) *TLSMethod {
+	return newTLSMethod(TypePEAP, config, tlsKeyingLabel, func(ctx context.Context, conn *tls.Conn, pipe *tlsPipe, identity string) (string, error) {
+		return peapTunnel(ctx, conn, pipe, lookup)
+	})
+}
+
+// peapTunnel runs the inner conversation. PEAPv0 omits the EAP header of
+// inner packets except for the Extensions carrying the Result TLV.
+func peapTunnel(ctx context.Context, conn *tls.Conn, pipe *tlsPipe, lookup auth.CredentialLookup) (string, error) {
+	// the peer acknowledges the final flight of the handshake
+	if _, ok := pipe.wait(); !ok {
+		return "", errTunnelClosed
+	}
+
+	response, err := exchangeInner(conn, []byte{byte(TypeIdentity)})
+	if err != nil {
+		return "", err
+	}
+	if len(response) < 1 || Type(response[0]) != TypeIdentity {
+		return "", errors.New("unexpected inner response to PEAP identity request")
+	}
+	identity := string(response[1:])
+
+	authChallenge := make([]byte, mschapv2ChallengeLen)
+	if _, err := rand.Read(authChallenge); err != nil {
+		return "", err
+	}
+	msID := authChallenge[0]
+
+	challenge := append([]byte{mschapv2ChallengeLen}, authChallenge...)
+	challenge = append(challenge, mschapv2ServerName...)
+	response, err = exchangeInner(conn, mschapv2Message(mschapv2OpChallenge, msID, challenge))
+	if err != nil {
+		return "", err
+	}
+
+	// Type, OpCode, MS-CHAPv2-ID, MS-Length, Value-Size, Value, Name
+	if len(response) < 6+mschapv2ResponseLen || Type(response[0]) != TypeMSCHAPv2 ||
+		response[1] != mschapv2OpResponse || response[5] != mschapv2ResponseLen {
+		return "", errors.New("unexpected inner response to MS-CHAPv2 challenge")
+	}
+	value := response[6 : 6+mschapv2ResponseLen]
+	peerChallenge, ntResponse := value[:16], value[24:48]
+	name := string(response[6+mschapv2ResponseLen:])
+
+	creds, err := lookup(ctx, identity)
+	if err != nil {
+		return "", err
+	}
+	if creds == nil {
+		return "", auth.ErrUnknownUser
+	}
+	ntHash, err := creds.PasswordNTHash()
+	if err != nil {
+		return "", err
+	}
+
+	result, err := auth.VerifyMSCHAPv2Response(authChallenge, peerChallenge, ntResponse, name, ntHash)
+	if err != nil {
+		// E=691 is ERROR_AUTHENTICATION_FAILURE, retries are not allowed
+		failure := fmt.Sprintf("E=691 R=0 C=%X V=3 M=Authentication failed", authChallenge)
+		exchangeInner(conn, mschapv2Message(mschapv2OpFailure, msID, []byte(failure)))
+		return "", err
+	}
+
+	success := result.AuthenticatorResponse() + " M=OK"
+	response, err = exchangeInner(conn, mschapv2Message(mschapv2OpSuccess, msID, []byte(success)))
+	if err != nil {
+		return "", err
+	}
+	if len(response) < 2 || Type(response[0]) != TypeMSCHAPv2 || response[1] != mschapv2OpSuccess {
+		return "", errors.New("MS-CHAPv2 success not acknowledged")
+	}
+
+	extensions := Packet{
+		Code:       CodeRequest,
+		Identifier: msID + 1,
+		Type:       TypeExtensions,
+		Data:       resultTLV(resultTLVSuccess),
+	}
+	response, err = exchangeInner(conn, extensions.Encode())
+	if err != nil {
+		return "", err
+	}
+
+	ack, err := Parse(response)
+	if err != nil {
+		return "", err
+	}
+	if ack.Code != CodeResponse || ack.Type != TypeExtensions || !hasSuccessTLV(ack.Data) {
+		return "", errors.New("PEAP result not acknowledged")
+	}
+
+	return identity, nil
+}
+
+func mschapv2Message(opCode, msID byte, data []byte) []byte {
+	b := []byte{byte(TypeMSCHAPv2), opCode, msID, 0, 0}
+	binary.BigEndian.PutUint16(b[3:5], uint16(4+len(data)))
+	return append(b, data...)
+}
+
+func resultTLV(status uint16) []byte {
+	// mandatory Result TLV
+	b := make([]byte, 6)
+	binary.BigEndian.PutUint16(b[0:2], 0x8000|resultTLVType)
+	binary.BigEndian.PutUint16(b[2:4], 2)
+	binary.BigEndian.PutUint16(b[4:6], status)
+	return b
+}
+
+func hasSuccessTLV(data []byte) bool {
+	for len(data) >= 4 {
+		typ := binary.BigEndian.Uint16(data[0:2]) & 0x3fff
+		length := int(binary.BigEndian.Uint16(data[2:4]))
+		if len(data) < 4+length {
+			return false
+		}
+		if typ == resultTLVType && length == 2 {
+			return binary.BigEndian.Uint16(data[4:6]) == resultTLVSuccess
+		}
+		data = data[4+length:]
+	}
+	return false
+}
+
+func exchangeInner(conn *tls.Conn, message []byte) ([]byte, error) {
+	if _, err := conn.Write(message); err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, maxInnerMessageLen)
+	n, err := conn.Read(buf)
+	if err != nil {
+		return nil, err
+	}
+
+	return buf[:n], nil
+}
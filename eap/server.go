@@ -0,0 +1,369 @@
+package eap
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/x509"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/wimark/libradius"
+	"layeh.com/radius"
+	"layeh.com/radius/rfc2865"
+	"layeh.com/radius/rfc2869"
+	"layeh.com/radius/vendors/microsoft"
+)
+
+const (
+	defaultSessionTimeout = 30 * time.Second
+	stateLen              = 16
+	mskLen                = 64
+)
+
+var ErrNoMethod = errors.New("no acceptable EAP method")
+
+type Result struct {
+	Success          bool
+	Identity         string
+	MSK              []byte
+	PeerCertificates []*x509.Certificate
+}
+
+type Method interface {
+	Type() Type
+	NewConversation(ctx context.Context, identity string) (Conversation, error)
+}
+
+// Conversation runs one EAP method for one peer. Next receives the
+// response to the previous request, nil when the method starts, and returns
+// either the type data of the next request or the final result.
+type Conversation interface {
+	Next(response *Packet) ([]byte, *Result, error)
+	Close()
+}
+
+type Server struct {
+	Methods        []Method
+	SessionTimeout time.Duration
+	// OnAccept may add reply attributes, e.g. a VLAN, to the Access-Accept.
+	OnAccept func(r *radius.Request, result *Result, accept *radius.Packet)
+	// Next serves Access-Requests which carry no EAP-Message.
+	Next     radius.Handler
+	ErrorLog *log.Logger
+
+	mu          sync.Mutex
+	sessions    map[string]*session
+	lastCleanup time.Time
+}
+
+type session struct {
+	mu       sync.Mutex
+	state    []byte
+	identity string
+	method   Method
+	conv     Conversation
+	expires  time.Time
+
+	requestID  uint8
+	nakAllowed bool
+	lastReply  *radius.Packet
+	lastResult *Result
+	finished   bool
+}
+
+func NewServer(methods ...Method) *Server {
+	return &Server{
+		Methods:        methods,
+		SessionTimeout: defaultSessionTimeout,
+		sessions:       make(map[string]*session),
+	}
+}
+
+func (s *Server) logf(format string, args ...interface{}) {
+	if s.ErrorLog != nil {
+		s.ErrorLog.Printf(format, args...)
+	} else {
+		log.Printf(format, args...)
+	}
+}
+
+func (s *Server) ServeRADIUS(w radius.ResponseWriter, r *radius.Request) {
+	message, err := rfc2869.EAPMessage_Lookup(r.Packet)
+	if err != nil || r.Code != radius.CodeAccessRequest {
+		if s.Next != nil {
+			s.Next.ServeRADIUS(w, r)
+		}
+		return
+	}
+
+	// RFC 3579, section 3.2: EAP requests without a valid
+	// Message-Authenticator are silently discarded
+	if !libradius.VerifyMessageAuthenticator(r.Packet, nil) {
+		s.logf("eap: invalid Message-Authenticator from %s", r.RemoteAddr)
+		return
+	}
+
+	response, err := Parse(message)
+	if err != nil || response.Code != CodeResponse {
+		s.logf("eap: invalid EAP response from %s: %v", r.RemoteAddr, err)
+		s.write(w, r, s.reject(r, 0), nil, nil)
+		return
+	}
+
+	sess := s.lookupSession(rfc2865.State_Get(r.Packet))
+	if sess == nil {
+		sess, err = s.newSession(r, response)
+		if err != nil {
+			s.logf("eap: unable to start conversation for %s: %v", r.RemoteAddr, err)
+			s.write(w, r, s.reject(r, response.Identifier), nil, nil)
+			return
+		}
+	}
+
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+
+	// a retransmitted RADIUS request gets the same answer again
+	if sess.lastReply != nil && response.Identifier+1 == sess.requestID {
+		s.write(w, r, sess.lastReply, sess, sess.lastResult)
+		return
+	}
+	if sess.finished || response.Identifier != sess.requestID {
+		return
+	}
+
+	reply, result := s.handle(r, sess, response)
+	sess.lastReply = reply
+	sess.lastResult = result
+	s.write(w, r, reply, sess, result)
+}
+
+func (s *Server) write(w radius.ResponseWriter, r *radius.Request, reply *radius.Packet, sess *session, result *Result) {
+	p := libradius.ClonePacket(reply)
+	p.Identifier = r.Identifier
+	p.Authenticator = r.Authenticator
+
+	if sess != nil && p.Code == radius.CodeAccessChallenge {
+		rfc2865.State_Set(p, sess.state)
+	}
+
+	if p.Code == radius.CodeAccessAccept && result != nil {
+		if len(result.MSK) >= mskLen {
+			microsoft.MSMPPERecvKey_Add(p, result.MSK[:32])
+			microsoft.MSMPPESendKey_Add(p, result.MSK[32:64])
+		}
+		if len(result.Identity) > 0 {
+			rfc2865.UserName_SetString(p, result.Identity)
+		}
+		if s.OnAccept != nil {
+			s.OnAccept(r, result, p)
+		}
+	}
+
+	if err := libradius.AddMessageAuthenticator(p); err != nil {
+		s.logf("eap: unable to sign reply to %s: %v", r.RemoteAddr, err)
+		return
+	}
+
+	w.Write(p)
+}
+
+func (s *Server) handle(r *radius.Request, sess *session, response *Packet) (*radius.Packet, *Result) {
+	method := sess.method
+	switch {
+	case response.Type == TypeIdentity && sess.conv == nil:
+		method = s.Methods[0]
+	case response.Type == TypeNak && sess.nakAllowed:
+		// RFC 3748, section 5.3.1: a Nak is only valid in response to the
+		// initial request of a method
+		method = s.negotiate(response.Data)
+		if method == nil {
+			s.finish(sess)
+			return s.reject(r, response.Identifier), nil
+		}
+	default:
+		method = nil
+	}
+
+	if method != nil {
+		data, err := s.start(r.Context(), sess, method)
+		if err != nil {
+			s.logf("eap: unable to start method %d for %s: %v", method.Type(), sess.identity, err)
+			s.finish(sess)
+			return s.reject(r, response.Identifier), nil
+		}
+		sess.nakAllowed = response.Type == TypeIdentity
+		return s.challenge(r, sess, response.Identifier, data), nil
+	}
+
+	sess.nakAllowed = false
+	if sess.conv == nil || response.Type != sess.method.Type() {
+		s.finish(sess)
+		return s.reject(r, response.Identifier), nil
+	}
+
+	data, result, err := sess.conv.Next(response)
+	if err != nil {
+		s.logf("eap: conversation with %s failed: %v", sess.identity, err)
+		s.finish(sess)
+		return s.reject(r, response.Identifier), nil
+	}
+
+	if result == nil {
+		return s.challenge(r, sess, response.Identifier, data), nil
+	}
+
+	s.finish(sess)
+	if !result.Success {
+		return s.reject(r, response.Identifier), nil
+	}
+
+	accept := r.Response(radius.CodeAccessAccept)
+	rfc2869.EAPMessage_Set(accept, (&Packet{Code: CodeSuccess, Identifier: response.Identifier}).Encode())
+	return accept, result
+}
+
+func (s *Server) challenge(r *radius.Request, sess *session, responseID uint8, data []byte) *radius.Packet {
+	sess.requestID = responseID + 1
+
+	request := Packet{
+		Code:       CodeRequest,
+		Identifier: sess.requestID,
+		Type:       sess.method.Type(),
+		Data:       data,
+	}
+
+	p := r.Response(radius.CodeAccessChallenge)
+	rfc2869.EAPMessage_Set(p, request.Encode())
+	return p
+}
+
+func (s *Server) reject(r *radius.Request, responseID uint8) *radius.Packet {
+	p := r.Response(radius.CodeAccessReject)
+	rfc2869.EAPMessage_Set(p, (&Packet{Code: CodeFailure, Identifier: responseID}).Encode())
+	return p
+}
+
+func (s *Server) negotiate(desired []byte) Method {
+	for _, t := range desired {
+		for _, method := range s.Methods {
+			if method.Type() == Type(t) {
+				return method
+			}
+		}
+	}
+	return nil
+}
+
+func (s *Server) start(ctx context.Context, sess *session, method Method) ([]byte, error) {
+	if sess.conv != nil {
+		sess.conv.Close()
+		sess.conv = nil
+	}
+
+	conv, err := method.NewConversation(ctx, sess.identity)
+	if err != nil {
+		return nil, err
+	}
+
+	data, result, err := conv.Next(nil)
+	if err != nil {
+		conv.Close()
+		return nil, err
+	}
+	if result != nil {
+		conv.Close()
+		return nil, errors.New("method finished without a request")
+	}
+
+	sess.method = method
+	sess.conv = conv
+	return data, nil
+}
+
+func (s *Server) newSession(r *radius.Request, response *Packet) (*session, error) {
+	if response.Type != TypeIdentity {
+		return nil, errors.New("conversation does not start with an identity")
+	}
+	if len(s.Methods) == 0 {
+		return nil, ErrNoMethod
+	}
+
+	state := make([]byte, stateLen)
+	if _, err := rand.Read(state); err != nil {
+		return nil, err
+	}
+
+	sess := &session{
+		state:     state,
+		identity:  string(response.Data),
+		requestID: response.Identifier,
+		expires:   time.Now().Add(s.timeout()),
+	}
+
+	s.mu.Lock()
+	s.cleanupLocked()
+	s.sessions[string(state)] = sess
+	s.mu.Unlock()
+
+	return sess, nil
+}
+
+func (s *Server) lookupSession(state []byte) *session {
+	if len(state) == 0 {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sess, ok := s.sessions[string(state)]
+	if !ok || time.Now().After(sess.expires) {
+		return nil
+	}
+	sess.expires = time.Now().Add(s.timeout())
+
+	return sess
+}
+
+func (s *Server) finish(sess *session) {
+	sess.finished = true
+	if sess.conv != nil {
+		sess.conv.Close()
+	}
+
+	// the session is kept until it expires to answer retransmissions
+	s.mu.Lock()
+	sess.expires = time.Now().Add(s.timeout())
+	s.mu.Unlock()
+}
+
+func (s *Server) cleanupLocked() {
+	now := time.Now()
+	if now.Sub(s.lastCleanup) < time.Second {
+		return
+	}
+	s.lastCleanup = now
+
+	for key, sess := range s.sessions {
+		if now.After(sess.expires) {
+			delete(s.sessions, key)
+			go func(sess *session) {
+				sess.mu.Lock()
+				defer sess.mu.Unlock()
+				if sess.conv != nil {
+					sess.conv.Close()
+				}
+			}(sess)
+		}
+	}
+}
+
+func (s *Server) timeout() time.Duration {
+	if s.SessionTimeout > 0 {
+		return s.SessionTimeout
+	}
+	return defaultSessionTimeout
+}
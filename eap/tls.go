@@ -0,0 +1,353 @@
+package eap
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	tlsFlagLength = 0x80
+	tlsFlagMore   = 0x40
+	tlsFlagStart  = 0x20
+
+	defaultFragmentSize = 1000
+	maxTLSMessageLen    = 64 * 1024
+
+	tlsKeyingLabel  = "client EAP encryption"
+	ttlsKeyingLabel = "ttls keying material"
+)
+
+// tunnelFunc runs the inner authentication over an established TLS tunnel
+// and returns the authenticated identity.
+type tunnelFunc func(ctx context.Context, conn *tls.Conn, pipe *tlsPipe, identity string) (string, error)
+
+// TLSMethod implements EAP-TLS and the tunneled methods built on top of it,
+// EAP-TTLS and PEAP. Only TLS 1.2 is negotiated, keying material for TLS 1.3
+// is defined differently (RFC 9190) and not supported here.
+type TLSMethod struct {
+	FragmentSize int
+
+	typ     Type
+	config  *tls.Config
+	label   string
+	version byte
+	tunnel  tunnelFunc
+}
+
+// NewTLSMethod returns EAP-TLS. The client certificate is the credential of
+// the peer, so it is always required and verified against ClientCAs,
+// whatever ClientAuth is set to.
+func NewTLSMethod(config *tls.Config) *TLSMethod {
+	config = config.Clone()
+	config.ClientAuth = tls.RequireAndVerifyClientCert
+
+	return newTLSMethod(TypeTLS, config, tlsKeyingLabel, nil)
+}
+
+func newTLSMethod(typ Type, config *tls.Config, label string, tunnel tunnelFunc) *TLSMethod {
+	config = config.Clone()
+	config.MaxVersion = tls.VersionTLS12
+	config.SessionTicketsDisabled = true
+
+	return &TLSMethod{
+		FragmentSize: defaultFragmentSize,
+		typ:          typ,
+		config:       config,
+		label:        label,
+		tunnel:       tunnel,
+	}
+}
+
+func (m *TLSMethod) Type() Type {
+	return m.typ
+}
+
+func (m *TLSMethod) NewConversation(ctx context.Context, identity string) (Conversation, error) {
+	fragmentSize := m.FragmentSize
+	if fragmentSize <= 0 {
+		fragmentSize = defaultFragmentSize
+	}
+
+	return &tlsConversation{
+		ctx:          ctx,
+		method:       m,
+		identity:     identity,
+		fragmentSize: fragmentSize,
+		pipe:         newTLSPipe(),
+		done:         make(chan struct{}),
+	}, nil
+}
+
+type tlsConversation struct {
+	ctx          context.Context
+	method       *TLSMethod
+	identity     string
+	fragmentSize int
+
+	pipe   *tlsPipe
+	done   chan struct{}
+	result *Result
+	err    error
+
+	incoming  []byte
+	outgoing  []byte
+	lengthSet bool
+	finished  bool
+}
+
+func (c *tlsConversation) Next(response *Packet) ([]byte, *Result, error) {
+	if response == nil {
+		go c.run()
+		if _, finished := c.wait(); finished {
+			return nil, nil, c.failure()
+		}
+		return []byte{tlsFlagStart | c.method.version}, nil, nil
+	}
+
+	data := response.Data
+	if len(data) < 1 {
+		return nil, nil, errors.New("EAP-TLS response without flags")
+	}
+	flags, data := data[0], data[1:]
+	if flags&tlsFlagLength != 0 {
+		if len(data) < 4 {
+			return nil, nil, errors.New("EAP-TLS response without message length")
+		}
+		if binary.BigEndian.Uint32(data) > maxTLSMessageLen {
+			return nil, nil, fmt.Errorf("too long EAP-TLS message: %d", binary.BigEndian.Uint32(data))
+		}
+		data = data[4:]
+	}
+
+	if len(c.incoming)+len(data) > maxTLSMessageLen {
+		return nil, nil, errors.New("too long EAP-TLS message")
+	}
+	c.incoming = append(c.incoming, data...)
+	if flags&tlsFlagMore != 0 {
+		// acknowledge the fragment
+		return []byte{c.method.version}, nil, nil
+	}
+
+	data, c.incoming = c.incoming, nil
+	if len(data) == 0 && len(c.outgoing) > 0 {
+		return c.fragment(), nil, nil
+	}
+
+	if c.finished {
+		// the peer acknowledged the last flight of the server
+		return nil, c.result, nil
+	}
+
+	c.outgoing = c.step(data)
+	c.lengthSet = false
+
+	select {
+	case <-c.done:
+		if c.err != nil {
+			return nil, nil, c.failure()
+		}
+		if len(c.outgoing) == 0 {
+			return nil, c.result, nil
+		}
+		c.finished = true
+	default:
+	}
+
+	return c.fragment(), nil, nil
+}
+
+func (c *tlsConversation) failure() error {
+	if c.err != nil {
+		return c.err
+	}
+	return errors.New("TLS conversation finished unexpectedly")
+}
+
+func (c *tlsConversation) fragment() []byte {
+	out := c.outgoing
+	if len(out) <= c.fragmentSize && !c.lengthSet {
+		c.outgoing = nil
+		return append([]byte{c.method.version}, out...)
+	}
+
+	var data []byte
+	flags := c.method.version
+	if !c.lengthSet {
+		flags |= tlsFlagLength
+		data = binary.BigEndian.AppendUint32(data, uint32(len(out)))
+		c.lengthSet = true
+	}
+	if len(out) > c.fragmentSize {
+		flags |= tlsFlagMore
+		out = out[:c.fragmentSize]
+	}
+	c.outgoing = c.outgoing[len(out):]
+
+	return append(append([]byte{flags}, data...), out...)
+}
+
+// step delivers data of the peer to the TLS goroutine and collects what it
+// writes until it needs more data or finishes.
+func (c *tlsConversation) step(data []byte) []byte {
+	select {
+	case c.pipe.in <- data:
+	case <-c.done:
+		return c.pipe.take()
+	}
+
+	out, _ := c.wait()
+	return out
+}
+
+func (c *tlsConversation) wait() ([]byte, bool) {
+	select {
+	case <-c.pipe.waiting:
+		return c.pipe.take(), false
+	case <-c.done:
+		return c.pipe.take(), true
+	}
+}
+
+func (c *tlsConversation) run() {
+	defer close(c.done)
+
+	conn := tls.Server(c.pipe, c.method.config)
+	if err := conn.Handshake(); err != nil {
+		c.err = err
+		return
+	}
+
+	identity := c.identity
+	if c.method.tunnel != nil {
+		var err error
+		if identity, err = c.method.tunnel(c.ctx, conn, c.pipe, identity); err != nil {
+			c.err = err
+			return
+		}
+	} else {
+		if len(conn.ConnectionState().VerifiedChains) == 0 {
+			c.err = errors.New("EAP-TLS peer without a verified certificate")
+			return
+		}
+		// the peer acknowledges the final flight of the handshake
+		if _, ok := c.pipe.wait(); !ok {
+			c.err = io.EOF
+			return
+		}
+	}
+
+	state := conn.ConnectionState()
+	msk, err := state.ExportKeyingMaterial(c.method.label, nil, mskLen)
+	if err != nil {
+		c.err = err
+		return
+	}
+
+	c.result = &Result{
+		Success:          true,
+		Identity:         identity,
+		MSK:              msk,
+		PeerCertificates: state.PeerCertificates,
+	}
+}
+
+func (c *tlsConversation) Close() {
+	c.pipe.Close()
+}
+
+// tlsPipe connects a tls.Conn to the EAP exchange: the TLS goroutine blocks
+// in Read until the next EAP response arrives, everything it writes in the
+// meantime is sent with the next EAP request.
+type tlsPipe struct {
+	in      chan []byte
+	waiting chan struct{}
+	quit    chan struct{}
+	once    sync.Once
+	buf     []byte
+
+	mu  sync.Mutex
+	out []byte
+}
+
+func newTLSPipe() *tlsPipe {
+	return &tlsPipe{
+		in:      make(chan []byte),
+		waiting: make(chan struct{}),
+		quit:    make(chan struct{}),
+	}
+}
+
+func (p *tlsPipe) wait() ([]byte, bool) {
+	select {
+	case p.waiting <- struct{}{}:
+	case <-p.quit:
+		return nil, false
+	}
+
+	select {
+	case data := <-p.in:
+		return data, true
+	case <-p.quit:
+		return nil, false
+	}
+}
+
+func (p *tlsPipe) take() []byte {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	out := p.out
+	p.out = nil
+	return out
+}
+
+func (p *tlsPipe) Read(b []byte) (int, error) {
+	for len(p.buf) == 0 {
+		data, ok := p.wait()
+		if !ok {
+			return 0, io.EOF
+		}
+		p.buf = data
+	}
+
+	n := copy(b, p.buf)
+	p.buf = p.buf[n:]
+	return n, nil
+}
+
+func (p *tlsPipe) Write(b []byte) (int, error) {
+	select {
+	case <-p.quit:
+		return 0, io.ErrClosedPipe
+	default:
+	}
+
+	p.mu.Lock()
+	p.out = append(p.out, b...)
+	p.mu.Unlock()
+
+	return len(b), nil
+}
+
+func (p *tlsPipe) Close() error {
+	p.once.Do(func() { close(p.quit) })
+	return nil
+}
+
+func (p *tlsPipe) LocalAddr() net.Addr                { return pipeAddr{} }
+func (p *tlsPipe) RemoteAddr() net.Addr               { return pipeAddr{} }
+func (p *tlsPipe) SetDeadline(t time.Time) error      { return nil }
+func (p *tlsPipe) SetReadDeadline(t time.Time) error  { return nil }
+func (p *tlsPipe) SetWriteDeadline(t time.Time) error { return nil }
+
+type pipeAddr struct{}
+
+func (pipeAddr) Network() string { return "eap" }
+func (pipeAddr) String() string  { return "eap" }
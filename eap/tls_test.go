@@ -0,0 +1,428 @@
+package eap
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/wimark/libradius"
+	"github.com/wimark/libradius/auth"
+	"layeh.com/radius"
+	"layeh.com/radius/rfc2759"
+	"layeh.com/radius/rfc2865"
+	"layeh.com/radius/rfc2869"
+	"layeh.com/radius/vendors/microsoft"
+)
+
+const (
+	testSecret   = "testing123"
+	testUser     = "alice"
+	testPassword = "clientPass"
+)
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pool *x509.CertPool
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "libradius test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return &testCA{cert: cert, key: key, pool: pool}
+}
+
+func (ca *testCA) issue(t *testing.T, name string, usage x509.ExtKeyUsage) tls.Certificate {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+type responseRecorder struct {
+	packet *radius.Packet
+}
+
+func (r *responseRecorder) Write(p *radius.Packet) error {
+	r.packet = p
+	return nil
+}
+
+// testPeer is the supplicant side of an EAP-TLS based conversation, inner
+// runs the tunneled protocol of PEAP after the handshake.
+type testPeer struct {
+	t      *testing.T
+	server *Server
+	typ    Type
+	config *tls.Config
+	inner  func(conn *tls.Conn) error
+
+	conn *tls.Conn
+	msk  []byte
+}
+
+// run authenticates and returns the final Access-Accept or Access-Reject
+// along with the request it answers.
+func (p *testPeer) run() (reply, request *radius.Packet) {
+	t := p.t
+
+	pipe := newTLSPipe()
+	defer pipe.Close()
+	done := make(chan error, 1)
+
+	// the TLS goroutine blocks in Read until the data of the next EAP
+	// request is passed, owed is set while it does
+	var owed bool
+	var state, incoming []byte
+	message := (&Packet{Code: CodeResponse, Type: TypeIdentity, Data: []byte(testUser)}).Encode()
+
+	for round := 0; round < 64; round++ {
+		request = radius.New(radius.CodeAccessRequest, []byte(testSecret))
+		rfc2865.UserName_SetString(request, testUser)
+		rfc2869.EAPMessage_Set(request, message)
+		if state != nil {
+			rfc2865.State_Set(request, state)
+		}
+		if err := libradius.AddMessageAuthenticator(request); err != nil {
+			t.Fatal(err)
+		}
+
+		w := &responseRecorder{}
+		p.server.ServeRADIUS(w, &radius.Request{Packet: request, RemoteAddr: &net.UDPAddr{}})
+		reply = w.packet
+		if reply == nil {
+			t.Fatal("no reply")
+		}
+		if !libradius.VerifyMessageAuthenticator(reply, request.Authenticator[:]) {
+			t.Fatalf("invalid Message-Authenticator in %s", reply.Code)
+		}
+		if reply.Code != radius.CodeAccessChallenge {
+			return reply, request
+		}
+		state = rfc2865.State_Get(reply)
+
+		b, err := rfc2869.EAPMessage_Lookup(reply)
+		if err != nil {
+			t.Fatal(err)
+		}
+		eapRequest, err := Parse(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if eapRequest.Type != p.typ {
+			t.Fatalf("EAP request of type %d, want %d", eapRequest.Type, p.typ)
+		}
+
+		flags, data := eapRequest.Data[0], eapRequest.Data[1:]
+		if flags&tlsFlagLength != 0 {
+			data = data[4:]
+		}
+		incoming = append(incoming, data...)
+
+		response := &Packet{Code: CodeResponse, Identifier: eapRequest.Identifier, Type: p.typ}
+		switch {
+		case flags&tlsFlagStart != 0:
+			p.conn = tls.Client(pipe, p.config)
+			go func() { done <- p.handshake() }()
+		case flags&tlsFlagMore != 0:
+			// acknowledge the fragment
+			response.Data = []byte{0}
+			message = response.Encode()
+			continue
+		default:
+			if !owed {
+				t.Fatal("data for a finished TLS conversation")
+			}
+			pipe.in <- incoming
+		}
+		incoming = nil
+
+		select {
+		case <-pipe.waiting:
+			owed = true
+		case err := <-done:
+			owed = false
+			if err != nil {
+				t.Logf("peer: %s", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("the peer is stuck")
+		}
+
+		out := pipe.take()
+		response.Data = []byte{0}
+		if len(out) > 0 {
+			response.Data = binary.BigEndian.AppendUint32([]byte{tlsFlagLength}, uint32(len(out)))
+			response.Data = append(response.Data, out...)
+		}
+		message = response.Encode()
+	}
+
+	t.Fatal("the conversation does not end")
+	return nil, nil
+}
+
+func (p *testPeer) handshake() error {
+	if err := p.conn.Handshake(); err != nil {
+		return err
+	}
+
+	state := p.conn.ConnectionState()
+	msk, err := state.ExportKeyingMaterial(tlsKeyingLabel, nil, mskLen)
+	if err != nil {
+		return err
+	}
+	p.msk = msk
+
+	if p.inner != nil {
+		return p.inner(p.conn)
+	}
+	return nil
+}
+
+// peapPeer answers the inner identity and EAP-MSCHAPv2 requests of PEAPv0.
+func peapPeer(password string) func(conn *tls.Conn) error {
+	return func(conn *tls.Conn) error {
+		buf := make([]byte, maxInnerMessageLen)
+		read := func() ([]byte, error) {
+			n, err := conn.Read(buf)
+			return buf[:n], err
+		}
+
+		b, err := read()
+		if err != nil {
+			return err
+		}
+		if len(b) != 1 || Type(b[0]) != TypeIdentity {
+			return fmt.Errorf("unexpected inner request %x", b)
+		}
+		if _, err := conn.Write(append([]byte{byte(TypeIdentity)}, testUser...)); err != nil {
+			return err
+		}
+
+		b, err = read()
+		if err != nil {
+			return err
+		}
+		if len(b) < 5+1+mschapv2ChallengeLen || Type(b[0]) != TypeMSCHAPv2 || b[1] != mschapv2OpChallenge {
+			return fmt.Errorf("unexpected inner request %x", b)
+		}
+		msID := b[2]
+		authChallenge := append([]byte(nil), b[6:6+mschapv2ChallengeLen]...)
+
+		peerChallenge := make([]byte, 16)
+		rand.Read(peerChallenge)
+		ntResponse, err := rfc2759.GenerateNTResponse(authChallenge, peerChallenge, []byte(testUser), []byte(password))
+		if err != nil {
+			return err
+		}
+
+		value := append(append(append([]byte(nil), peerChallenge...), make([]byte, 8)...), ntResponse...)
+		value = append(value, 0)
+		data := append([]byte{mschapv2ResponseLen}, value...)
+		if _, err := conn.Write(mschapv2Message(mschapv2OpResponse, msID, append(data, testUser...))); err != nil {
+			return err
+		}
+
+		b, err = read()
+		if err != nil {
+			return err
+		}
+		if len(b) < 5 || Type(b[0]) != TypeMSCHAPv2 {
+			return fmt.Errorf("unexpected inner request %x", b)
+		}
+		if b[1] == mschapv2OpFailure {
+			conn.Write([]byte{byte(TypeMSCHAPv2), mschapv2OpFailure})
+			return errors.New("MS-CHAPv2 failure")
+		}
+		expected, err := rfc2759.GenerateAuthenticatorResponse(authChallenge, peerChallenge, ntResponse, []byte(testUser), []byte(password))
+		if err != nil {
+			return err
+		}
+		if !bytes.HasPrefix(b[5:], []byte(expected)) {
+			return fmt.Errorf("invalid authenticator response %q", b[5:])
+		}
+		if _, err := conn.Write([]byte{byte(TypeMSCHAPv2), mschapv2OpSuccess}); err != nil {
+			return err
+		}
+
+		b, err = read()
+		if err != nil {
+			return err
+		}
+		extensions, err := Parse(b)
+		if err != nil {
+			return err
+		}
+		if extensions.Type != TypeExtensions || !hasSuccessTLV(extensions.Data) {
+			return fmt.Errorf("unexpected inner request %x", b)
+		}
+		ack := Packet{Code: CodeResponse, Identifier: extensions.Identifier, Type: TypeExtensions, Data: resultTLV(resultTLVSuccess)}
+		_, err = conn.Write(ack.Encode())
+		return err
+	}
+}
+
+func TestTLSConversation(t *testing.T) {
+	ca := newTestCA(t)
+	serverCert := ca.issue(t, "radius.example.com", x509.ExtKeyUsageServerAuth)
+	clientCert := ca.issue(t, "client.example.com", x509.ExtKeyUsageClientAuth)
+
+	serverConfig := &tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientCAs:    ca.pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	}
+	optionalConfig := &tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientCAs:    ca.pool,
+		ClientAuth:   tls.VerifyClientCertIfGiven,
+	}
+	lookup := func(ctx context.Context, username string) (*auth.Credentials, error) {
+		if username != testUser {
+			return nil, nil
+		}
+		return &auth.Credentials{Password: testPassword}, nil
+	}
+
+	tests := []struct {
+		name     string
+		method   *TLSMethod
+		cert     bool
+		password string
+		accept   bool
+	}{
+		{"EAP-TLS", NewTLSMethod(serverConfig), true, "", true},
+		{"EAP-TLS without certificate", NewTLSMethod(serverConfig), false, "", false},
+		{"EAP-TLS with optional client auth", NewTLSMethod(optionalConfig), true, "", true},
+		{"EAP-TLS with optional client auth without certificate", NewTLSMethod(optionalConfig), false, "", false},
+		{"PEAP", NewPEAPMethod(&tls.Config{Certificates: []tls.Certificate{serverCert}}, lookup), false, testPassword, true},
+		{"PEAP with a wrong password", NewPEAPMethod(&tls.Config{Certificates: []tls.Certificate{serverCert}}, lookup), false, "serverPass", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// fragments of the server flights are acknowledged by the peer
+			test.method.FragmentSize = 200
+
+			var result *Result
+			server := NewServer(test.method)
+			server.OnAccept = func(r *radius.Request, res *Result, accept *radius.Packet) {
+				result = res
+			}
+
+			peer := &testPeer{
+				t:      t,
+				server: server,
+				typ:    test.method.Type(),
+				config: &tls.Config{
+					ServerName: "radius.example.com",
+					RootCAs:    ca.pool,
+					MaxVersion: tls.VersionTLS12,
+				},
+			}
+			if test.cert {
+				peer.config.Certificates = []tls.Certificate{clientCert}
+			}
+			if test.method.Type() == TypePEAP {
+				peer.inner = peapPeer(test.password)
+			}
+
+			reply, request := peer.run()
+			if accepted := reply.Code == radius.CodeAccessAccept; accepted != test.accept {
+				t.Fatalf("got %s", reply.Code)
+			}
+
+			b, _ := rfc2869.EAPMessage_Lookup(reply)
+			eapReply, err := Parse(b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !test.accept {
+				if eapReply.Code != CodeFailure {
+					t.Errorf("EAP code %d in %s", eapReply.Code, reply.Code)
+				}
+				return
+			}
+			if eapReply.Code != CodeSuccess {
+				t.Errorf("EAP code %d in %s", eapReply.Code, reply.Code)
+			}
+
+			// both sides derive the same MSK, carried in the MPPE keys
+			recvKey, err := microsoft.MSMPPERecvKey_Lookup(reply, request)
+			if err != nil {
+				t.Fatal(err)
+			}
+			sendKey, err := microsoft.MSMPPESendKey_Lookup(reply, request)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(append(recvKey, sendKey...), peer.msk) {
+				t.Error("the MPPE keys are not the MSK of the peer")
+			}
+
+			if result == nil || result.Identity != testUser {
+				t.Fatalf("result %+v", result)
+			}
+			if test.cert && (len(result.PeerCertificates) == 0 || result.PeerCertificates[0].Subject.CommonName != "client.example.com") {
+				t.Error("the client certificate is not in the result")
+			}
+		})
+	}
+}
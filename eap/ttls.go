@@ -0,0 +1,99 @@
+package eap
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/wimark/libradius/auth"
+)
+
+const (
+	diameterFlagVendor = 0x80
+
+	diameterUserName     = 1
+	diameterUserPassword = 2
+)
+
+// NewTTLSMethod returns EAP-TTLSv0 with PAP as the inner method, the
+// credentials are carried as Diameter AVPs (RFC 5281, section 11.2.5).
+func NewTTLSMethod(config *tls.Config, lookup auth.CredentialLookup) *TLSMethod {
+	return newTLSMethod(TypeTTLS, config, ttlsKeyingLabel, func(ctx context.Context, conn *tls.Conn, pipe *tlsPipe, identity string) (string, error) {
+		return ttlsTunnel(ctx, conn, lookup)
+	})
+}
+
+func ttlsTunnel(ctx context.Context, conn *tls.Conn, lookup auth.CredentialLookup) (string, error) {
+	buf := make([]byte, maxInnerMessageLen)
+	n, err := conn.Read(buf)
+	if err != nil {
+		return "", err
+	}
+
+	avps, err := parseDiameterAVPs(buf[:n])
+	if err != nil {
+		return "", err
+	}
+
+	username, ok := avps[diameterUserName]
+	if !ok {
+		return "", fmt.Errorf("attribute User-Name not found")
+	}
+	password, ok := avps[diameterUserPassword]
+	if !ok {
+		return "", auth.ErrUnsupportedMethod
+	}
+
+	creds, err := lookup(ctx, string(username))
+	if err != nil {
+		return "", err
+	}
+	if creds == nil {
+		return "", auth.ErrUnknownUser
+	}
+
+	// the password is padded with zeros to a multiple of 16 bytes
+	if err := auth.CheckPassword(creds, bytes.TrimRight(password, "\x00")); err != nil {
+		return "", err
+	}
+
+	return string(username), nil
+}
+
+// parseDiameterAVPs returns the values of the non-vendor AVPs by code.
+func parseDiameterAVPs(b []byte) (map[uint32][]byte, error) {
+	avps := make(map[uint32][]byte)
+	for len(b) > 0 {
+		if len(b) < 8 {
+			return nil, errors.New("truncated Diameter AVP")
+		}
+
+		code := binary.BigEndian.Uint32(b[0:4])
+		flags := b[4]
+		length := int(binary.BigEndian.Uint32(b[4:8]) & 0xffffff)
+
+		header := 8
+		if flags&diameterFlagVendor != 0 {
+			header = 12
+		}
+		if length < header || length > len(b) {
+			return nil, fmt.Errorf("invalid Diameter AVP length: %d", length)
+		}
+
+		if flags&diameterFlagVendor == 0 {
+			avps[code] = b[header:length]
+		}
+
+		// AVPs are padded to a multiple of 4 bytes
+		padded := (length + 3) &^ 3
+		if padded > len(b) {
+			padded = len(b)
+		}
+		b = b[padded:]
+	}
+
+	return avps, nil
+}
@@ -0,0 +1,59 @@
+package libradius
+
+import (
+	"net"
+	"sync/atomic"
+	"time"
+
+	"layeh.com/radius"
+)
+
+type Metrics interface {
+	ClientRequest(addr string, code radius.Code)
+	ClientResponse(addr string, request, response radius.Code, rtt time.Duration)
+	ClientTimeout(addr string, code radius.Code)
+	ClientRetransmit(addr string, code radius.Code)
+	ClientBadAuthenticator(addr string, code radius.Code)
+
+	ServerRequest(code radius.Code)
+	ServerResponse(request, response radius.Code, latency time.Duration)
+	ServerBadAuthenticator(addr net.Addr, code radius.Code)
+	ServerUnknownClient(addr net.Addr)
+}
+
+type NopMetrics struct{}
+
+func (NopMetrics) ClientRequest(string, radius.Code)                              {}
+func (NopMetrics) ClientResponse(string, radius.Code, radius.Code, time.Duration) {}
+func (NopMetrics) ClientTimeout(string, radius.Code)                              {}
+func (NopMetrics) ClientRetransmit(string, radius.Code)                           {}
+func (NopMetrics) ClientBadAuthenticator(string, radius.Code)                     {}
+func (NopMetrics) ServerRequest(radius.Code)                                      {}
+func (NopMetrics) ServerResponse(radius.Code, radius.Code, time.Duration)         {}
+func (NopMetrics) ServerBadAuthenticator(net.Addr, radius.Code)                   {}
+func (NopMetrics) ServerUnknownClient(net.Addr)                                   {}
+
+// metricsHook holds a metricsHolder, atomic.Value requires every value
+// stored to be of the same type.
+var metricsHook atomic.Value
+
+type metricsHolder struct {
+	Metrics
+}
+
+func metrics() Metrics {
+	if h, ok := metricsHook.Load().(metricsHolder); ok {
+		return h.Metrics
+	}
+	return NopMetrics{}
+}
+
+// SetMetrics installs the hook used by SendPacket, SendCoA and the ServerRun
+// family. It is safe to call while traffic is sent or served, the requests in
+// flight may report to either hook.
+func SetMetrics(m Metrics) {
+	if m == nil {
+		m = NopMetrics{}
+	}
+	metricsHook.Store(metricsHolder{m})
+}
@@ -0,0 +1,136 @@
+package libradius
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"layeh.com/radius"
+)
+
+// recordingMetrics counts the calls of every hook, by hook and codes.
+type recordingMetrics struct {
+	mu    sync.Mutex
+	calls map[string]int
+}
+
+func (m *recordingMetrics) record(format string, args ...interface{}) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.calls[fmt.Sprintf(format, args...)]++
+}
+
+func (m *recordingMetrics) count(call string) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.calls[call]
+}
+
+func (m *recordingMetrics) ClientRequest(_ string, code radius.Code) {
+	m.record("ClientRequest %s", code)
+}
+
+func (m *recordingMetrics) ClientResponse(_ string, request, response radius.Code, _ time.Duration) {
+	m.record("ClientResponse %s %s", request, response)
+}
+
+func (m *recordingMetrics) ClientTimeout(_ string, code radius.Code) {
+	m.record("ClientTimeout %s", code)
+}
+
+func (m *recordingMetrics) ClientRetransmit(_ string, code radius.Code) {
+	m.record("ClientRetransmit %s", code)
+}
+
+func (m *recordingMetrics) ClientBadAuthenticator(_ string, code radius.Code) {
+	m.record("ClientBadAuthenticator %s", code)
+}
+
+func (m *recordingMetrics) ServerRequest(code radius.Code) {
+	m.record("ServerRequest %s", code)
+}
+
+func (m *recordingMetrics) ServerResponse(request, response radius.Code, _ time.Duration) {
+	m.record("ServerResponse %s %s", request, response)
+}
+
+func (m *recordingMetrics) ServerBadAuthenticator(_ net.Addr, code radius.Code) {
+	m.record("ServerBadAuthenticator %s", code)
+}
+
+func (m *recordingMetrics) ServerUnknownClient(net.Addr) {
+	m.record("ServerUnknownClient")
+}
+
+// countingSecretSource counts the lookups of the secret.
+type countingSecretSource struct {
+	mu      sync.Mutex
+	lookups int
+}
+
+func (s *countingSecretSource) RADIUSSecret(ctx context.Context, addr net.Addr) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lookups++
+	return []byte("testing123"), nil
+}
+
+func TestServerMetrics(t *testing.T) {
+	m := &recordingMetrics{calls: make(map[string]int)}
+	SetMetrics(m)
+	defer SetMetrics(nil)
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	secrets := &countingSecretSource{}
+	server := newPacketServer(&RadiusServerConfig{}, secrets, func(w radius.ResponseWriter, r *radius.Request) {
+		w.Write(r.Response(radius.CodeAccessAccept))
+	})
+	go servePacketConn(server, conn)
+	defer server.Shutdown(context.Background())
+	addr := conn.LocalAddr().String()
+
+	response, err := SendPacket(addr, radius.New(radius.CodeAccessRequest, []byte("testing123")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.Code != radius.CodeAccessAccept {
+		t.Fatalf("got %s", response.Code)
+	}
+
+	// an accounting request signed with another secret is dropped
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if _, err := SendPacketContext(ctx, addr, radius.New(radius.CodeAccountingRequest, []byte("wrong"))); err == nil {
+		t.Fatal("the accounting request was answered")
+	}
+
+	for call, want := range map[string]int{
+		"ClientRequest Access-Request":                1,
+		"ClientResponse Access-Request Access-Accept": 1,
+		"ClientRequest Accounting-Request":            1,
+		"ClientTimeout Accounting-Request":            1,
+		"ServerRequest Access-Request":                1,
+		"ServerResponse Access-Request Access-Accept": 1,
+		"ServerBadAuthenticator Accounting-Request":   1,
+		"ServerRequest Accounting-Request":            0,
+		"ClientBadAuthenticator Access-Request":       0,
+		"ServerUnknownClient":                         0,
+	} {
+		if got := m.count(call); got != want {
+			t.Errorf("%s: %d calls, want %d", call, got, want)
+		}
+	}
+
+	// the secret is looked up once per datagram
+	secrets.mu.Lock()
+	defer secrets.mu.Unlock()
+	if secrets.lookups != 2 {
+		t.Errorf("%d secret lookups for 2 requests", secrets.lookups)
+	}
+}
@@ -0,0 +1,395 @@
+package libradius
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"layeh.com/radius"
+)
+
+const (
+	defaultMuxMaxSockets = 16
+	muxIdentifiers       = 256
+	// muxIdleTimeout is how long a socket without requests in flight stays
+	// open
+	muxIdleTimeout = time.Minute
+)
+
+// MuxClient exchanges packets over UDP sockets shared by concurrent requests.
+// Every destination gets a socket, and another source port whenever the 256
+// Identifiers of the open ones are in flight, up to MaxConns sockets. Idle
+// and failed sockets are closed.
+type MuxClient struct {
+	retry       time.Duration
+	maxSockets  int
+	idleTimeout time.Duration
+
+	mu     sync.Mutex
+	dests  map[string]*muxDestination
+	closed bool
+}
+
+func NewMuxClient(cfg *RadiusClientConfig) *MuxClient {
+	c := &MuxClient{
+		retry:       cfg.Retry,
+		maxSockets:  cfg.MaxConns,
+		idleTimeout: muxIdleTimeout,
+		dests:       make(map[string]*muxDestination),
+	}
+	if c.maxSockets <= 0 {
+		c.maxSockets = defaultMuxMaxSockets
+	}
+	return c
+}
+
+// Exchange sends the packet to addr with a free Identifier and waits for the
+// response, retransmitting it every Retry.
+func (c *MuxClient) Exchange(ctx context.Context, packet *radius.Packet, addr string) (*radius.Packet, error) {
+	dest, err := c.destination(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, defaultSendRadiusPacketTimeout)
+		defer cancel()
+	}
+
+	// a slot guarantees a free Identifier on an open or a new socket
+	select {
+	case dest.slots <- struct{}{}:
+	case <-ctx.Done():
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			metrics().ClientTimeout(addr, packet.Code)
+		}
+		return nil, ctx.Err()
+	}
+	defer func() { <-dest.slots }()
+
+	socket, request, err := dest.register(packet)
+	if err != nil {
+		return nil, err
+	}
+	defer socket.unregister(request)
+
+	return socket.exchange(ctx, request, c.retry)
+}
+
+// Close closes all sockets, running exchanges fail.
+func (c *MuxClient) Close() error {
+	c.mu.Lock()
+	c.closed = true
+	dests := c.dests
+	c.dests = make(map[string]*muxDestination)
+	c.mu.Unlock()
+
+	for _, dest := range dests {
+		dest.close()
+	}
+	return nil
+}
+
+func (c *MuxClient) destination(addr string) (*muxDestination, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return nil, ErrClientClosed
+	}
+
+	dest, ok := c.dests[addr]
+	if !ok {
+		dest = &muxDestination{
+			addr:        addr,
+			idleTimeout: c.idleTimeout,
+			slots:       make(chan struct{}, muxIdentifiers*c.maxSockets),
+		}
+		c.dests[addr] = dest
+	}
+	return dest, nil
+}
+
+type muxDestination struct {
+	addr        string
+	idleTimeout time.Duration
+	slots       chan struct{}
+
+	mu      sync.Mutex
+	sockets []*muxSocket
+}
+
+func (d *muxDestination) register(packet *radius.Packet) (*muxSocket, *muxRequest, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	// failed sockets are left to the exchanges still waiting on them
+	sockets := d.sockets[:0]
+	for _, socket := range d.sockets {
+		if !socket.failed() {
+			sockets = append(sockets, socket)
+		}
+	}
+	for i := len(sockets); i < len(d.sockets); i++ {
+		d.sockets[i] = nil
+	}
+	d.sockets = sockets
+
+	for _, socket := range d.sockets {
+		request, ok, err := socket.register(packet)
+		if err != nil {
+			return nil, nil, err
+		}
+		if ok {
+			return socket, request, nil
+		}
+	}
+
+	socket, err := newMuxSocket(d.addr, d.idleTimeout, d.reap)
+	if err != nil {
+		return nil, nil, err
+	}
+	d.sockets = append(d.sockets, socket)
+
+	request, _, err := socket.register(packet)
+	if err != nil {
+		return nil, nil, err
+	}
+	return socket, request, nil
+}
+
+// reap closes the socket unless a request was registered on it since it
+// became idle.
+func (d *muxDestination) reap(socket *muxSocket) {
+	d.mu.Lock()
+	socket.mu.Lock()
+	busy := len(socket.pending) > 0
+	socket.mu.Unlock()
+	if busy {
+		d.mu.Unlock()
+		return
+	}
+
+	for i, s := range d.sockets {
+		if s == socket {
+			d.sockets = append(d.sockets[:i], d.sockets[i+1:]...)
+			break
+		}
+	}
+	d.mu.Unlock()
+
+	socket.close(net.ErrClosed)
+}
+
+func (d *muxDestination) close() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for _, socket := range d.sockets {
+		socket.close(ErrClientClosed)
+	}
+	d.sockets = nil
+}
+
+type muxRequest struct {
+	packet   *radius.Packet
+	wire     []byte
+	response chan *radius.Packet
+}
+
+type muxSocket struct {
+	addr        string
+	conn        net.Conn
+	idleTimeout time.Duration
+	onIdle      func(*muxSocket)
+
+	mu      sync.Mutex
+	pending map[byte]*muxRequest
+	nextID  byte
+	idle    *time.Timer
+	err     error
+	done    chan struct{}
+}
+
+func newMuxSocket(addr string, idleTimeout time.Duration, onIdle func(*muxSocket)) (*muxSocket, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &muxSocket{
+		addr:        addr,
+		conn:        conn,
+		idleTimeout: idleTimeout,
+		onIdle:      onIdle,
+		pending:     make(map[byte]*muxRequest),
+		done:        make(chan struct{}),
+	}
+	go s.readLoop()
+
+	return s, nil
+}
+
+// register allocates a free Identifier to the packet, ok is false when all
+// of them are in flight.
+func (s *muxSocket) register(packet *radius.Packet) (request *muxRequest, ok bool, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.err != nil || len(s.pending) == muxIdentifiers {
+		return nil, false, nil
+	}
+
+	id := s.nextID
+	for {
+		if _, used := s.pending[id]; !used {
+			break
+		}
+		id++
+	}
+	s.nextID = id + 1
+
+	clone := ClonePacket(packet)
+	clone.Identifier = id
+	if HasMessageAuthenticator(clone) {
+		if err := AddMessageAuthenticator(clone); err != nil {
+			return nil, false, err
+		}
+	}
+
+	wire, err := clone.Encode()
+	if err != nil {
+		return nil, false, err
+	}
+
+	request = &muxRequest{
+		packet:   clone,
+		wire:     wire,
+		response: make(chan *radius.Packet, 1),
+	}
+	s.pending[id] = request
+	if s.idle != nil {
+		s.idle.Stop()
+		s.idle = nil
+	}
+
+	return request, true, nil
+}
+
+func (s *muxSocket) unregister(request *muxRequest) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.pending[request.packet.Identifier] == request {
+		delete(s.pending, request.packet.Identifier)
+	}
+
+	if len(s.pending) == 0 && s.err == nil && s.idle == nil {
+		s.idle = time.AfterFunc(s.idleTimeout, func() { s.onIdle(s) })
+	}
+}
+
+func (s *muxSocket) failed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.err != nil
+}
+
+func (s *muxSocket) exchange(ctx context.Context, request *muxRequest, retry time.Duration) (*radius.Packet, error) {
+	code := request.packet.Code
+	metrics().ClientRequest(s.addr, code)
+	start := time.Now()
+
+	if _, err := s.conn.Write(request.wire); err != nil {
+		return nil, err
+	}
+
+	var retryTimer <-chan time.Time
+	if retry > 0 {
+		ticker := time.NewTicker(retry)
+		defer ticker.Stop()
+		retryTimer = ticker.C
+	}
+
+	for {
+		select {
+		case <-retryTimer:
+			metrics().ClientRetransmit(s.addr, code)
+			s.conn.Write(request.wire)
+		case response := <-request.response:
+			metrics().ClientResponse(s.addr, code, response.Code, time.Since(start))
+			return response, nil
+		case <-s.done:
+			return nil, s.err
+		case <-ctx.Done():
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				metrics().ClientTimeout(s.addr, code)
+			}
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func (s *muxSocket) readLoop() {
+	var incoming [radius.MaxPacketLength]byte
+	for {
+		n, err := s.conn.Read(incoming[:])
+		if err != nil {
+			// ICMP errors of connected UDP sockets are reported by Read,
+			// they do not close the socket
+			if !errors.Is(err, net.ErrClosed) {
+				continue
+			}
+			s.close(err)
+			return
+		}
+		if n < 20 {
+			continue
+		}
+
+		s.mu.Lock()
+		request := s.pending[incoming[1]]
+		s.mu.Unlock()
+		if request == nil {
+			continue
+		}
+
+		if !radius.IsAuthenticResponse(incoming[:n], request.wire, request.packet.Secret) {
+			metrics().ClientBadAuthenticator(s.addr, request.packet.Code)
+			continue
+		}
+
+		response, err := radius.Parse(incoming[:n], request.packet.Secret)
+		if err != nil {
+			continue
+		}
+
+		s.mu.Lock()
+		if s.pending[response.Identifier] == request {
+			delete(s.pending, response.Identifier)
+			request.response <- response
+		}
+		s.mu.Unlock()
+	}
+}
+
+func (s *muxSocket) close(err error) {
+	s.mu.Lock()
+	if s.err != nil {
+		s.mu.Unlock()
+		return
+	}
+	s.err = err
+	close(s.done)
+	if s.idle != nil {
+		s.idle.Stop()
+		s.idle = nil
+	}
+	s.mu.Unlock()
+
+	s.conn.Close()
+}
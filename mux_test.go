@@ -0,0 +1,148 @@
+package libradius
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"layeh.com/radius"
+	"layeh.com/radius/rfc2865"
+)
+
+func startTestServer(t *testing.T, secret string) string {
+	t.Helper()
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &radius.PacketServer{
+		SecretSource: radius.StaticSecretSource([]byte(secret)),
+		Handler: radius.HandlerFunc(func(w radius.ResponseWriter, r *radius.Request) {
+			w.Write(r.Response(radius.CodeAccessAccept))
+		}),
+	}
+	go server.Serve(conn)
+	t.Cleanup(func() { server.Shutdown(context.Background()) })
+
+	return conn.LocalAddr().String()
+}
+
+func muxSockets(c *MuxClient, addr string) []*muxSocket {
+	c.mu.Lock()
+	dest := c.dests[addr]
+	c.mu.Unlock()
+
+	dest.mu.Lock()
+	defer dest.mu.Unlock()
+	return append([]*muxSocket(nil), dest.sockets...)
+}
+
+func TestMuxClientReapsSockets(t *testing.T) {
+	addr := startTestServer(t, "testing123")
+
+	c := NewMuxClient(&RadiusClientConfig{})
+	c.idleTimeout = 50 * time.Millisecond
+	defer c.Close()
+
+	exchange := func() {
+		t.Helper()
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		response, err := c.Exchange(ctx, radius.New(radius.CodeAccessRequest, []byte("testing123")), addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if response.Code != radius.CodeAccessAccept {
+			t.Fatalf("got %s", response.Code)
+		}
+	}
+
+	exchange()
+	sockets := muxSockets(c, addr)
+	if len(sockets) != 1 {
+		t.Fatalf("%d sockets after an exchange, want 1", len(sockets))
+	}
+
+	// an idle socket is closed and removed
+	deadline := time.Now().Add(time.Second)
+	for len(muxSockets(c, addr)) > 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("the idle socket was not reaped")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !sockets[0].failed() {
+		t.Error("the reaped socket is still open")
+	}
+
+	// a failed socket is replaced
+	exchange()
+	sockets = muxSockets(c, addr)
+	sockets[0].close(net.ErrClosed)
+	exchange()
+	if replaced := muxSockets(c, addr); len(replaced) != 1 || replaced[0] == sockets[0] {
+		t.Fatalf("got sockets %v, want the failed one replaced", replaced)
+	}
+}
+
+func TestMuxClientConcurrentExchanges(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the slow answers keep the Identifiers of the first socket in flight
+	server := &radius.PacketServer{
+		SecretSource: radius.StaticSecretSource([]byte("testing123")),
+		Handler: radius.HandlerFunc(func(w radius.ResponseWriter, r *radius.Request) {
+			time.Sleep(200 * time.Millisecond)
+			response := r.Response(radius.CodeAccessAccept)
+			rfc2865.ReplyMessage_Set(response, rfc2865.UserName_Get(r.Packet))
+			w.Write(response)
+		}),
+	}
+	go server.Serve(conn)
+	defer server.Shutdown(context.Background())
+	addr := conn.LocalAddr().String()
+
+	// the burst may overflow the receive buffer of the server
+	c := NewMuxClient(&RadiusClientConfig{Retry: 500 * time.Millisecond})
+	defer c.Close()
+
+	const requests = muxIdentifiers + 44
+	errs := make(chan error, requests)
+	var wg sync.WaitGroup
+	for i := 0; i < requests; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			user := fmt.Sprintf("user%d", i)
+			packet := radius.New(radius.CodeAccessRequest, []byte("testing123"))
+			rfc2865.UserName_SetString(packet, user)
+
+			response, err := c.Exchange(ctx, packet, addr)
+			if err != nil {
+				errs <- err
+				return
+			}
+			if reply := rfc2865.ReplyMessage_GetString(response); reply != user {
+				errs <- fmt.Errorf("%s got the response of %s", user, reply)
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Error(err)
+	}
+	if sockets := muxSockets(c, addr); len(sockets) != 2 {
+		t.Errorf("%d sockets for %d concurrent requests, want 2", len(sockets), requests)
+	}
+}
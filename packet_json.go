@@ -0,0 +1,421 @@
+package libradius
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"layeh.com/radius"
+	"layeh.com/radius/dictionary"
+	"layeh.com/radius/rfc2865"
+)
+
+// JSONPacket gives a packet a stable JSON representation, e.g. for events
+// holding packets:
+//
+//	{"code":"Access-Request","identifier":1,"authenticator":"…","attributes":[
+//		{"name":"User-Name","type":1,"value":"bob"},
+//		{"name":"Wimark-Client-Group","vendor":52400,"vendor_name":"Wimark","type":3,"value":"guests"},
+//		{"name":"User-Password","type":2,"encrypted":true,"raw":"…"}]}
+//
+// Values are typed by the dictionary: numbers for integers, strings for the
+// other types, hex for octets. Attributes missing from the dictionary, hidden
+// ones and the ones not matching their type are kept in raw, in hex, so that
+// the packet is encoded again as it was, except for Vendor-Specific
+// attributes holding many vendor attributes, which are split. The secret is
+// never encoded.
+type JSONPacket struct {
+	*radius.Packet
+	// Dictionary defaults to DefaultDictionary.
+	Dictionary *dictionary.Dictionary
+}
+
+type jsonPacket struct {
+	Code          string          `json:"code"`
+	Identifier    uint8           `json:"identifier"`
+	Authenticator string          `json:"authenticator"`
+	Attributes    []jsonAttribute `json:"attributes"`
+}
+
+type jsonAttribute struct {
+	Name       string          `json:"name,omitempty"`
+	Vendor     uint32          `json:"vendor,omitempty"`
+	VendorName string          `json:"vendor_name,omitempty"`
+	Type       uint8           `json:"type,omitempty"`
+	Tag        *uint8          `json:"tag,omitempty"`
+	Value      json.RawMessage `json:"value,omitempty"`
+	// Enum names an integer value, it is ignored when decoding a value.
+	Enum      string `json:"enum,omitempty"`
+	Encrypted bool   `json:"encrypted,omitempty"`
+	Raw       string `json:"raw,omitempty"`
+}
+
+func MarshalPacketJSON(p *radius.Packet, dict *dictionary.Dictionary) ([]byte, error) {
+	return json.Marshal(JSONPacket{Packet: p, Dictionary: dict})
+}
+
+// UnmarshalPacketJSON decodes a packet, which gets the secret. Attributes
+// are looked up by type, or by name when it is missing.
+func UnmarshalPacketJSON(b []byte, secret []byte, dict *dictionary.Dictionary) (*radius.Packet, error) {
+	p := JSONPacket{
+		Packet:     &radius.Packet{Secret: secret},
+		Dictionary: dict,
+	}
+	if err := json.Unmarshal(b, &p); err != nil {
+		return nil, err
+	}
+	return p.Packet, nil
+}
+
+func (p JSONPacket) dictionary() *dictionary.Dictionary {
+	if p.Dictionary == nil {
+		return DefaultDictionary
+	}
+	return p.Dictionary
+}
+
+func (p JSONPacket) MarshalJSON() ([]byte, error) {
+	if p.Packet == nil {
+		return []byte("null"), nil
+	}
+	dict := p.dictionary()
+
+	packet := jsonPacket{
+		Code:          p.Code.String(),
+		Identifier:    p.Identifier,
+		Authenticator: hex.EncodeToString(p.Authenticator[:]),
+		Attributes:    make([]jsonAttribute, 0, len(p.Attributes)),
+	}
+
+	for _, a := range dictAttributes(p.Packet, dict) {
+		attr := jsonAttribute{
+			Name:   a.name,
+			Vendor: a.vendor,
+			Type:   a.typ,
+			Tag:    a.tag,
+		}
+		if a.vendor != 0 {
+			if vendor := dictionary.VendorByNumber(dict.Vendors, int(a.vendor)); vendor != nil {
+				attr.VendorName = vendor.Name
+			}
+		}
+
+		var value interface{}
+		var ok bool
+		if a.attr != nil && !a.attr.FlagEncrypt.Valid {
+			value, attr.Enum, ok = jsonValue(a.attr, a.values, a.value)
+		}
+		if ok {
+			b, err := json.Marshal(value)
+			if err != nil {
+				return nil, err
+			}
+			attr.Value = b
+		} else {
+			attr.Encrypted = a.attr != nil && a.attr.FlagEncrypt.Valid
+			attr.Raw = hex.EncodeToString(a.value)
+		}
+
+		packet.Attributes = append(packet.Attributes, attr)
+	}
+
+	return json.Marshal(packet)
+}
+
+// UnmarshalJSON decodes the packet, keeping the secret of the one already
+// held if any.
+func (p *JSONPacket) UnmarshalJSON(b []byte) error {
+	var packet jsonPacket
+	if err := json.Unmarshal(b, &packet); err != nil {
+		return err
+	}
+	dict := p.dictionary()
+
+	decoded := &radius.Packet{
+		Identifier: packet.Identifier,
+		Attributes: make(radius.Attributes, 0, len(packet.Attributes)),
+	}
+	if p.Packet != nil {
+		decoded.Secret = p.Packet.Secret
+	}
+
+	code, err := parseCode(packet.Code)
+	if err != nil {
+		return err
+	}
+	decoded.Code = code
+
+	authenticator, err := hex.DecodeString(packet.Authenticator)
+	if err != nil || len(authenticator) != len(decoded.Authenticator) {
+		return fmt.Errorf("invalid authenticator: %q", packet.Authenticator)
+	}
+	copy(decoded.Authenticator[:], authenticator)
+
+	for i := range packet.Attributes {
+		if err := addJSONAttribute(decoded, dict, &packet.Attributes[i]); err != nil {
+			return err
+		}
+	}
+
+	p.Packet = decoded
+	return nil
+}
+
+func parseCode(s string) (radius.Code, error) {
+	for code := 0; code < 256; code++ {
+		if radius.Code(code).String() == s {
+			return radius.Code(code), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown code: %q", s)
+}
+
+func addJSONAttribute(p *radius.Packet, dict *dictionary.Dictionary, a *jsonAttribute) error {
+	attrs, values := dict.Attributes, dict.Values
+	if a.Vendor != 0 {
+		attrs, values = nil, nil
+		if vendor := dictionary.VendorByNumber(dict.Vendors, int(a.Vendor)); vendor != nil {
+			attrs, values = vendor.Attributes, vendor.Values
+		}
+	}
+
+	var attr *dictionary.Attribute
+	if a.Type == 0 {
+		if attr = dictionary.AttributeByName(attrs, a.Name); attr == nil {
+			return fmt.Errorf("unknown attribute: %q", a.Name)
+		}
+		if len(attr.OID) != 1 || attr.OID[0] < 1 || attr.OID[0] > 255 {
+			return fmt.Errorf("unsupported attribute: %q", a.Name)
+		}
+		a.Type = uint8(attr.OID[0])
+	} else {
+		attr = dictionary.AttributeByOID(attrs, dictionary.OID{int(a.Type)})
+	}
+
+	var value radius.Attribute
+	var err error
+	switch {
+	case len(a.Value) > 0:
+		if attr == nil {
+			return fmt.Errorf("attribute %s has a value without a type, raw is required", jsonAttributeName(a))
+		}
+		value, err = parseJSONValue(attr, values, a.Value)
+	default:
+		value, err = hex.DecodeString(strings.TrimPrefix(a.Raw, "0x"))
+	}
+	if err != nil {
+		return fmt.Errorf("attribute %s: %s", jsonAttributeName(a), err)
+	}
+
+	if a.Tag != nil {
+		if attr != nil && attr.Type == dictionary.AttributeInteger && len(value) == 4 {
+			value[0] = *a.Tag
+		} else {
+			value = append(radius.Attribute{*a.Tag}, value...)
+		}
+	}
+
+	if a.Vendor == 0 {
+		p.Add(radius.Type(a.Type), value)
+		return nil
+	}
+
+	if len(value) > 253 {
+		return fmt.Errorf("attribute %s is too long", jsonAttributeName(a))
+	}
+	vendor := make(radius.Attribute, 2+len(value))
+	vendor[0] = a.Type
+	vendor[1] = byte(len(vendor))
+	copy(vendor[2:], value)
+
+	vsa, err := radius.NewVendorSpecific(a.Vendor, vendor)
+	if err != nil {
+		return fmt.Errorf("attribute %s: %s", jsonAttributeName(a), err)
+	}
+	p.Add(rfc2865.VendorSpecific_Type, vsa)
+
+	return nil
+}
+
+func jsonAttributeName(a *jsonAttribute) string {
+	if len(a.Name) > 0 {
+		return a.Name
+	}
+	if a.Vendor != 0 {
+		return fmt.Sprintf("Attr-26.%d.%d", a.Vendor, a.Type)
+	}
+	return "Attr-" + strconv.Itoa(int(a.Type))
+}
+
+// jsonValue decodes the attribute by its type, ok is false when it does not
+// match the type.
+func jsonValue(attr *dictionary.Attribute, values []*dictionary.Value, a radius.Attribute) (value interface{}, enum string, ok bool) {
+	switch attr.Type {
+	case dictionary.AttributeString:
+		// JSON strings would not keep invalid UTF-8
+		if utf8.Valid(a) {
+			return string(a), "", true
+		}
+	case dictionary.AttributeOctets:
+		return hex.EncodeToString(a), "", true
+	case dictionary.AttributeInteger:
+		if i, err := radius.Integer(a); err == nil {
+			// the last name of a value wins like with FreeRADIUS, which
+			// prints Interim-Update rather than the older Alive
+			for _, v := range dictionary.ValuesByAttribute(values, attr.Name) {
+				if v.Number == uint64(i) {
+					enum = v.Name
+				}
+			}
+			return i, enum, true
+		}
+	case dictionary.AttributeInteger64:
+		if i, err := radius.Integer64(a); err == nil {
+			return i, "", true
+		}
+	case dictionary.AttributeShort:
+		if i, err := radius.Short(a); err == nil {
+			return i, "", true
+		}
+	case dictionary.AttributeByte:
+		if len(a) == 1 {
+			return a[0], "", true
+		}
+	case dictionary.AttributeSigned:
+		if len(a) == 4 {
+			return int32(binary.BigEndian.Uint32(a)), "", true
+		}
+	case dictionary.AttributeDate:
+		if t, err := radius.Date(a); err == nil {
+			return t.UTC().Format(time.RFC3339), "", true
+		}
+	case dictionary.AttributeIPAddr:
+		if ip, err := radius.IPAddr(a); err == nil {
+			return ip.String(), "", true
+		}
+	case dictionary.AttributeIPv6Addr:
+		if ip, err := radius.IPv6Addr(a); err == nil {
+			return ip.String(), "", true
+		}
+	case dictionary.AttributeIPv6Prefix:
+		// the prefix is kept raw unless it encodes the same again
+		if prefix, err := radius.IPv6Prefix(a); err == nil {
+			if b, err := radius.NewIPv6Prefix(prefix); err == nil && string(b) == string(a) {
+				return prefix.String(), "", true
+			}
+		}
+	case dictionary.AttributeIFID:
+		if id, err := radius.IFID(a); err == nil {
+			return id.String(), "", true
+		}
+	case dictionary.AttributeEther:
+		if len(a) == 6 {
+			return net.HardwareAddr(a).String(), "", true
+		}
+	}
+
+	return nil, "", false
+}
+
+func parseJSONValue(attr *dictionary.Attribute, values []*dictionary.Value, raw json.RawMessage) (radius.Attribute, error) {
+	switch attr.Type {
+	case dictionary.AttributeInteger:
+		var name string
+		if json.Unmarshal(raw, &name) == nil {
+			for _, v := range dictionary.ValuesByAttribute(values, attr.Name) {
+				if v.Name == name {
+					return radius.NewInteger(uint32(v.Number)), nil
+				}
+			}
+			return nil, fmt.Errorf("unknown value: %q", name)
+		}
+		var i uint32
+		if err := json.Unmarshal(raw, &i); err != nil {
+			return nil, err
+		}
+		return radius.NewInteger(i), nil
+	case dictionary.AttributeInteger64:
+		var i uint64
+		if err := json.Unmarshal(raw, &i); err != nil {
+			return nil, err
+		}
+		return radius.NewInteger64(i), nil
+	case dictionary.AttributeShort:
+		var i uint16
+		if err := json.Unmarshal(raw, &i); err != nil {
+			return nil, err
+		}
+		return radius.NewShort(i), nil
+	case dictionary.AttributeByte:
+		var i uint8
+		if err := json.Unmarshal(raw, &i); err != nil {
+			return nil, err
+		}
+		return radius.Attribute{i}, nil
+	case dictionary.AttributeSigned:
+		var i int32
+		if err := json.Unmarshal(raw, &i); err != nil {
+			return nil, err
+		}
+		return radius.NewInteger(uint32(i)), nil
+	}
+
+	var s string
+	if err := json.Unmarshal(raw, &s); err != nil {
+		return nil, err
+	}
+
+	switch attr.Type {
+	case dictionary.AttributeString:
+		return radius.NewString(s)
+	case dictionary.AttributeOctets:
+		return hex.DecodeString(strings.TrimPrefix(s, "0x"))
+	case dictionary.AttributeDate:
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			return nil, err
+		}
+		return radius.NewDate(t)
+	case dictionary.AttributeIPAddr:
+		ip := net.ParseIP(s)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid IP address: %q", s)
+		}
+		return radius.NewIPAddr(ip)
+	case dictionary.AttributeIPv6Addr:
+		ip := net.ParseIP(s)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid IP address: %q", s)
+		}
+		return radius.NewIPv6Addr(ip)
+	case dictionary.AttributeIPv6Prefix:
+		_, prefix, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, err
+		}
+		return radius.NewIPv6Prefix(prefix)
+	case dictionary.AttributeIFID:
+		mac, err := net.ParseMAC(s)
+		if err != nil {
+			return nil, err
+		}
+		return radius.NewIFID(mac)
+	case dictionary.AttributeEther:
+		mac, err := net.ParseMAC(s)
+		if err != nil {
+			return nil, err
+		}
+		if len(mac) != 6 {
+			return nil, fmt.Errorf("invalid MAC address: %q", s)
+		}
+		return radius.Attribute(mac), nil
+	}
+
+	return nil, fmt.Errorf("unsupported type: %s", attr.Type)
+}
@@ -0,0 +1,166 @@
+package libradius
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"layeh.com/radius"
+	"layeh.com/radius/rfc2865"
+	"layeh.com/radius/rfc2866"
+	"layeh.com/radius/rfc2868"
+)
+
+func TestPacketJSONRoundTrip(t *testing.T) {
+	secret := []byte("testing123")
+
+	p := radius.New(radius.CodeAccessRequest, secret)
+	p.Identifier = 42
+	rfc2865.UserName_SetString(p, "bob")
+	rfc2865.UserPassword_SetString(p, "password12345678")
+	rfc2865.CallingStationID_Set(p, []byte{0xff, 0xfe, 'a'})
+	rfc2866.AcctStatusType_Set(p, rfc2866.AcctStatusType_Value_InterimUpdate)
+	rfc2868.TunnelType_Set(p, 1, 13)
+	rfc2868.TunnelPrivateGroupID_SetString(p, 1, "100")
+	rfc2868.TunnelPassword_SetString(p, 2, "tunnel")
+	WimarkClientGroup_AddString(p, "guests")
+	AddVSAString(p, 9999, 1, "unknown vendor")
+	p.Add(200, radius.Attribute{1, 2, 3})
+
+	b, err := MarshalPacketJSON(p, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var encoded jsonPacket
+	if err := json.Unmarshal(b, &encoded); err != nil {
+		t.Fatal(err)
+	}
+	if encoded.Code != "Access-Request" || encoded.Identifier != 42 {
+		t.Errorf("got %s Id %d", encoded.Code, encoded.Identifier)
+	}
+	byName := make(map[string]jsonAttribute)
+	for _, a := range encoded.Attributes {
+		byName[jsonAttributeName(&a)] = a
+	}
+	checks := []struct {
+		name      string
+		value     string
+		tag       int
+		enum      string
+		encrypted bool
+		raw       bool
+	}{
+		{name: "User-Name", value: `"bob"`},
+		{name: "User-Password", encrypted: true, raw: true},
+		{name: "Calling-Station-Id", raw: true},
+		{name: "Acct-Status-Type", value: "3", enum: "Interim-Update"},
+		{name: "Tunnel-Type", value: "13", tag: 1, enum: "VLAN"},
+		{name: "Tunnel-Private-Group-Id", value: `"100"`, tag: 1},
+		{name: "Tunnel-Password", tag: 2, encrypted: true, raw: true},
+		{name: "Wimark-Client-Group", value: `"guests"`},
+		{name: "Attr-26.9999.1", raw: true},
+		{name: "Attr-200", raw: true},
+	}
+	for _, check := range checks {
+		a, ok := byName[check.name]
+		if !ok {
+			t.Errorf("%s not encoded", check.name)
+			continue
+		}
+		if string(a.Value) != check.value || a.Enum != check.enum || a.Encrypted != check.encrypted || (len(a.Raw) > 0) != check.raw {
+			t.Errorf("%s encoded as %+v", check.name, a)
+		}
+		var tag int
+		if a.Tag != nil {
+			tag = int(*a.Tag)
+		}
+		if tag != check.tag {
+			t.Errorf("%s has tag %d, want %d", check.name, tag, check.tag)
+		}
+	}
+
+	decoded, err := UnmarshalPacketJSON(b, secret, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Code != p.Code || decoded.Identifier != p.Identifier || decoded.Authenticator != p.Authenticator {
+		t.Errorf("header %s Id %d, want %s Id %d", decoded.Code, decoded.Identifier, p.Code, p.Identifier)
+	}
+	if len(decoded.Attributes) != len(p.Attributes) {
+		t.Fatalf("%d attributes, want %d", len(decoded.Attributes), len(p.Attributes))
+	}
+	for i, a := range p.Attributes {
+		if decoded.Attributes[i].Type != a.Type || !bytes.Equal(decoded.Attributes[i].Attribute, a.Attribute) {
+			t.Errorf("attribute %d: got %d %x, want %d %x", i, decoded.Attributes[i].Type, decoded.Attributes[i].Attribute, a.Type, a.Attribute)
+		}
+	}
+	if password := rfc2865.UserPassword_GetString(decoded); password != "password12345678" {
+		t.Errorf("User-Password = %q", password)
+	}
+}
+
+func TestPacketJSONSplitsVSA(t *testing.T) {
+	p := radius.New(radius.CodeAccessAccept, []byte("testing123"))
+	vsa, err := radius.NewVendorSpecific(VendorWimark, radius.Attribute{3, 8, 's', 't', 'a', 'f', 'f', 's', 4, 6, 0, 0, 0x0e, 0x10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Add(rfc2865.VendorSpecific_Type, vsa)
+
+	b, err := MarshalPacketJSON(p, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := UnmarshalPacketJSON(b, p.Secret, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(decoded.Attributes) != 2 {
+		t.Fatalf("%d attributes, want a Vendor-Specific attribute each", len(decoded.Attributes))
+	}
+	if group := WimarkClientGroup_GetString(decoded); group != "staffs" {
+		t.Errorf("Wimark-Client-Group = %q", group)
+	}
+	if timeout := WimarkSessionTimeout_Get(decoded); timeout != 3600 {
+		t.Errorf("Wimark-Session-Timeout = %d", timeout)
+	}
+}
+
+func TestPacketJSONByName(t *testing.T) {
+	b := []byte(`{"code":"CoA-Request","identifier":1,"authenticator":"00000000000000000000000000000000","attributes":[
+		{"name":"Acct-Session-Id","value":"session"},
+		{"name":"Acct-Status-Type","value":"Alive"},
+		{"name":"Tunnel-Type","tag":1,"value":"VLAN"},
+		{"name":"Wimark-Client-Group","vendor":52400,"value":"guests"}]}`)
+
+	p, err := UnmarshalPacketJSON(b, []byte("testing123"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id := rfc2866.AcctSessionID_GetString(p); id != "session" {
+		t.Errorf("Acct-Session-Id = %q", id)
+	}
+	if status := rfc2866.AcctStatusType_Get(p); status != rfc2866.AcctStatusType_Value_InterimUpdate {
+		t.Errorf("Acct-Status-Type = %d", status)
+	}
+	if tag, value, err := rfc2868.TunnelType_Lookup(p); err != nil || tag != 1 || value != 13 {
+		t.Errorf("Tunnel-Type = %d:%d (%v)", tag, value, err)
+	}
+	if group := WimarkClientGroup_GetString(p); group != "guests" {
+		t.Errorf("Wimark-Client-Group = %q", group)
+	}
+
+	for _, attr := range []string{
+		`{"name":"No-Such-Attribute","value":"x"}`,
+		`{"type":200,"value":"x"}`,
+		`{"name":"Acct-Status-Type","value":"No-Such-Value"}`,
+		`{"name":"Framed-IP-Address","value":"not an address"}`,
+	} {
+		b := []byte(`{"code":"Access-Request","identifier":1,"authenticator":"00000000000000000000000000000000","attributes":[` + attr + `]}`)
+		if _, err := UnmarshalPacketJSON(b, nil, nil); err == nil {
+			t.Errorf("%s decoded", attr)
+		}
+	}
+}
@@ -0,0 +1,346 @@
+package libradius
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"layeh.com/radius"
+	"layeh.com/radius/rfc2866"
+)
+
+type PoolStrategy string
+
+const (
+	PoolFailover   PoolStrategy = "failover"
+	PoolRoundRobin PoolStrategy = "round-robin"
+	PoolWeighted   PoolStrategy = "weighted"
+)
+
+const (
+	defaultPoolMaxFailures    = 3
+	defaultPoolReviveInterval = 30 * time.Second
+	defaultPoolStickyTTL      = 24 * time.Hour
+)
+
+var ErrNoPoolServer = errors.New("no RADIUS server in pool")
+
+type PoolServer struct {
+	Server *RadiusServerConfig `json:"server"`
+	Weight int                 `json:"weight"`
+}
+
+type ServerPoolConfig struct {
+	Strategy PoolStrategy `json:"strategy"`
+	Servers  []PoolServer `json:"servers"`
+	// Timeout bounds each attempt, the context of Send bounds them all.
+	Timeout time.Duration `json:"timeout"`
+	// MaxFailures consecutive failed attempts mark a server dead. Dead servers are
+	// probed with Status-Server every ReviveInterval, or simply revived
+	// after it when DisableProbe is set for servers without RFC 5997.
+	MaxFailures    int           `json:"max_failures"`
+	ReviveInterval time.Duration `json:"revive_interval"`
+	DisableProbe   bool          `json:"disable_probe"`
+	// StickyTTL is how long accounting of a session without updates keeps
+	// going to the same server.
+	StickyTTL time.Duration `json:"sticky_ttl"`
+}
+
+func NewServerPoolConfig(strategy PoolStrategy, servers ...*RadiusServerConfig) *ServerPoolConfig {
+	cfg := &ServerPoolConfig{
+		Strategy:       strategy,
+		Timeout:        defaultSendRadiusPacketTimeout,
+		MaxFailures:    defaultPoolMaxFailures,
+		ReviveInterval: defaultPoolReviveInterval,
+		StickyTTL:      defaultPoolStickyTTL,
+	}
+	for _, server := range servers {
+		cfg.Servers = append(cfg.Servers, PoolServer{Server: server, Weight: 1})
+	}
+	return cfg
+}
+
+type PoolServerStatus struct {
+	Addr     string `json:"addr"`
+	Alive    bool   `json:"alive"`
+	Failures int    `json:"failures"`
+}
+
+type poolServer struct {
+	cfg    *RadiusServerConfig
+	weight int
+
+	// guarded by ServerPool.mu
+	alive         bool
+	failures      int
+	currentWeight int
+}
+
+type stickyEntry struct {
+	server *poolServer
+	used   time.Time
+}
+
+// ServerPool sends requests to a group of RADIUS servers with failover and
+// load balancing. Accounting for an Acct-Session-Id sticks to one server.
+type ServerPool struct {
+	cfg     ServerPoolConfig
+	servers []*poolServer
+
+	mu          sync.Mutex
+	next        int
+	sticky      map[string]*stickyEntry
+	lastCleanup time.Time
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func NewServerPool(cfg *ServerPoolConfig) *ServerPool {
+	ctx, cancel := context.WithCancel(context.Background())
+	pool := &ServerPool{
+		cfg:    *cfg,
+		sticky: make(map[string]*stickyEntry),
+		ctx:    ctx,
+		cancel: cancel,
+	}
+
+	if pool.cfg.Timeout <= 0 {
+		pool.cfg.Timeout = defaultSendRadiusPacketTimeout
+	}
+	if pool.cfg.MaxFailures <= 0 {
+		pool.cfg.MaxFailures = defaultPoolMaxFailures
+	}
+	if pool.cfg.ReviveInterval <= 0 {
+		pool.cfg.ReviveInterval = defaultPoolReviveInterval
+	}
+	if pool.cfg.StickyTTL <= 0 {
+		pool.cfg.StickyTTL = defaultPoolStickyTTL
+	}
+
+	for _, server := range cfg.Servers {
+		weight := server.Weight
+		if weight <= 0 {
+			weight = 1
+		}
+		pool.servers = append(pool.servers, &poolServer{
+			cfg:    server.Server,
+			weight: weight,
+			alive:  true,
+		})
+	}
+
+	return pool
+}
+
+// Close stops probing dead servers.
+func (p *ServerPool) Close() error {
+	p.cancel()
+	p.wg.Wait()
+	return nil
+}
+
+func (p *ServerPool) Status() []PoolServerStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	status := make([]PoolServerStatus, 0, len(p.servers))
+	for _, server := range p.servers {
+		status = append(status, PoolServerStatus{
+			Addr:     server.cfg.GetAddr(),
+			Alive:    server.alive,
+			Failures: server.failures,
+		})
+	}
+	return status
+}
+
+// Send sends the packet to the servers of the pool until one answers. The
+// packet may be built with any secret: hidden attributes are encrypted again
+// for the secret of every server tried.
+func (p *ServerPool) Send(ctx context.Context, packet *radius.Packet) (*radius.Packet, error) {
+	sessionID := ""
+	if packet.Code == radius.CodeAccountingRequest {
+		sessionID = rfc2866.AcctSessionID_GetString(packet)
+	}
+
+	candidates := p.candidates(sessionID)
+	if len(candidates) == 0 {
+		return nil, ErrNoPoolServer
+	}
+
+	var lastErr error
+	for _, server := range candidates {
+		request, err := p.prepare(packet, server)
+		if err != nil {
+			return nil, err
+		}
+
+		attemptCtx, cancel := context.WithTimeout(ctx, p.cfg.Timeout)
+		response, err := exchange(attemptCtx, radius.DefaultClient, request, server.cfg.GetAddr(), nil)
+		cancel()
+
+		if err == nil {
+			p.succeeded(server, sessionID, packet)
+			return response, nil
+		}
+		lastErr = err
+
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		p.failed(server)
+	}
+
+	return nil, fmt.Errorf("no RADIUS server in pool answered: %w", lastErr)
+}
+
+func (p *ServerPool) prepare(packet *radius.Packet, server *poolServer) (*radius.Packet, error) {
+	return packetWithSecret(packet, []byte(server.cfg.Secret))
+}
+
+// candidates orders the servers to try: the sticky server of the session,
+// then the alive servers by strategy, and the dead ones as a last resort.
+func (p *ServerPool) candidates(sessionID string) []*poolServer {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var alive, dead []*poolServer
+	for _, server := range p.servers {
+		if server.alive {
+			alive = append(alive, server)
+		} else {
+			dead = append(dead, server)
+		}
+	}
+
+	switch p.cfg.Strategy {
+	case PoolRoundRobin:
+		if len(alive) > 0 {
+			start := p.next % len(alive)
+			p.next++
+			alive = append(alive[start:], alive[:start]...)
+		}
+	case PoolWeighted:
+		if first := p.pickWeightedLocked(alive); first != nil {
+			ordered := []*poolServer{first}
+			for _, server := range alive {
+				if server != first {
+					ordered = append(ordered, server)
+				}
+			}
+			alive = ordered
+		}
+	}
+
+	if entry, ok := p.sticky[sessionID]; ok && len(sessionID) > 0 && entry.server.alive {
+		ordered := []*poolServer{entry.server}
+		for _, server := range alive {
+			if server != entry.server {
+				ordered = append(ordered, server)
+			}
+		}
+		alive = ordered
+	}
+
+	return append(alive, dead...)
+}
+
+// pickWeightedLocked is the smooth weighted round-robin of nginx: it spreads
+// the picks of a server evenly instead of in bursts.
+func (p *ServerPool) pickWeightedLocked(servers []*poolServer) *poolServer {
+	var best *poolServer
+	var total int
+	for _, server := range servers {
+		server.currentWeight += server.weight
+		total += server.weight
+		if best == nil || server.currentWeight > best.currentWeight {
+			best = server
+		}
+	}
+	if best != nil {
+		best.currentWeight -= total
+	}
+	return best
+}
+
+func (p *ServerPool) succeeded(server *poolServer, sessionID string, packet *radius.Packet) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	server.failures = 0
+	server.alive = true
+
+	if len(sessionID) == 0 {
+		return
+	}
+
+	now := time.Now()
+	if status, err := rfc2866.AcctStatusType_Lookup(packet); err == nil && status == rfc2866.AcctStatusType_Value_Stop {
+		delete(p.sticky, sessionID)
+	} else {
+		p.sticky[sessionID] = &stickyEntry{server: server, used: now}
+	}
+
+	if now.Sub(p.lastCleanup) > p.cfg.StickyTTL/10 {
+		p.lastCleanup = now
+		for key, entry := range p.sticky {
+			if now.Sub(entry.used) > p.cfg.StickyTTL {
+				delete(p.sticky, key)
+			}
+		}
+	}
+}
+
+func (p *ServerPool) failed(server *poolServer) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	server.failures++
+	if !server.alive || server.failures < p.cfg.MaxFailures {
+		return
+	}
+
+	server.alive = false
+
+	p.wg.Add(1)
+	go p.revive(server)
+}
+
+// revive waits for a dead server to answer a Status-Server, or for the
+// revive interval when probing is disabled.
+func (p *ServerPool) revive(server *poolServer) {
+	defer p.wg.Done()
+
+	ticker := time.NewTicker(p.cfg.ReviveInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if !p.cfg.DisableProbe && !p.probe(server) {
+			continue
+		}
+
+		p.mu.Lock()
+		server.alive = true
+		server.failures = 0
+		p.mu.Unlock()
+		return
+	}
+}
+
+func (p *ServerPool) probe(server *poolServer) bool {
+	ctx, cancel := context.WithTimeout(p.ctx, p.cfg.Timeout)
+	defer cancel()
+
+	_, err := Ping(ctx, server.cfg.GetAddr(), server.cfg.Secret)
+	return err == nil
+}
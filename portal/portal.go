@@ -0,0 +1,102 @@
+// Package portal builds the Wimark CoA requests of a captive portal: clients
+// are redirected to the portal until they log in, authorized after it and
+// revoked on logout.
+package portal
+
+import (
+	"context"
+	"time"
+
+	"github.com/wimark/libradius"
+)
+
+// Client is a session of a wireless client on a Wimark NAS.
+type Client struct {
+	// Addr is the dynamic authorization address of the NAS, host:3799.
+	Addr            string `json:"addr"`
+	Secret          string `json:"-"`
+	AcctSessionID   string `json:"acct_session_id"`
+	FramedIPAddress string `json:"framed_ip_address"`
+}
+
+func ClientFromSession(session *libradius.Session, secret string) *Client {
+	return &Client{
+		Addr:            session.DynamicAuthAddr(),
+		Secret:          secret,
+		AcctSessionID:   session.Key.SessionID,
+		FramedIPAddress: session.FramedIPAddress,
+	}
+}
+
+// Authorization is granted to a client after the portal login.
+type Authorization struct {
+	ClientGroup          string        `json:"client_group"`
+	SessionTimeout       time.Duration `json:"session_timeout"`
+	IdleTimeout          time.Duration `json:"idle_timeout"`
+	WimarkSessionTimeout time.Duration `json:"wimark_session_timeout"`
+}
+
+func (c *Client) request() libradius.CoaRequest {
+	return libradius.CoaRequest{
+		FramedIPAddress: c.FramedIPAddress,
+		AcctSessionID:   c.AcctSessionID,
+	}
+}
+
+// AuthorizeRequest lifts the redirect of the client and applies the
+// authorization.
+func AuthorizeRequest(client *Client, auth Authorization) libradius.CoaRequest {
+	request := client.request()
+	request.SessionTimeout = int(auth.SessionTimeout / time.Second)
+	request.IdleTimeout = int(auth.IdleTimeout / time.Second)
+
+	request.VSAList = append(request.VSAList, alwaysRedirect(false))
+	if len(auth.ClientGroup) > 0 {
+		request.VSAList = append(request.VSAList, libradius.VSAEntity{
+			Vendor:      libradius.VendorWimark,
+			Attr:        uint8(libradius.WimarkAVPTypeClientStr),
+			ValueString: auth.ClientGroup,
+		})
+	}
+	if auth.WimarkSessionTimeout > 0 {
+		request.VSAList = append(request.VSAList, libradius.VSAEntity{
+			Vendor:   libradius.VendorWimark,
+			Attr:     uint8(libradius.WimarkAVPTypeSessionInt),
+			ValueInt: int(auth.WimarkSessionTimeout / time.Second),
+		})
+	}
+
+	return request
+}
+
+// RedirectRequest sends every request of the client to the portal.
+func RedirectRequest(client *Client) libradius.CoaRequest {
+	request := client.request()
+	request.VSAList = append(request.VSAList, alwaysRedirect(true))
+	return request
+}
+
+func alwaysRedirect(redirect bool) libradius.VSAEntity {
+	vsa := libradius.VSAEntity{
+		Vendor: libradius.VendorWimark,
+		Attr:   uint8(libradius.WimarkAVPTypeAlwaysRedirect),
+	}
+	if redirect {
+		vsa.ValueInt = 1
+	}
+	return vsa
+}
+
+func AuthorizeClient(ctx context.Context, client *Client, auth Authorization) error {
+	return libradius.SendCoAContext(ctx, client.Addr, client.Secret, AuthorizeRequest(client, auth))
+}
+
+func RedirectClient(ctx context.Context, client *Client) error {
+	return libradius.SendCoAContext(ctx, client.Addr, client.Secret, RedirectRequest(client))
+}
+
+// RevokeClient disconnects the client, which starts over behind the portal
+// when it connects again.
+func RevokeClient(ctx context.Context, client *Client) error {
+	return libradius.SendDisconnect(ctx, client.Addr, client.Secret, client.request())
+}
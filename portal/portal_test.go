@@ -0,0 +1,144 @@
+package portal
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"layeh.com/radius"
+	"layeh.com/radius/rfc2865"
+
+	"github.com/wimark/libradius"
+)
+
+// startNAS answers every dynamic authorization request with an ACK and
+// hands the received packets to the test.
+func startNAS(t *testing.T, secret string) (string, <-chan *radius.Packet) {
+	t.Helper()
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	received := make(chan *radius.Packet, 1)
+	server := &radius.PacketServer{
+		SecretSource: radius.StaticSecretSource([]byte(secret)),
+		Handler: libradius.NewDynamicAuthServer(func(ctx context.Context, request *libradius.DynamicAuthRequest) error {
+			received <- request.Packet
+			return nil
+		}),
+	}
+	go server.Serve(conn)
+	t.Cleanup(func() { server.Shutdown(context.Background()) })
+
+	return conn.LocalAddr().String(), received
+}
+
+func TestPortalRequests(t *testing.T) {
+	addr, received := startNAS(t, "testing123")
+	client := &Client{
+		Addr:            addr,
+		Secret:          "testing123",
+		AcctSessionID:   "session",
+		FramedIPAddress: "10.0.0.2",
+	}
+
+	tests := []struct {
+		name string
+		send func(ctx context.Context) error
+
+		code           radius.Code
+		redirect       bool
+		clientGroup    string
+		sessionTimeout int
+		idleTimeout    int
+		wimarkTimeout  int
+	}{
+		{
+			name: "authorize",
+			send: func(ctx context.Context) error {
+				return AuthorizeClient(ctx, client, Authorization{
+					ClientGroup:          "guests",
+					SessionTimeout:       time.Hour,
+					IdleTimeout:          10 * time.Minute,
+					WimarkSessionTimeout: 2 * time.Hour,
+				})
+			},
+			code:           radius.CodeCoARequest,
+			clientGroup:    "guests",
+			sessionTimeout: 3600,
+			idleTimeout:    600,
+			wimarkTimeout:  7200,
+		},
+		{
+			name: "authorize without limits",
+			send: func(ctx context.Context) error {
+				return AuthorizeClient(ctx, client, Authorization{})
+			},
+			code: radius.CodeCoARequest,
+		},
+		{
+			name:     "redirect",
+			send:     func(ctx context.Context) error { return RedirectClient(ctx, client) },
+			code:     radius.CodeCoARequest,
+			redirect: true,
+		},
+		{
+			name: "revoke",
+			send: func(ctx context.Context) error { return RevokeClient(ctx, client) },
+			code: radius.CodeDisconnectRequest,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if err := test.send(ctx); err != nil {
+				t.Fatal(err)
+			}
+			p := <-received
+
+			if p.Code != test.code {
+				t.Errorf("sent %s, want %s", p.Code, test.code)
+			}
+			if ip := rfc2865.FramedIPAddress_Get(p); !ip.Equal(net.ParseIP("10.0.0.2")) {
+				t.Errorf("Framed-IP-Address = %s", ip)
+			}
+
+			_, err := libradius.WimarkAlwaysRedirect_Lookup(p)
+			switch {
+			case test.code == radius.CodeDisconnectRequest:
+				if err == nil {
+					t.Error("Wimark-Always-Redirect sent in a Disconnect-Request")
+				}
+			case err != nil:
+				t.Error("Wimark-Always-Redirect not sent")
+			}
+			if redirect := libradius.WimarkAlwaysRedirect_Get(p) == 1; redirect != test.redirect {
+				t.Errorf("Wimark-Always-Redirect = %t, want %t", redirect, test.redirect)
+			}
+			if group := libradius.WimarkClientGroup_GetString(p); group != test.clientGroup {
+				t.Errorf("Wimark-Client-Group = %q, want %q", group, test.clientGroup)
+			}
+			if timeout := int(libradius.WimarkSessionTimeout_Get(p)); timeout != test.wimarkTimeout {
+				t.Errorf("Wimark-Session-Timeout = %d, want %d", timeout, test.wimarkTimeout)
+			}
+
+			// zero timeouts are left out rather than sent as 0
+			if _, err := rfc2865.SessionTimeout_Lookup(p); (err == nil) != (test.sessionTimeout > 0) {
+				t.Errorf("Session-Timeout sent: %t, want %t", err == nil, test.sessionTimeout > 0)
+			}
+			if timeout := int(rfc2865.SessionTimeout_Get(p)); timeout != test.sessionTimeout {
+				t.Errorf("Session-Timeout = %d, want %d", timeout, test.sessionTimeout)
+			}
+			if _, err := rfc2865.IdleTimeout_Lookup(p); (err == nil) != (test.idleTimeout > 0) {
+				t.Errorf("Idle-Timeout sent: %t, want %t", err == nil, test.idleTimeout > 0)
+			}
+			if timeout := int(rfc2865.IdleTimeout_Get(p)); timeout != test.idleTimeout {
+				t.Errorf("Idle-Timeout = %d, want %d", timeout, test.idleTimeout)
+			}
+		})
+	}
+}
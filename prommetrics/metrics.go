@@ -0,0 +1,195 @@
+package prommetrics
+
+import (
+	"net"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/wimark/libradius"
+	"layeh.com/radius"
+)
+
+const (
+	sideClient = "client"
+	sideServer = "server"
+)
+
+var _ libradius.Metrics = (*Metrics)(nil)
+
+// Metrics implements libradius.Metrics on top of Prometheus collectors.
+// Register it with a prometheus.Registerer and install it with
+// libradius.SetMetrics.
+type Metrics struct {
+	clientRequests          *prometheus.CounterVec
+	clientResponses         *prometheus.CounterVec
+	clientLatency           *prometheus.HistogramVec
+	clientTimeouts          *prometheus.CounterVec
+	clientRetransmits       *prometheus.CounterVec
+	clientBadAuthenticators *prometheus.CounterVec
+
+	serverRequests          *prometheus.CounterVec
+	serverResponses         *prometheus.CounterVec
+	serverLatency           *prometheus.HistogramVec
+	serverBadAuthenticators *prometheus.CounterVec
+	serverUnknownClients    prometheus.Counter
+
+	coaResults *prometheus.CounterVec
+}
+
+func New(namespace string) *Metrics {
+	return &Metrics{
+		clientRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: sideClient,
+			Name:      "requests_total",
+			Help:      "RADIUS requests sent, by server and request code.",
+		}, []string{"server", "code"}),
+		clientResponses: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: sideClient,
+			Name:      "responses_total",
+			Help:      "RADIUS responses received, by server and response code.",
+		}, []string{"server", "code"}),
+		clientLatency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: sideClient,
+			Name:      "request_duration_seconds",
+			Help:      "Time from sending a RADIUS request to receiving its response.",
+			Buckets:   prometheus.ExponentialBuckets(0.001, 2, 14),
+		}, []string{"server", "code"}),
+		clientTimeouts: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: sideClient,
+			Name:      "timeouts_total",
+			Help:      "RADIUS requests that timed out without a response.",
+		}, []string{"server", "code"}),
+		clientRetransmits: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: sideClient,
+			Name:      "retransmits_total",
+			Help:      "RADIUS request retransmissions.",
+		}, []string{"server", "code"}),
+		clientBadAuthenticators: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: sideClient,
+			Name:      "bad_authenticators_total",
+			Help:      "RADIUS responses dropped because of an invalid authenticator.",
+		}, []string{"server", "code"}),
+
+		serverRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: sideServer,
+			Name:      "requests_total",
+			Help:      "RADIUS requests handled, by request code.",
+		}, []string{"code"}),
+		serverResponses: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: sideServer,
+			Name:      "responses_total",
+			Help:      "RADIUS responses sent, by response code.",
+		}, []string{"code"}),
+		serverLatency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: sideServer,
+			Name:      "response_duration_seconds",
+			Help:      "Time spent by the handler before a response was written.",
+			Buckets:   prometheus.ExponentialBuckets(0.0005, 2, 14),
+		}, []string{"code"}),
+		serverBadAuthenticators: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: sideServer,
+			Name:      "bad_authenticators_total",
+			Help:      "RADIUS requests dropped because of an invalid authenticator.",
+		}, []string{"code"}),
+		serverUnknownClients: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: sideServer,
+			Name:      "unknown_clients_total",
+			Help:      "RADIUS requests dropped because no secret is known for the client.",
+		}),
+
+		coaResults: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "coa_results_total",
+			Help:      "CoA and Disconnect outcomes, by side and result (ack or nak).",
+		}, []string{"side", "code", "result"}),
+	}
+}
+
+func (m *Metrics) collectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		m.clientRequests,
+		m.clientResponses,
+		m.clientLatency,
+		m.clientTimeouts,
+		m.clientRetransmits,
+		m.clientBadAuthenticators,
+		m.serverRequests,
+		m.serverResponses,
+		m.serverLatency,
+		m.serverBadAuthenticators,
+		m.serverUnknownClients,
+		m.coaResults,
+	}
+}
+
+func (m *Metrics) Describe(ch chan<- *prometheus.Desc) {
+	for _, c := range m.collectors() {
+		c.Describe(ch)
+	}
+}
+
+func (m *Metrics) Collect(ch chan<- prometheus.Metric) {
+	for _, c := range m.collectors() {
+		c.Collect(ch)
+	}
+}
+
+func (m *Metrics) ClientRequest(addr string, code radius.Code) {
+	m.clientRequests.WithLabelValues(addr, code.String()).Inc()
+}
+
+func (m *Metrics) ClientResponse(addr string, request, response radius.Code, rtt time.Duration) {
+	m.clientResponses.WithLabelValues(addr, response.String()).Inc()
+	m.clientLatency.WithLabelValues(addr, request.String()).Observe(rtt.Seconds())
+	m.observeCoA(sideClient, request, response)
+}
+
+func (m *Metrics) ClientTimeout(addr string, code radius.Code) {
+	m.clientTimeouts.WithLabelValues(addr, code.String()).Inc()
+}
+
+func (m *Metrics) ClientRetransmit(addr string, code radius.Code) {
+	m.clientRetransmits.WithLabelValues(addr, code.String()).Inc()
+}
+
+func (m *Metrics) ClientBadAuthenticator(addr string, code radius.Code) {
+	m.clientBadAuthenticators.WithLabelValues(addr, code.String()).Inc()
+}
+
+func (m *Metrics) ServerRequest(code radius.Code) {
+	m.serverRequests.WithLabelValues(code.String()).Inc()
+}
+
+func (m *Metrics) ServerResponse(request, response radius.Code, latency time.Duration) {
+	m.serverResponses.WithLabelValues(response.String()).Inc()
+	m.serverLatency.WithLabelValues(request.String()).Observe(latency.Seconds())
+	m.observeCoA(sideServer, request, response)
+}
+
+func (m *Metrics) ServerBadAuthenticator(addr net.Addr, code radius.Code) {
+	m.serverBadAuthenticators.WithLabelValues(code.String()).Inc()
+}
+
+func (m *Metrics) ServerUnknownClient(addr net.Addr) {
+	m.serverUnknownClients.Inc()
+}
+
+func (m *Metrics) observeCoA(side string, request, response radius.Code) {
+	switch response {
+	case radius.CodeCoAACK, radius.CodeDisconnectACK:
+		m.coaResults.WithLabelValues(side, request.String(), "ack").Inc()
+	case radius.CodeCoANAK, radius.CodeDisconnectNAK:
+		m.coaResults.WithLabelValues(side, request.String(), "nak").Inc()
+	}
+}
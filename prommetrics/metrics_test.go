@@ -0,0 +1,98 @@
+package prommetrics
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/wimark/libradius"
+	"layeh.com/radius"
+)
+
+// startServer serves Access-Accept on a free loopback port and returns once
+// the server answers Status-Server.
+func startServer(t *testing.T) string {
+	t.Helper()
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := conn.LocalAddr().String()
+	conn.Close()
+
+	host, port, _ := net.SplitHostPort(addr)
+	server, err := libradius.ServerRunAsync(libradius.NewRadiusServerConfig(host, port, "testing123"), func(w radius.ResponseWriter, r *radius.Request) {
+		w.Write(r.Response(radius.CodeAccessAccept))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { server.Shutdown(context.Background()) })
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		_, err := libradius.Ping(ctx, addr, "testing123")
+		cancel()
+		if err == nil {
+			return addr
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("the server is not answering: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	addr := startServer(t)
+
+	m := New("test")
+	libradius.SetMetrics(m)
+	defer libradius.SetMetrics(nil)
+
+	response, err := libradius.SendPacket(addr, radius.New(radius.CodeAccessRequest, []byte("testing123")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.Code != radius.CodeAccessAccept {
+		t.Fatalf("got %s", response.Code)
+	}
+
+	// an accounting request signed with another secret is dropped
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if _, err := libradius.SendPacketContext(ctx, addr, radius.New(radius.CodeAccountingRequest, []byte("wrong"))); err == nil {
+		t.Fatal("the accounting request was answered")
+	}
+
+	for _, check := range []struct {
+		name string
+		got  float64
+		want float64
+	}{
+		{"client requests", testutil.ToFloat64(m.clientRequests.WithLabelValues(addr, "Access-Request")), 1},
+		{"client responses", testutil.ToFloat64(m.clientResponses.WithLabelValues(addr, "Access-Accept")), 1},
+		{"client accounting requests", testutil.ToFloat64(m.clientRequests.WithLabelValues(addr, "Accounting-Request")), 1},
+		{"client timeouts", testutil.ToFloat64(m.clientTimeouts.WithLabelValues(addr, "Accounting-Request")), 1},
+		{"server requests", testutil.ToFloat64(m.serverRequests.WithLabelValues("Access-Request")), 1},
+		{"server responses", testutil.ToFloat64(m.serverResponses.WithLabelValues("Access-Accept")), 1},
+		{"server bad authenticators", testutil.ToFloat64(m.serverBadAuthenticators.WithLabelValues("Accounting-Request")), 1},
+		{"server accounting requests", testutil.ToFloat64(m.serverRequests.WithLabelValues("Accounting-Request")), 0},
+		{"server unknown clients", testutil.ToFloat64(m.serverUnknownClients), 0},
+	} {
+		if check.got != check.want {
+			t.Errorf("%s = %v, want %v", check.name, check.got, check.want)
+		}
+	}
+
+	if n := testutil.CollectAndCount(m.clientLatency); n != 1 {
+		t.Errorf("%d client latency series, want 1", n)
+	}
+	if n := testutil.CollectAndCount(m.serverLatency); n != 1 {
+		t.Errorf("%d server latency series, want 1", n)
+	}
+}
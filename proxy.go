@@ -0,0 +1,375 @@
+package libradius
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"sync/atomic"
+
+	"layeh.com/radius"
+	"layeh.com/radius/rfc2865"
+	"layeh.com/radius/rfc2868"
+	"layeh.com/radius/rfc2869"
+)
+
+const (
+	vendorMicrosoft      uint32 = 311
+	microsoftMPPESendKey byte   = 16
+	microsoftMPPERecvKey byte   = 17
+)
+
+type ProxyRealm struct {
+	Name string `json:"name"`
+	// Server receives Access-Requests, AccountingServer Accounting-Requests
+	// and defaults to Server.
+	Server           *RadiusServerConfig `json:"server"`
+	AccountingServer *RadiusServerConfig `json:"accounting_server"`
+	// StripRealm forwards the bare user name without the realm.
+	StripRealm bool `json:"strip_realm"`
+
+	// RemoveRequestAttributes and RemoveResponseAttributes are dropped from
+	// forwarded requests and from responses returned to the NAS.
+	RemoveRequestAttributes  []radius.Type `json:"remove_request_attributes"`
+	RemoveResponseAttributes []radius.Type `json:"remove_response_attributes"`
+
+	// RewriteRequest and RewriteResponse may change the forwarded request
+	// and the response to the NAS. They are called after User-Password and
+	// the other hidden attributes are encrypted with the receiver's secret.
+	RewriteRequest  func(request *radius.Packet)                           `json:"-"`
+	RewriteResponse func(request *radius.Request, response *radius.Packet) `json:"-"`
+}
+
+func (r *ProxyRealm) serverFor(code radius.Code) *RadiusServerConfig {
+	if code == radius.CodeAccountingRequest && r.AccountingServer != nil {
+		return r.AccountingServer
+	}
+	return r.Server
+}
+
+// Proxy forwards Access and Accounting requests to upstream servers chosen by
+// the realm of the User-Name (user@realm or REALM\user) or by the SSID in
+// Called-Station-Id. Its ServeRADIUS method can be passed to ServerRun.
+type Proxy struct {
+	Default  *ProxyRealm
+	ErrorLog *log.Logger
+
+	realms map[string]*ProxyRealm
+	ssids  map[string]*ProxyRealm
+	state  uint32
+	id     []byte
+}
+
+func NewProxy() *Proxy {
+	id := make([]byte, 4)
+	rand.Read(id)
+
+	return &Proxy{
+		realms: make(map[string]*ProxyRealm),
+		ssids:  make(map[string]*ProxyRealm),
+		id:     id,
+	}
+}
+
+func (p *Proxy) AddRealm(realm *ProxyRealm) {
+	p.realms[strings.ToLower(realm.Name)] = realm
+}
+
+// AddSSID routes requests from the SSID, the part of Called-Station-Id after
+// the colon, to a realm. It is used when the User-Name has no realm.
+func (p *Proxy) AddSSID(ssid string, realm *ProxyRealm) {
+	p.ssids[ssid] = realm
+}
+
+// Route returns the realm of a request and the user name to forward.
+func (p *Proxy) Route(packet *radius.Packet) (*ProxyRealm, string, bool) {
+	user := rfc2865.UserName_GetString(packet)
+
+	name, bare := splitRealm(user)
+	if len(name) > 0 {
+		if realm, ok := p.realms[strings.ToLower(name)]; ok {
+			if realm.StripRealm {
+				return realm, bare, true
+			}
+			return realm, user, true
+		}
+	}
+
+	if ssid := calledStationSSID(rfc2865.CalledStationID_GetString(packet)); len(ssid) > 0 {
+		if realm, ok := p.ssids[ssid]; ok {
+			return realm, user, true
+		}
+	}
+
+	if p.Default != nil {
+		return p.Default, user, true
+	}
+
+	return nil, user, false
+}
+
+func splitRealm(user string) (realm, bare string) {
+	if i := strings.IndexByte(user, '\\'); i > 0 {
+		return user[:i], user[i+1:]
+	}
+	if i := strings.LastIndexByte(user, '@'); i >= 0 && i < len(user)-1 {
+		return user[i+1:], user[:i]
+	}
+	return "", user
+}
+
+// calledStationSSID extracts the SSID of "MAC:SSID" as sent by Wimark and
+// most other access points.
+func calledStationSSID(calledStationID string) string {
+	// the MAC may be written with colons as well, and the SSID may contain
+	// them, so a MAC prefix is skipped by its length
+	if len(calledStationID) > 18 && calledStationID[17] == ':' {
+		if _, err := net.ParseMAC(calledStationID[:17]); err == nil {
+			return calledStationID[18:]
+		}
+	}
+	if i := strings.IndexByte(calledStationID, ':'); i >= 0 {
+		return calledStationID[i+1:]
+	}
+	return ""
+}
+
+func (p *Proxy) logf(format string, args ...interface{}) {
+	if p.ErrorLog != nil {
+		p.ErrorLog.Printf(format, args...)
+	} else {
+		log.Printf(format, args...)
+	}
+}
+
+func (p *Proxy) ServeRADIUS(w radius.ResponseWriter, r *radius.Request) {
+	switch r.Code {
+	case radius.CodeAccessRequest, radius.CodeAccountingRequest:
+	default:
+		return
+	}
+
+	realm, user, ok := p.Route(r.Packet)
+	if !ok {
+		p.logf("proxy: no realm for %q from %s", rfc2865.UserName_GetString(r.Packet), r.RemoteAddr)
+		if r.Code == radius.CodeAccessRequest {
+			w.Write(r.Response(radius.CodeAccessReject))
+		}
+		return
+	}
+
+	server := realm.serverFor(r.Code)
+	if server == nil {
+		p.logf("proxy: realm %s has no server for %s", realm.Name, r.Code)
+		return
+	}
+
+	request, proxyState, err := p.forwardRequest(r, realm, server, user)
+	if err != nil {
+		p.logf("proxy: unable to forward request to realm %s: %v", realm.Name, err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), defaultSendRadiusPacketTimeout)
+	defer cancel()
+
+	response, err := exchange(ctx, radius.DefaultClient, request, server.GetAddr(), nil)
+	if err != nil {
+		// no answer lets the NAS retransmit or fail over
+		p.logf("proxy: realm %s server %s: %v", realm.Name, server.GetAddr(), err)
+		return
+	}
+
+	reply, err := p.forwardResponse(r, realm, request, response, proxyState)
+	if err != nil {
+		p.logf("proxy: invalid response from realm %s server %s: %v", realm.Name, server.GetAddr(), err)
+		return
+	}
+
+	w.Write(reply)
+}
+
+func (p *Proxy) forwardRequest(r *radius.Request, realm *ProxyRealm, server *RadiusServerConfig, user string) (*radius.Packet, []byte, error) {
+	request := ClonePacket(r.Packet)
+	request.Secret = []byte(server.Secret)
+	if r.Code == radius.CodeAccessRequest {
+		if _, err := rand.Read(request.Authenticator[:]); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if len(user) > 0 {
+		rfc2865.UserName_Set(request, []byte(user))
+	}
+
+	// RFC 2865, section 2.2: without CHAP-Challenge the request authenticator
+	// is the challenge, which the new one would replace
+	if r.Code == radius.CodeAccessRequest && request.Get(rfc2865.CHAPPassword_Type) != nil && request.Get(rfc2865.CHAPChallenge_Type) == nil {
+		rfc2865.CHAPChallenge_Set(request, append([]byte(nil), r.Authenticator[:]...))
+	}
+
+	_, signed := request.Lookup(rfc2869.MessageAuthenticator_Type)
+	request.Del(rfc2869.MessageAuthenticator_Type)
+	removeAttributes(request, realm.RemoveRequestAttributes)
+
+	if err := reencryptAttributes(request, r.Packet.Secret, r.Authenticator[:], request.Secret, request.Authenticator[:]); err != nil {
+		return nil, nil, err
+	}
+
+	if realm.RewriteRequest != nil {
+		realm.RewriteRequest(request)
+	}
+
+	proxyState := p.nextProxyState()
+	request.Add(rfc2865.ProxyState_Type, proxyState)
+
+	if signed || r.Code == radius.CodeAccessRequest && request.Get(rfc2869.EAPMessage_Type) != nil {
+		if err := AddMessageAuthenticator(request); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	// the authenticator of other requests is computed by Encode, the
+	// response is checked against the one on the wire
+	if r.Code != radius.CodeAccessRequest {
+		wire, err := request.Encode()
+		if err != nil {
+			return nil, nil, err
+		}
+		copy(request.Authenticator[:], wire[4:20])
+	}
+
+	return request, proxyState, nil
+}
+
+func (p *Proxy) forwardResponse(r *radius.Request, realm *ProxyRealm, request, response *radius.Packet, proxyState []byte) (*radius.Packet, error) {
+	if HasMessageAuthenticator(response) && !VerifyMessageAuthenticator(response, request.Authenticator[:]) {
+		return nil, fmt.Errorf("invalid Message-Authenticator")
+	}
+
+	reply := r.Response(response.Code)
+
+	var found bool
+	for _, avp := range response.Attributes {
+		switch {
+		case avp.Type == rfc2865.ProxyState_Type && !found && bytes.Equal(avp.Attribute, proxyState):
+			// RFC 2865, section 5.33: the Proxy-State added by this proxy
+			// is removed before the response is passed on
+			found = true
+			continue
+		case avp.Type == rfc2869.MessageAuthenticator_Type:
+			continue
+		}
+		reply.Add(avp.Type, append(radius.Attribute(nil), avp.Attribute...))
+	}
+	if !found {
+		return nil, fmt.Errorf("attribute Proxy-State not found")
+	}
+
+	removeAttributes(reply, realm.RemoveResponseAttributes)
+
+	if err := reencryptAttributes(reply, request.Secret, request.Authenticator[:], reply.Secret, r.Authenticator[:]); err != nil {
+		return nil, err
+	}
+
+	if realm.RewriteResponse != nil {
+		realm.RewriteResponse(r, reply)
+	}
+
+	if HasMessageAuthenticator(response) || HasMessageAuthenticator(r.Packet) {
+		if err := AddMessageAuthenticator(reply); err != nil {
+			return nil, err
+		}
+	}
+
+	return reply, nil
+}
+
+func (p *Proxy) nextProxyState() []byte {
+	state := make([]byte, 8)
+	copy(state, p.id)
+	binary.BigEndian.PutUint32(state[4:], atomic.AddUint32(&p.state, 1))
+	return state
+}
+
+func removeAttributes(p *radius.Packet, types []radius.Type) {
+	for _, t := range types {
+		p.Del(t)
+	}
+}
+
+// reencryptAttributes moves User-Password, Tunnel-Password and the MS-MPPE
+// keys from one secret and request authenticator to another.
+func reencryptAttributes(p *radius.Packet, fromSecret, fromAuthenticator, toSecret, toAuthenticator []byte) error {
+	for _, avp := range p.Attributes {
+		switch avp.Type {
+		case rfc2865.UserPassword_Type:
+			password, err := radius.UserPassword(avp.Attribute, fromSecret, fromAuthenticator)
+			if err != nil {
+				return err
+			}
+			if avp.Attribute, err = radius.NewUserPassword(padUserPassword(string(password)), toSecret, toAuthenticator); err != nil {
+				return err
+			}
+		case rfc2868.TunnelPassword_Type:
+			var tag radius.Attribute
+			value := avp.Attribute
+			if len(value) > 0 && value[0] <= 0x1F {
+				tag, value = value[:1], value[1:]
+			}
+			encrypted, err := reencryptSalted(value, fromSecret, fromAuthenticator, toSecret, toAuthenticator)
+			if err != nil {
+				return err
+			}
+			avp.Attribute = append(append(radius.Attribute(nil), tag...), encrypted...)
+		case rfc2865.VendorSpecific_Type:
+			vendorID, vsa, err := radius.VendorSpecific(avp.Attribute)
+			if err != nil || vendorID != vendorMicrosoft {
+				continue
+			}
+			if vsa, err = reencryptMicrosoftKeys(vsa, fromSecret, fromAuthenticator, toSecret, toAuthenticator); err != nil {
+				return err
+			}
+			if avp.Attribute, err = radius.NewVendorSpecific(vendorID, vsa); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func reencryptMicrosoftKeys(vsa radius.Attribute, fromSecret, fromAuthenticator, toSecret, toAuthenticator []byte) (radius.Attribute, error) {
+	var result radius.Attribute
+	for len(vsa) >= 3 {
+		vsaType, vsaLen := vsa[0], vsa[1]
+		if int(vsaLen) > len(vsa) || vsaLen < 3 {
+			break
+		}
+
+		value := vsa[2:int(vsaLen)]
+		if vsaType == microsoftMPPESendKey || vsaType == microsoftMPPERecvKey {
+			encrypted, err := reencryptSalted(value, fromSecret, fromAuthenticator, toSecret, toAuthenticator)
+			if err != nil {
+				return nil, err
+			}
+			value = encrypted
+		}
+
+		result = append(result, vsaType, byte(2+len(value)))
+		result = append(result, value...)
+		vsa = vsa[int(vsaLen):]
+	}
+	return result, nil
+}
+
+func reencryptSalted(value radius.Attribute, fromSecret, fromAuthenticator, toSecret, toAuthenticator []byte) (radius.Attribute, error) {
+	plaintext, salt, err := radius.TunnelPassword(value, fromSecret, fromAuthenticator)
+	if err != nil {
+		return nil, err
+	}
+	return radius.NewTunnelPassword(plaintext, salt, toSecret, toAuthenticator)
+}
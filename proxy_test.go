@@ -0,0 +1,191 @@
+package libradius
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"net"
+	"testing"
+
+	"layeh.com/radius"
+	"layeh.com/radius/rfc2865"
+	"layeh.com/radius/rfc2866"
+)
+
+const (
+	proxyTestNASSecret      = "nas-secret"
+	proxyTestUpstreamSecret = "upstream-secret"
+	proxyTestPassword       = "password12345678"
+)
+
+// startUpstream runs a home server accepting the test password with PAP and
+// CHAP, its responses echo Proxy-State and are signed with
+// Message-Authenticator.
+func startUpstream(t *testing.T) string {
+	t.Helper()
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &radius.PacketServer{
+		SecretSource: radius.StaticSecretSource([]byte(proxyTestUpstreamSecret)),
+		Handler: radius.HandlerFunc(func(w radius.ResponseWriter, r *radius.Request) {
+			var response *radius.Packet
+			switch r.Code {
+			case radius.CodeAccountingRequest:
+				response = r.Response(radius.CodeAccountingResponse)
+			case radius.CodeAccessRequest:
+				response = r.Response(radius.CodeAccessReject)
+				if chapPassword := rfc2865.CHAPPassword_Get(r.Packet); len(chapPassword) > 0 {
+					if verifyTestCHAP(r.Packet, chapPassword) {
+						response = r.Response(radius.CodeAccessAccept)
+					}
+				} else if rfc2865.UserPassword_GetString(r.Packet) == proxyTestPassword {
+					response = r.Response(radius.CodeAccessAccept)
+				}
+			default:
+				return
+			}
+			for _, state := range r.Packet.Attributes {
+				if state.Type == rfc2865.ProxyState_Type {
+					response.Add(state.Type, state.Attribute)
+				}
+			}
+			if err := AddMessageAuthenticator(response); err != nil {
+				t.Error(err)
+			}
+			w.Write(response)
+		}),
+	}
+	go server.Serve(conn)
+	t.Cleanup(func() { server.Shutdown(context.Background()) })
+
+	return conn.LocalAddr().String()
+}
+
+func verifyTestCHAP(p *radius.Packet, chapPassword []byte) bool {
+	challenge := rfc2865.CHAPChallenge_Get(p)
+	if len(challenge) == 0 {
+		challenge = p.Authenticator[:]
+	}
+	hash := md5.New()
+	hash.Write(chapPassword[:1])
+	hash.Write([]byte(proxyTestPassword))
+	hash.Write(challenge)
+	return len(chapPassword) == 17 && bytes.Equal(hash.Sum(nil), chapPassword[1:])
+}
+
+type proxyRecorder struct {
+	response *radius.Packet
+}
+
+func (w *proxyRecorder) Write(p *radius.Packet) error {
+	w.response = p
+	return nil
+}
+
+func TestProxyLoopback(t *testing.T) {
+	host, port, err := net.SplitHostPort(startUpstream(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	proxy := NewProxy()
+	proxy.Default = &ProxyRealm{
+		Name:   "upstream",
+		Server: NewRadiusServerConfig(host, port, proxyTestUpstreamSecret),
+	}
+
+	tests := []struct {
+		name    string
+		request func() *radius.Packet
+		code    radius.Code
+	}{
+		{
+			name: "PAP",
+			request: func() *radius.Packet {
+				p := radius.New(radius.CodeAccessRequest, []byte(proxyTestNASSecret))
+				rfc2865.UserName_SetString(p, "bob")
+				rfc2865.UserPassword_SetString(p, proxyTestPassword)
+				return p
+			},
+			code: radius.CodeAccessAccept,
+		},
+		{
+			name: "PAP with a wrong password",
+			request: func() *radius.Packet {
+				p := radius.New(radius.CodeAccessRequest, []byte(proxyTestNASSecret))
+				rfc2865.UserName_SetString(p, "bob")
+				rfc2865.UserPassword_SetString(p, "wrong-password-1")
+				return p
+			},
+			code: radius.CodeAccessReject,
+		},
+		{
+			name: "CHAP with the request authenticator as challenge",
+			request: func() *radius.Packet {
+				p := radius.New(radius.CodeAccessRequest, []byte(proxyTestNASSecret))
+				rfc2865.UserName_SetString(p, "bob")
+				hash := md5.New()
+				hash.Write([]byte{7})
+				hash.Write([]byte(proxyTestPassword))
+				hash.Write(p.Authenticator[:])
+				rfc2865.CHAPPassword_Set(p, append([]byte{7}, hash.Sum(nil)...))
+				return p
+			},
+			code: radius.CodeAccessAccept,
+		},
+		{
+			name: "Accounting-Request",
+			request: func() *radius.Packet {
+				p := radius.New(radius.CodeAccountingRequest, []byte(proxyTestNASSecret))
+				rfc2865.UserName_SetString(p, "bob")
+				rfc2866.AcctStatusType_Set(p, rfc2866.AcctStatusType_Value_Start)
+				rfc2866.AcctSessionID_SetString(p, "session")
+				return p
+			},
+			code: radius.CodeAccountingResponse,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// the request as received from the NAS
+			wire, err := test.request().Encode()
+			if err != nil {
+				t.Fatal(err)
+			}
+			packet, err := radius.Parse(wire, []byte(proxyTestNASSecret))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			w := &proxyRecorder{}
+			proxy.ServeRADIUS(w, &radius.Request{
+				Packet:     packet,
+				RemoteAddr: &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1812},
+			})
+			if w.response == nil {
+				t.Fatal("no response")
+			}
+			if w.response.Code != test.code {
+				t.Fatalf("got %s, want %s", w.response.Code, test.code)
+			}
+
+			reply, err := w.response.Encode()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !radius.IsAuthenticResponse(reply, wire, []byte(proxyTestNASSecret)) {
+				t.Error("the response is not authentic for the NAS")
+			}
+			if !VerifyMessageAuthenticator(w.response, packet.Authenticator[:]) {
+				t.Error("invalid Message-Authenticator for the NAS")
+			}
+			if _, ok := w.response.Lookup(rfc2865.ProxyState_Type); ok {
+				t.Error("the Proxy-State of the proxy was passed on")
+			}
+		})
+	}
+}
@@ -1,7 +1,12 @@
 package libradius
 
 import (
+	"context"
+	"crypto/tls"
 	"fmt"
+	"net"
+	"sync"
+	"time"
 
 	"layeh.com/radius"
 )
@@ -10,6 +15,20 @@ type RadiusServerConfig struct {
 	Host   string `json:"host"`
 	Port   string `json:"port"`
 	Secret string `json:"secret"`
+	// Accounting listeners answer Status-Server with Accounting-Response.
+	Accounting bool `json:"accounting"`
+	// StatusHook may add server statistics to Status-Server responses.
+	StatusHook func(r *radius.Request, response *radius.Packet) `json:"-"`
+	// Transport defaults to UDP. TLS and DTLS listeners identify NASes by
+	// their client certificate and use the secrets of RFC 6614 and RFC 7360
+	// when Secret is empty. TLSConfig configures DTLS as well, its ClientCAs
+	// must verify the NAS certificates: peers without a verified client
+	// certificate are refused.
+	Transport Transport   `json:"transport"`
+	TLSConfig *tls.Config `json:"-"`
+	// IdleTimeout closes TCP and TLS connections without requests, NASes
+	// keep them open with Status-Server.
+	IdleTimeout time.Duration `json:"idle_timeout"`
 }
 
 func NewRadiusServerConfig(host, port, secret string) *RadiusServerConfig {
@@ -25,25 +44,28 @@ func (c *RadiusServerConfig) GetAddr() string {
 }
 
 func ServerRun(cfg *RadiusServerConfig, handler func(w radius.ResponseWriter, r *radius.Request)) error {
-	server := radius.PacketServer{
-		Addr:         cfg.GetAddr(),
-		SecretSource: radius.StaticSecretSource([]byte(cfg.Secret)),
-		Handler:      radius.HandlerFunc(handler),
+	switch cfg.Transport {
+	case TransportTCP, TransportTLS, TransportDTLS:
+		return ServerRunStream(cfg, handler)
 	}
 
-	return server.ListenAndServe()
+	server := newPacketServer(cfg, radius.StaticSecretSource([]byte(cfg.Secret)), handler)
+
+	return listenAndServe(server)
 }
 
+// ServerRunAsync only serves UDP, ServerRunStreamAsync runs the other
+// transports.
 func ServerRunAsync(cfg *RadiusServerConfig, h func(w radius.ResponseWriter, r *radius.Request)) (*radius.PacketServer, error) {
-	server := radius.PacketServer{
-		Addr:         cfg.GetAddr(),
-		SecretSource: radius.StaticSecretSource([]byte(cfg.Secret)),
-		Handler:      radius.HandlerFunc(h),
+	if err := checkPacketTransport(cfg); err != nil {
+		return nil, err
 	}
 
-	go server.ListenAndServe()
+	server := newPacketServer(cfg, radius.StaticSecretSource([]byte(cfg.Secret)), h)
+
+	go listenAndServe(server)
 
-	return &server, nil
+	return server, nil
 }
 
 func ServerRunAsyncWithMultipleSecrets(
@@ -51,14 +73,174 @@ func ServerRunAsyncWithMultipleSecrets(
 	s radius.SecretSource,
 	f func(w radius.ResponseWriter, r *radius.Request),
 ) (*radius.PacketServer, error) {
+	if err := checkPacketTransport(cfg); err != nil {
+		return nil, err
+	}
+
+	server := newPacketServer(cfg, s, f)
 
-	server := radius.PacketServer{
+	go listenAndServe(server)
+
+	return server, nil
+}
+
+func checkPacketTransport(cfg *RadiusServerConfig) error {
+	switch cfg.Transport {
+	case "", TransportUDP:
+		return nil
+	}
+	return fmt.Errorf("radius: transport %s is served by ServerRunStreamAsync", cfg.Transport)
+}
+
+func newPacketServer(
+	cfg *RadiusServerConfig,
+	s radius.SecretSource,
+	f func(w radius.ResponseWriter, r *radius.Request),
+) *radius.PacketServer {
+	return &radius.PacketServer{
 		Addr:         cfg.GetAddr(),
-		SecretSource: s,
-		Handler:      radius.HandlerFunc(f),
+		SecretSource: newResolvedSecrets(s),
+		Handler:      instrumentHandler(statusServerHandler(cfg, radius.HandlerFunc(f))),
+	}
+}
+
+func listenAndServe(server *radius.PacketServer) error {
+	conn, err := net.ListenPacket("udp", server.Addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	return servePacketConn(server, conn)
+}
+
+func servePacketConn(server *radius.PacketServer, conn net.PacketConn) error {
+	secrets, ok := server.SecretSource.(*resolvedSecrets)
+	if !ok {
+		secrets = newResolvedSecrets(server.SecretSource)
+	}
+
+	return server.Serve(&instrumentedPacketConn{
+		PacketConn: conn,
+		secrets:    secrets,
+	})
+}
+
+func instrumentHandler(h radius.Handler) radius.Handler {
+	return radius.HandlerFunc(func(w radius.ResponseWriter, r *radius.Request) {
+		metrics().ServerRequest(r.Code)
+		h.ServeRADIUS(&instrumentedResponseWriter{
+			ResponseWriter: w,
+			request:        r.Code,
+			start:          time.Now(),
+		}, r)
+	})
+}
+
+type instrumentedResponseWriter struct {
+	radius.ResponseWriter
+	request radius.Code
+	start   time.Time
+}
+
+func (w *instrumentedResponseWriter) Write(p *radius.Packet) error {
+	if err := w.ResponseWriter.Write(p); err != nil {
+		return err
 	}
 
-	go server.ListenAndServe()
+	metrics().ServerResponse(w.request, p.Code, time.Since(w.start))
+	return nil
+}
+
+// instrumentedPacketConn only observes incoming datagrams: dropping them is
+// still left to radius.PacketServer.
+type instrumentedPacketConn struct {
+	net.PacketConn
+	secrets *resolvedSecrets
+}
+
+func (c *instrumentedPacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	n, addr, err := c.PacketConn.ReadFrom(b)
+	if err != nil {
+		return n, addr, err
+	}
+
+	// radius.PacketServer looks the secret up for every datagram read
+	secret, secretErr := c.secrets.resolve(addr)
+	if n < 20 {
+		return n, addr, err
+	}
+	if secretErr != nil || len(secret) == 0 {
+		metrics().ServerUnknownClient(addr)
+		return n, addr, err
+	}
+
+	code := radius.Code(b[0])
+	switch code {
+	case radius.CodeAccountingRequest, radius.CodeCoARequest, radius.CodeDisconnectRequest:
+		if !radius.IsAuthenticRequest(b[:n], secret) {
+			metrics().ServerBadAuthenticator(addr, code)
+		}
+	}
+
+	return n, addr, err
+}
+
+// resolvedSecrets hands radius.PacketServer the secrets instrumentedPacketConn
+// already looked up, so that the SecretSource is asked once per datagram.
+type resolvedSecrets struct {
+	source radius.SecretSource
+
+	mu       sync.Mutex
+	resolved map[string]*resolvedSecret
+}
+
+type resolvedSecret struct {
+	secret  []byte
+	err     error
+	pending int
+}
+
+func newResolvedSecrets(source radius.SecretSource) *resolvedSecrets {
+	return &resolvedSecrets{
+		source:   source,
+		resolved: make(map[string]*resolvedSecret),
+	}
+}
+
+// resolve looks the secret of addr up for the next RADIUSSecret call.
+func (s *resolvedSecrets) resolve(addr net.Addr) ([]byte, error) {
+	secret, err := s.source.RADIUSSecret(context.Background(), addr)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := addr.String()
+	resolved, ok := s.resolved[key]
+	if !ok {
+		resolved = &resolvedSecret{}
+		s.resolved[key] = resolved
+	}
+	resolved.secret, resolved.err = secret, err
+	resolved.pending++
+
+	return secret, err
+}
+
+func (s *resolvedSecrets) RADIUSSecret(ctx context.Context, addr net.Addr) ([]byte, error) {
+	s.mu.Lock()
+	key := addr.String()
+	resolved, ok := s.resolved[key]
+	if !ok {
+		s.mu.Unlock()
+		return s.source.RADIUSSecret(ctx, addr)
+	}
+	resolved.pending--
+	if resolved.pending == 0 {
+		delete(s.resolved, key)
+	}
+	secret, err := resolved.secret, resolved.err
+	s.mu.Unlock()
 
-	return &server, nil
+	return secret, err
 }
@@ -0,0 +1,501 @@
+package libradius
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"layeh.com/radius"
+)
+
+const (
+	radiusAccountingOn  = "Accounting-On"
+	radiusAccountingOff = "Accounting-Off"
+)
+
+const (
+	defaultDynamicAuthPort       = "3799"
+	defaultSessionMissedInterims = 2
+)
+
+type SessionKey struct {
+	SessionID string `json:"session_id"`
+	NAS       string `json:"nas"`
+}
+
+type Session struct {
+	Key SessionKey `json:"key"`
+
+	UserName         string `json:"user_name"`
+	CallingStationID string `json:"calling_station_id"`
+	CalledStationID  string `json:"called_station_id"`
+	NASIPAddress     string `json:"nas_ip_address"`
+	NASIdentifier    string `json:"nas_identifier"`
+	NASAddr          string `json:"nas_addr"`
+	FramedIPAddress  string `json:"framed_ip_address"`
+	CPEID            string `json:"cpe_id"`
+	WLANID           string `json:"wlan_id"`
+
+	InputOctets   uint64 `json:"input_octets"`
+	OutputOctets  uint64 `json:"output_octets"`
+	InputPackets  uint64 `json:"input_packets"`
+	OutputPackets uint64 `json:"output_packets"`
+
+	SessionTime     time.Duration `json:"session_time"`
+	InterimInterval time.Duration `json:"interim_interval"`
+
+	// StartedAt, UpdatedAt and StoppedAt follow the Event-Timestamp of the
+	// NAS, ReceivedAt is the local time of the last update.
+	StartedAt  time.Time `json:"started_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+	StoppedAt  time.Time `json:"stopped_at"`
+	ReceivedAt time.Time `json:"received_at"`
+
+	Stopped        bool   `json:"stopped"`
+	TerminateCause string `json:"terminate_cause"`
+}
+
+func (s *Session) Duration() time.Duration {
+	if s.SessionTime > 0 {
+		return s.SessionTime
+	}
+	return s.UpdatedAt.Sub(s.StartedAt)
+}
+
+func (s *Session) CoaRequest() CoaRequest {
+	return CoaRequest{
+		FramedIPAddress: s.FramedIPAddress,
+		AcctSessionID:   s.Key.SessionID,
+	}
+}
+
+// DynamicAuthAddr returns the address CoA and Disconnect requests for the
+// session should be sent to: the NAS on the RFC 5176 port.
+func (s *Session) DynamicAuthAddr() string {
+	host := s.NASAddr
+	if host == "" {
+		host = s.NASIPAddress
+	}
+	return net.JoinHostPort(host, defaultDynamicAuthPort)
+}
+
+// CoaTarget targets the session on its NAS, see Session.DynamicAuthAddr.
+func (s *Session) CoaTarget(secret string, disconnect bool) CoaTarget {
+	return CoaTarget{
+		Addr:       s.DynamicAuthAddr(),
+		Secret:     secret,
+		Disconnect: disconnect,
+		Request:    s.CoaRequest(),
+	}
+}
+
+type SessionStoreConfig struct {
+	InterimInterval time.Duration
+	MissedInterims  int
+	// Storage persists sessions, they are only kept while active when nil.
+	Storage    SessionStorage
+	StoppedTTL time.Duration
+	// ErrorLog receives the failures of RunCleanup, the log package's
+	// standard logger when nil.
+	ErrorLog *log.Logger
+}
+
+func NewSessionStoreConfig(interimInterval time.Duration, missedInterims int) *SessionStoreConfig {
+	return &SessionStoreConfig{
+		InterimInterval: interimInterval,
+		MissedInterims:  missedInterims,
+	}
+}
+
+type SessionStore struct {
+	cfg SessionStoreConfig
+
+	mu       sync.RWMutex
+	sessions map[SessionKey]*Session
+	// saving serializes the updates of a session with their save, so that
+	// the storage receives them in the order they were applied
+	saving map[SessionKey]*sessionLock
+}
+
+type sessionLock struct {
+	mu   sync.Mutex
+	refs int
+}
+
+func NewSessionStore(cfg *SessionStoreConfig) *SessionStore {
+	store := &SessionStore{
+		sessions: make(map[SessionKey]*Session),
+		saving:   make(map[SessionKey]*sessionLock),
+	}
+	if cfg != nil {
+		store.cfg = *cfg
+	}
+	if store.cfg.MissedInterims <= 0 {
+		store.cfg.MissedInterims = defaultSessionMissedInterims
+	}
+	if store.cfg.Storage == nil {
+		store.cfg.Storage = nopSessionStorage{}
+	}
+
+	return store
+}
+
+// Restore loads the active sessions kept by the storage, typically right
+// after a restart and before accounting traffic is served.
+func (s *SessionStore) Restore() error {
+	sessions, err := s.cfg.Storage.Load()
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, session := range sessions {
+		if !session.Stopped {
+			s.sessions[session.Key] = session
+		}
+	}
+
+	return nil
+}
+
+func (s *SessionStore) Cleanup(now time.Time) (int, error) {
+	return s.cfg.Storage.Cleanup(now.Add(-s.cfg.StoppedTTL))
+}
+
+func (s *SessionStore) RunCleanup(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if _, err := s.Cleanup(now); err != nil {
+				s.logf("session cleanup failed: %s", err)
+			}
+		}
+	}
+}
+
+func (s *SessionStore) logf(format string, args ...interface{}) {
+	if s.cfg.ErrorLog != nil {
+		s.cfg.ErrorLog.Printf(format, args...)
+	} else {
+		log.Printf(format, args...)
+	}
+}
+
+func (s *SessionStore) Close() error {
+	return s.cfg.Storage.Close()
+}
+
+// Ingest applies an Accounting-Request received from addr to the tracked
+// sessions and returns a copy of the resulting session state. Accounting-On
+// and Accounting-Off stop every session of the NAS and return nil.
+func (s *SessionStore) Ingest(p *radius.Packet, addr net.Addr) (*Session, error) {
+	record, err := DecodeAccountingRecord(p)
+	if err != nil {
+		return nil, err
+	}
+
+	nas := sessionNAS(record, addr)
+	received := time.Now()
+	now := record.StatusTime(received)
+
+	switch record.StatusType {
+	case radiusAccountingOn, radiusAccountingOff:
+		return nil, s.stopNAS(nas, now)
+	case RadiusStart, RadiusUpdate, RadiusStop:
+	default:
+		return nil, fmt.Errorf("unsupported Acct-Status-Type: %s", record.StatusType)
+	}
+
+	if len(record.SessionID) == 0 {
+		return nil, fmt.Errorf("attribute Acct-Session-Id not found")
+	}
+	key := SessionKey{SessionID: record.SessionID, NAS: nas}
+
+	s.lockSession(key)
+	defer s.unlockSession(key)
+
+	s.mu.Lock()
+	session, ok := s.sessions[key]
+	if !ok {
+		session = &Session{Key: key}
+	}
+	updateSession(session, record, addr, now)
+	session.ReceivedAt = received
+
+	if record.StatusType == RadiusStop {
+		session.Stopped = true
+		session.StoppedAt = now
+		session.TerminateCause = string(record.TerminateCause)
+		delete(s.sessions, key)
+	} else {
+		s.sessions[key] = session
+	}
+
+	result := *session
+	s.mu.Unlock()
+
+	// the copy is saved outside of the store lock, readers and other
+	// sessions do not wait for the storage
+	saved := result
+	if err := s.cfg.Storage.Save(&saved); err != nil {
+		return &result, fmt.Errorf("unable to save session %s: %w", record.SessionID, err)
+	}
+
+	return &result, nil
+}
+
+func (s *SessionStore) Get(key SessionKey) (*Session, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	session, ok := s.sessions[key]
+	if !ok {
+		return nil, false
+	}
+
+	result := *session
+	return &result, true
+}
+
+func (s *SessionStore) Delete(key SessionKey) error {
+	s.lockSession(key)
+	defer s.unlockSession(key)
+
+	s.mu.Lock()
+	delete(s.sessions, key)
+	s.mu.Unlock()
+
+	return s.cfg.Storage.Delete(key)
+}
+
+func (s *SessionStore) All() []*Session {
+	return s.find(func(*Session) bool { return true })
+}
+
+func (s *SessionStore) FindByFramedIP(ip string) []*Session {
+	return s.find(func(session *Session) bool { return session.FramedIPAddress == ip })
+}
+
+func (s *SessionStore) FindByUserName(name string) []*Session {
+	return s.find(func(session *Session) bool { return session.UserName == name })
+}
+
+func (s *SessionStore) FindByCallingStationID(id string) []*Session {
+	return s.find(func(session *Session) bool { return session.CallingStationID == id })
+}
+
+func (s *SessionStore) FindByCPEID(id string) []*Session {
+	return s.find(func(session *Session) bool { return session.CPEID == id })
+}
+
+func (s *SessionStore) FindByWLANID(id string) []*Session {
+	return s.find(func(session *Session) bool { return session.WLANID == id })
+}
+
+func (s *SessionStore) FindByNAS(nas string) []*Session {
+	return s.find(func(session *Session) bool { return session.Key.NAS == nas })
+}
+
+// Stale returns the sessions that missed more interim updates than the
+// store tolerates. Sessions without a known interim interval are never stale.
+// The updates are timed by their local receive time, the clock of the NAS
+// may be off.
+func (s *SessionStore) Stale(now time.Time) []*Session {
+	return s.find(func(session *Session) bool {
+		interval := session.InterimInterval
+		if interval == 0 {
+			interval = s.cfg.InterimInterval
+		}
+		if interval == 0 {
+			return false
+		}
+		// sessions saved before ReceivedAt was recorded only have UpdatedAt
+		received := session.ReceivedAt
+		if received.IsZero() {
+			received = session.UpdatedAt
+		}
+		return now.Sub(received) > interval*time.Duration(s.cfg.MissedInterims)
+	})
+}
+
+func (s *SessionStore) find(match func(*Session) bool) []*Session {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var sessions []*Session
+	for _, session := range s.sessions {
+		if match(session) {
+			result := *session
+			sessions = append(sessions, &result)
+		}
+	}
+
+	return sessions
+}
+
+func (s *SessionStore) stopNAS(nas string, now time.Time) error {
+	var keys []SessionKey
+
+	s.mu.RLock()
+	for key := range s.sessions {
+		if key.NAS == nas {
+			keys = append(keys, key)
+		}
+	}
+	s.mu.RUnlock()
+
+	var err error
+	for _, key := range keys {
+		if stopErr := s.stopSession(key, now); stopErr != nil && err == nil {
+			err = stopErr
+		}
+	}
+
+	return err
+}
+
+func (s *SessionStore) stopSession(key SessionKey, now time.Time) error {
+	s.lockSession(key)
+	defer s.unlockSession(key)
+
+	s.mu.Lock()
+	session, ok := s.sessions[key]
+	if !ok {
+		// stopped in the meantime
+		s.mu.Unlock()
+		return nil
+	}
+	session.Stopped = true
+	session.StoppedAt = now
+	session.TerminateCause = string(AcctTerminateCauseNASReboot)
+	delete(s.sessions, key)
+	s.mu.Unlock()
+
+	// the session left the map, nothing else modifies it any more
+	if err := s.cfg.Storage.Save(session); err != nil {
+		return fmt.Errorf("unable to save session %s: %w", key.SessionID, err)
+	}
+
+	return nil
+}
+
+func (s *SessionStore) lockSession(key SessionKey) {
+	s.mu.Lock()
+	lock, ok := s.saving[key]
+	if !ok {
+		lock = &sessionLock{}
+		s.saving[key] = lock
+	}
+	lock.refs++
+	s.mu.Unlock()
+
+	lock.mu.Lock()
+}
+
+func (s *SessionStore) unlockSession(key SessionKey) {
+	s.mu.Lock()
+	lock := s.saving[key]
+	lock.refs--
+	if lock.refs == 0 {
+		delete(s.saving, key)
+	}
+	s.mu.Unlock()
+
+	lock.mu.Unlock()
+}
+
+func sessionNAS(record *AccountingRecord, addr net.Addr) string {
+	if len(record.NASIdentifier) > 0 {
+		return record.NASIdentifier
+	}
+	if record.NASIPAddress != nil {
+		return record.NASIPAddress.String()
+	}
+	return addrHost(addr)
+}
+
+func addrHost(addr net.Addr) string {
+	if addr == nil {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
+
+func updateSession(session *Session, record *AccountingRecord, addr net.Addr, now time.Time) {
+	if len(record.UserName) > 0 {
+		session.UserName = record.UserName
+	}
+	if len(record.CallingStationID) > 0 {
+		session.CallingStationID = record.CallingStationID
+	}
+	if len(record.CalledStationID) > 0 {
+		session.CalledStationID = record.CalledStationID
+	}
+	if len(record.NASIdentifier) > 0 {
+		session.NASIdentifier = record.NASIdentifier
+	}
+	if record.NASIPAddress != nil {
+		session.NASIPAddress = record.NASIPAddress.String()
+	}
+	if record.FramedIPAddress != nil {
+		session.FramedIPAddress = record.FramedIPAddress.String()
+	}
+	if len(record.Wimark.CPEID) > 0 {
+		session.CPEID = record.Wimark.CPEID
+	}
+	if len(record.Wimark.WLANID) > 0 {
+		session.WLANID = record.Wimark.WLANID
+	}
+	if host := addrHost(addr); len(host) > 0 {
+		session.NASAddr = host
+	}
+
+	session.InputOctets = combineCounter(session.InputOctets, record.InputOctets)
+	session.OutputOctets = combineCounter(session.OutputOctets, record.OutputOctets)
+	session.InputPackets = combineCounter(session.InputPackets, record.InputPackets)
+	session.OutputPackets = combineCounter(session.OutputPackets, record.OutputPackets)
+
+	if record.SessionTime > 0 {
+		session.SessionTime = record.SessionTime
+	}
+	if record.InterimInterval > 0 {
+		session.InterimInterval = record.InterimInterval
+	}
+
+	if session.StartedAt.IsZero() {
+		session.StartedAt = now.Add(-session.SessionTime)
+	}
+	session.UpdatedAt = now
+}
+
+// combineCounter keeps a 64-bit counter growing across updates. When the
+// NAS reports no gigawords, a wrap is inferred from the 32-bit counter going
+// backwards. Missing counters are reported as zero and keep the old value.
+func combineCounter(previous, value uint64) uint64 {
+	if value == 0 {
+		return previous
+	}
+	if value>>32 != 0 {
+		return value
+	}
+
+	wraps := previous >> 32
+	if uint32(value) < uint32(previous) {
+		wraps++
+	}
+	return wraps<<32 | value
+}
@@ -0,0 +1,423 @@
+package libradius
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+type SessionStorage interface {
+	Save(session *Session) error
+	Delete(key SessionKey) error
+	Load() ([]*Session, error)
+	// Cleanup removes stopped sessions that stopped before the given time
+	// and returns how many were removed.
+	Cleanup(stoppedBefore time.Time) (int, error)
+	Close() error
+}
+
+// nopSessionStorage is the storage of a SessionStore without one, stopped
+// sessions are dropped at once.
+type nopSessionStorage struct{}
+
+func (nopSessionStorage) Save(*Session) error            { return nil }
+func (nopSessionStorage) Delete(SessionKey) error        { return nil }
+func (nopSessionStorage) Load() ([]*Session, error)      { return nil, nil }
+func (nopSessionStorage) Cleanup(time.Time) (int, error) { return 0, nil }
+func (nopSessionStorage) Close() error                   { return nil }
+
+type MemorySessionStorage struct {
+	mu       sync.RWMutex
+	sessions map[SessionKey]*Session
+}
+
+func NewMemorySessionStorage() *MemorySessionStorage {
+	return &MemorySessionStorage{
+		sessions: make(map[SessionKey]*Session),
+	}
+}
+
+func (m *MemorySessionStorage) Save(session *Session) error {
+	stored := *session
+
+	m.mu.Lock()
+	m.sessions[session.Key] = &stored
+	m.mu.Unlock()
+
+	return nil
+}
+
+func (m *MemorySessionStorage) Delete(key SessionKey) error {
+	m.mu.Lock()
+	delete(m.sessions, key)
+	m.mu.Unlock()
+
+	return nil
+}
+
+func (m *MemorySessionStorage) Load() ([]*Session, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	sessions := make([]*Session, 0, len(m.sessions))
+	for _, session := range m.sessions {
+		stored := *session
+		sessions = append(sessions, &stored)
+	}
+
+	return sessions, nil
+}
+
+func (m *MemorySessionStorage) Cleanup(stoppedBefore time.Time) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var removed int
+	for key, session := range m.sessions {
+		if session.Stopped && session.StoppedAt.Before(stoppedBefore) {
+			delete(m.sessions, key)
+			removed++
+		}
+	}
+
+	return removed, nil
+}
+
+func (m *MemorySessionStorage) Close() error {
+	return nil
+}
+
+const (
+	fileSessionOpSave   = "save"
+	fileSessionOpDelete = "delete"
+
+	fileSessionCompactMinRecords = 1024
+)
+
+type fileSessionRecord struct {
+	Op      string     `json:"op"`
+	Key     SessionKey `json:"key"`
+	Session *Session   `json:"session,omitempty"`
+}
+
+// FileSessionStorage keeps sessions in an append-only journal of JSON lines
+// which is replayed on open and compacted when it grows or is cleaned up.
+type FileSessionStorage struct {
+	path string
+
+	mu       sync.Mutex
+	file     *os.File
+	records  int
+	sessions map[SessionKey]*Session
+}
+
+func NewFileSessionStorage(path string) (*FileSessionStorage, error) {
+	f := &FileSessionStorage{
+		path:     path,
+		sessions: make(map[SessionKey]*Session),
+	}
+
+	if err := f.replay(); err != nil {
+		return nil, err
+	}
+
+	if err := f.compact(); err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+func (f *FileSessionStorage) replay() error {
+	file, err := os.Open(f.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var record fileSessionRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			// a torn write at the end of the journal
+			continue
+		}
+
+		switch record.Op {
+		case fileSessionOpSave:
+			if record.Session != nil {
+				f.sessions[record.Key] = record.Session
+			}
+		case fileSessionOpDelete:
+			delete(f.sessions, record.Key)
+		}
+	}
+
+	return scanner.Err()
+}
+
+func (f *FileSessionStorage) compact() error {
+	tmpPath := f.path + ".tmp"
+	tmp, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(tmp)
+	encoder := json.NewEncoder(w)
+	for key, session := range f.sessions {
+		if err := encoder.Encode(fileSessionRecord{Op: fileSessionOpSave, Key: key, Session: session}); err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if f.file != nil {
+		f.file.Close()
+		f.file = nil
+	}
+
+	if err := os.Rename(tmpPath, f.path); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(f.path, os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+
+	f.file = file
+	f.records = len(f.sessions)
+
+	return nil
+}
+
+func (f *FileSessionStorage) appendLocked(record fileSessionRecord) error {
+	if f.file == nil {
+		return fmt.Errorf("session storage %s is closed", f.path)
+	}
+
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.file.Write(append(data, '\n')); err != nil {
+		return err
+	}
+
+	f.records++
+	if f.records > fileSessionCompactMinRecords && f.records > 2*len(f.sessions) {
+		return f.compact()
+	}
+
+	return nil
+}
+
+func (f *FileSessionStorage) Save(session *Session) error {
+	stored := *session
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.sessions[session.Key] = &stored
+	return f.appendLocked(fileSessionRecord{Op: fileSessionOpSave, Key: session.Key, Session: &stored})
+}
+
+func (f *FileSessionStorage) Delete(key SessionKey) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, ok := f.sessions[key]; !ok {
+		return nil
+	}
+
+	delete(f.sessions, key)
+	return f.appendLocked(fileSessionRecord{Op: fileSessionOpDelete, Key: key})
+}
+
+func (f *FileSessionStorage) Load() ([]*Session, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	sessions := make([]*Session, 0, len(f.sessions))
+	for _, session := range f.sessions {
+		stored := *session
+		sessions = append(sessions, &stored)
+	}
+
+	return sessions, nil
+}
+
+func (f *FileSessionStorage) Cleanup(stoppedBefore time.Time) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var removed int
+	for key, session := range f.sessions {
+		if session.Stopped && session.StoppedAt.Before(stoppedBefore) {
+			delete(f.sessions, key)
+			removed++
+		}
+	}
+
+	if removed == 0 {
+		return 0, nil
+	}
+
+	return removed, f.compact()
+}
+
+func (f *FileSessionStorage) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.file == nil {
+		return nil
+	}
+
+	err := f.file.Sync()
+	if closeErr := f.file.Close(); err == nil {
+		err = closeErr
+	}
+	f.file = nil
+
+	return err
+}
+
+// SQLSessionStorage stores sessions in a single table through database/sql.
+// Statements stick to plain SQL so they work with SQLite and MySQL style
+// drivers; Placeholder can be overridden for drivers such as PostgreSQL.
+type SQLSessionStorage struct {
+	db          *sql.DB
+	table       string
+	Placeholder func(n int) string
+}
+
+func NewSQLSessionStorage(db *sql.DB, table string) *SQLSessionStorage {
+	return &SQLSessionStorage{
+		db:    db,
+		table: table,
+		Placeholder: func(int) string {
+			return "?"
+		},
+	}
+}
+
+func (s *SQLSessionStorage) CreateTable(ctx context.Context) error {
+	_, err := s.db.ExecContext(ctx, fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+	session_id VARCHAR(253) NOT NULL,
+	nas VARCHAR(253) NOT NULL,
+	stopped INTEGER NOT NULL,
+	stopped_at BIGINT NOT NULL,
+	data TEXT NOT NULL,
+	PRIMARY KEY (session_id, nas)
+)`, s.table))
+	return err
+}
+
+func (s *SQLSessionStorage) Save(session *Session) error {
+	data, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+
+	var stopped, stoppedAt int64
+	if session.Stopped {
+		stopped = 1
+		stoppedAt = session.StoppedAt.Unix()
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(
+		fmt.Sprintf("DELETE FROM %s WHERE session_id = %s AND nas = %s", s.table, s.Placeholder(1), s.Placeholder(2)),
+		session.Key.SessionID, session.Key.NAS,
+	); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(
+		fmt.Sprintf("INSERT INTO %s (session_id, nas, stopped, stopped_at, data) VALUES (%s, %s, %s, %s, %s)",
+			s.table, s.Placeholder(1), s.Placeholder(2), s.Placeholder(3), s.Placeholder(4), s.Placeholder(5)),
+		session.Key.SessionID, session.Key.NAS, stopped, stoppedAt, string(data),
+	); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *SQLSessionStorage) Delete(key SessionKey) error {
+	_, err := s.db.Exec(
+		fmt.Sprintf("DELETE FROM %s WHERE session_id = %s AND nas = %s", s.table, s.Placeholder(1), s.Placeholder(2)),
+		key.SessionID, key.NAS,
+	)
+	return err
+}
+
+func (s *SQLSessionStorage) Load() ([]*Session, error) {
+	rows, err := s.db.Query(fmt.Sprintf("SELECT data FROM %s", s.table))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessions []*Session
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+
+		var session Session
+		if err := json.Unmarshal([]byte(data), &session); err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, &session)
+	}
+
+	return sessions, rows.Err()
+}
+
+func (s *SQLSessionStorage) Cleanup(stoppedBefore time.Time) (int, error) {
+	result, err := s.db.Exec(
+		fmt.Sprintf("DELETE FROM %s WHERE stopped = 1 AND stopped_at < %s", s.table, s.Placeholder(1)),
+		stoppedBefore.Unix(),
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	removed, err := result.RowsAffected()
+	return int(removed), err
+}
+
+func (s *SQLSessionStorage) Close() error {
+	return nil
+}
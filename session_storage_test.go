@@ -0,0 +1,262 @@
+package libradius
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+func testSessions(now time.Time) []*Session {
+	return []*Session{
+		{
+			Key:       SessionKey{SessionID: "active", NAS: "10.0.0.1"},
+			UserName:  "alice",
+			StartedAt: now.Add(-time.Hour),
+			UpdatedAt: now,
+		},
+		{
+			Key:            SessionKey{SessionID: "expired", NAS: "10.0.0.1"},
+			UserName:       "bob",
+			Stopped:        true,
+			StoppedAt:      now.Add(-2 * time.Hour),
+			TerminateCause: "User-Request",
+		},
+		{
+			Key:       SessionKey{SessionID: "stopped", NAS: "10.0.0.2"},
+			UserName:  "carol",
+			Stopped:   true,
+			StoppedAt: now.Add(-time.Minute),
+		},
+	}
+}
+
+func loadSessionIDs(t *testing.T, storage SessionStorage) []string {
+	t.Helper()
+
+	sessions, err := storage.Load()
+	if err != nil {
+		t.Fatalf("Load: %s", err)
+	}
+
+	var ids []string
+	for _, session := range sessions {
+		ids = append(ids, session.Key.SessionID)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// testSessionStorage runs Save, Load, Cleanup and Restore against storage.
+func testSessionStorage(t *testing.T, storage SessionStorage) {
+	now := time.Now().Truncate(time.Second)
+
+	for _, session := range testSessions(now) {
+		if err := storage.Save(session); err != nil {
+			t.Fatalf("Save %s: %s", session.Key.SessionID, err)
+		}
+	}
+
+	// a second save replaces the session
+	updated := testSessions(now)[0]
+	updated.InputOctets = 1000
+	if err := storage.Save(updated); err != nil {
+		t.Fatalf("Save: %s", err)
+	}
+
+	if ids, want := loadSessionIDs(t, storage), []string{"active", "expired", "stopped"}; !equalStrings(ids, want) {
+		t.Fatalf("Load = %v, want %v", ids, want)
+	}
+
+	sessions, err := storage.Load()
+	if err != nil {
+		t.Fatalf("Load: %s", err)
+	}
+	for _, session := range sessions {
+		if session.Key.SessionID == "active" && session.InputOctets != 1000 {
+			t.Errorf("InputOctets = %d, want 1000", session.InputOctets)
+		}
+		if session.Key.SessionID == "expired" && !session.StoppedAt.Equal(now.Add(-2*time.Hour)) {
+			t.Errorf("StoppedAt = %s, want %s", session.StoppedAt, now.Add(-2*time.Hour))
+		}
+	}
+
+	removed, err := storage.Cleanup(now.Add(-time.Hour))
+	if err != nil {
+		t.Fatalf("Cleanup: %s", err)
+	}
+	if removed != 1 {
+		t.Errorf("Cleanup removed %d sessions, want 1", removed)
+	}
+	if ids, want := loadSessionIDs(t, storage), []string{"active", "stopped"}; !equalStrings(ids, want) {
+		t.Fatalf("Load after Cleanup = %v, want %v", ids, want)
+	}
+
+	store := NewSessionStore(&SessionStoreConfig{Storage: storage})
+	if err := store.Restore(); err != nil {
+		t.Fatalf("Restore: %s", err)
+	}
+	all := store.All()
+	if len(all) != 1 || all[0].Key.SessionID != "active" || all[0].UserName != "alice" {
+		t.Fatalf("restored %+v, want the active session only", all)
+	}
+
+	if err := storage.Delete(updated.Key); err != nil {
+		t.Fatalf("Delete: %s", err)
+	}
+	if ids, want := loadSessionIDs(t, storage), []string{"stopped"}; !equalStrings(ids, want) {
+		t.Fatalf("Load after Delete = %v, want %v", ids, want)
+	}
+}
+
+func TestMemorySessionStorage(t *testing.T) {
+	testSessionStorage(t, NewMemorySessionStorage())
+}
+
+func TestFileSessionStorage(t *testing.T) {
+	storage, err := NewFileSessionStorage(filepath.Join(t.TempDir(), "sessions.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer storage.Close()
+
+	testSessionStorage(t, storage)
+}
+
+func TestSQLSessionStorage(t *testing.T) {
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "sessions.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	storage := NewSQLSessionStorage(db, "sessions")
+	if err := storage.CreateTable(context.Background()); err != nil {
+		t.Fatalf("CreateTable: %s", err)
+	}
+
+	testSessionStorage(t, storage)
+}
+
+func countLines(t *testing.T, path string) int {
+	t.Helper()
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var lines int
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		lines++
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return lines
+}
+
+func TestFileSessionStorageReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sessions.json")
+	now := time.Now().Truncate(time.Second)
+
+	storage, err := NewFileSessionStorage(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, session := range testSessions(now) {
+		if err := storage.Save(session); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := storage.Delete(SessionKey{SessionID: "stopped", NAS: "10.0.0.2"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// a torn write at the end of the journal is skipped
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"op":"save","key":{"session_id":"torn"`)
+	f.Close()
+
+	storage, err = NewFileSessionStorage(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer storage.Close()
+
+	if ids, want := loadSessionIDs(t, storage), []string{"active", "expired"}; !equalStrings(ids, want) {
+		t.Fatalf("replayed %v, want %v", ids, want)
+	}
+	// opening compacts the journal to a record per session
+	if lines := countLines(t, path); lines != 2 {
+		t.Errorf("journal has %d lines after open, want 2", lines)
+	}
+}
+
+func TestFileSessionStorageCompaction(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sessions.json")
+
+	storage, err := NewFileSessionStorage(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer storage.Close()
+
+	session := &Session{Key: SessionKey{SessionID: "interim", NAS: "10.0.0.1"}}
+	for i := 0; i <= fileSessionCompactMinRecords; i++ {
+		session.InputOctets = uint64(i)
+		if err := storage.Save(session); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if lines := countLines(t, path); lines > 1 {
+		t.Errorf("journal has %d lines after %d saves, want it compacted", lines, fileSessionCompactMinRecords+1)
+	}
+
+	sessions, err := storage.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 1 || sessions[0].InputOctets != fileSessionCompactMinRecords {
+		t.Fatalf("Load = %+v, want the last save", sessions)
+	}
+
+	// cleaning up rewrites the journal without the removed sessions
+	stopped := &Session{Key: SessionKey{SessionID: "stopped", NAS: "10.0.0.1"}, Stopped: true, StoppedAt: time.Now().Add(-time.Hour)}
+	if err := storage.Save(stopped); err != nil {
+		t.Fatal(err)
+	}
+	if removed, err := storage.Cleanup(time.Now()); err != nil || removed != 1 {
+		t.Fatalf("Cleanup = %d, %v, want 1 session removed", removed, err)
+	}
+	if lines := countLines(t, path); lines != 1 {
+		t.Errorf("journal has %d lines after Cleanup, want 1", lines)
+	}
+}
@@ -0,0 +1,217 @@
+package libradius
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"layeh.com/radius"
+	"layeh.com/radius/rfc2865"
+	"layeh.com/radius/rfc2866"
+	"layeh.com/radius/rfc2869"
+)
+
+var testNASAddr = &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 1813}
+
+func newAccountingRequest(statusType rfc2866.AcctStatusType, sessionID string) *radius.Packet {
+	p := radius.New(radius.CodeAccountingRequest, []byte("testing123"))
+	rfc2866.AcctStatusType_Set(p, statusType)
+	rfc2866.AcctSessionID_SetString(p, sessionID)
+	rfc2865.UserName_SetString(p, "alice")
+	return p
+}
+
+// blockingSessionStorage holds the save of the first active session until
+// release is closed.
+type blockingSessionStorage struct {
+	*MemorySessionStorage
+	blocked chan struct{}
+	release chan struct{}
+	once    sync.Once
+}
+
+func (b *blockingSessionStorage) Save(session *Session) error {
+	if !session.Stopped {
+		b.once.Do(func() {
+			close(b.blocked)
+			<-b.release
+		})
+	}
+	return b.MemorySessionStorage.Save(session)
+}
+
+func TestSessionStoreSaveOrder(t *testing.T) {
+	storage := &blockingSessionStorage{
+		MemorySessionStorage: NewMemorySessionStorage(),
+		blocked:              make(chan struct{}),
+		release:              make(chan struct{}),
+	}
+	store := NewSessionStore(&SessionStoreConfig{Storage: storage})
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		store.Ingest(newAccountingRequest(rfc2866.AcctStatusType_Value_InterimUpdate, "session"), testNASAddr)
+	}()
+
+	// the Stop is applied while the Interim-Update is being saved
+	<-storage.blocked
+	go func() {
+		defer wg.Done()
+		store.Ingest(newAccountingRequest(rfc2866.AcctStatusType_Value_Stop, "session"), testNASAddr)
+	}()
+	time.Sleep(20 * time.Millisecond)
+	close(storage.release)
+	wg.Wait()
+
+	restored := NewSessionStore(&SessionStoreConfig{Storage: storage})
+	if err := restored.Restore(); err != nil {
+		t.Fatal(err)
+	}
+	if sessions := restored.All(); len(sessions) != 0 {
+		t.Errorf("the stopped session was restored: %+v", sessions[0])
+	}
+	if len(store.saving) != 0 {
+		t.Errorf("%d session locks left", len(store.saving))
+	}
+}
+
+func TestSessionStoreIngest(t *testing.T) {
+	store := NewSessionStore(nil)
+
+	start := newAccountingRequest(rfc2866.AcctStatusType_Value_Start, "session")
+	rfc2865.NASIdentifier_SetString(start, "ap1")
+	rfc2865.FramedIPAddress_Set(start, net.IPv4(10, 0, 0, 2))
+	rfc2865.CallingStationID_SetString(start, "00-11-22-33-44-55")
+	if _, err := store.Ingest(start, testNASAddr); err != nil {
+		t.Fatal(err)
+	}
+
+	update := newAccountingRequest(rfc2866.AcctStatusType_Value_InterimUpdate, "session")
+	rfc2865.NASIdentifier_SetString(update, "ap1")
+	rfc2866.AcctInputOctets_Set(update, 1000)
+	rfc2866.AcctSessionTime_Set(update, 60)
+	rfc2869.AcctInterimInterval_Set(update, 300)
+	session, err := store.Ingest(update, testNASAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key := SessionKey{SessionID: "session", NAS: "ap1"}
+	if session.Key != key || session.UserName != "alice" || session.FramedIPAddress != "10.0.0.2" ||
+		session.CallingStationID != "00-11-22-33-44-55" || session.NASAddr != "10.0.0.1" {
+		t.Errorf("got %+v", session)
+	}
+	if session.InputOctets != 1000 || session.SessionTime != time.Minute || session.InterimInterval != 5*time.Minute {
+		t.Errorf("counters %d, %s, %s", session.InputOctets, session.SessionTime, session.InterimInterval)
+	}
+	if found := store.FindByFramedIP("10.0.0.2"); len(found) != 1 || found[0].Key != key {
+		t.Errorf("found %v by Framed-IP-Address", found)
+	}
+
+	// Accounting-On stops every session of the NAS
+	on := radius.New(radius.CodeAccountingRequest, []byte("testing123"))
+	rfc2866.AcctStatusType_Set(on, rfc2866.AcctStatusType_Value_AccountingOn)
+	rfc2865.NASIdentifier_SetString(on, "ap1")
+	if session, err := store.Ingest(on, testNASAddr); err != nil || session != nil {
+		t.Fatalf("Accounting-On: %v, %v", session, err)
+	}
+	if _, ok := store.Get(key); ok {
+		t.Error("the session outlived Accounting-On")
+	}
+
+	// a Stop returns the final state and forgets the session
+	if _, err := store.Ingest(start, testNASAddr); err != nil {
+		t.Fatal(err)
+	}
+	stop := newAccountingRequest(rfc2866.AcctStatusType_Value_Stop, "session")
+	rfc2865.NASIdentifier_SetString(stop, "ap1")
+	rfc2866.AcctTerminateCause_Set(stop, rfc2866.AcctTerminateCause_Value_UserRequest)
+	session, err = store.Ingest(stop, testNASAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !session.Stopped || session.StoppedAt.IsZero() || session.TerminateCause != "User-Request" {
+		t.Errorf("stopped as %+v", session)
+	}
+	if _, ok := store.Get(key); ok {
+		t.Error("the stopped session is still tracked")
+	}
+
+	for _, p := range []*radius.Packet{
+		newAccountingRequest(rfc2866.AcctStatusType_Value_InterimUpdate, ""),
+		newAccountingRequest(rfc2866.AcctStatusType_Value_Failed, "session"),
+	} {
+		if _, err := store.Ingest(p, testNASAddr); err == nil {
+			t.Errorf("ingested %s", rfc2866.AcctStatusType_Get(p))
+		}
+	}
+}
+
+func TestSessionStoreGigawords(t *testing.T) {
+	store := NewSessionStore(nil)
+	ingest := func(octets uint32, gigawords uint32) uint64 {
+		t.Helper()
+		p := newAccountingRequest(rfc2866.AcctStatusType_Value_InterimUpdate, "session")
+		rfc2866.AcctInputOctets_Set(p, rfc2866.AcctInputOctets(octets))
+		if gigawords > 0 {
+			rfc2869.AcctInputGigawords_Set(p, rfc2869.AcctInputGigawords(gigawords))
+		}
+		session, err := store.Ingest(p, testNASAddr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return session.InputOctets
+	}
+
+	if octets := ingest(0xfffffff0, 0); octets != 0xfffffff0 {
+		t.Errorf("got %d octets", octets)
+	}
+	// without gigawords the 32-bit counter going backwards is a wrap
+	if octets := ingest(0x10, 0); octets != 1<<32|0x10 {
+		t.Errorf("got %d octets after a wrap, want %d", octets, uint64(1<<32|0x10))
+	}
+	// reported gigawords are taken as they are
+	if octets := ingest(0x20, 3); octets != 3<<32|0x20 {
+		t.Errorf("got %d octets with gigawords, want %d", octets, uint64(3<<32|0x20))
+	}
+	if octets := ingest(0x30, 0); octets != 3<<32|0x30 {
+		t.Errorf("got %d octets after gigawords, want %d", octets, uint64(3<<32|0x30))
+	}
+}
+
+func TestSessionStoreStale(t *testing.T) {
+	store := NewSessionStore(&SessionStoreConfig{InterimInterval: time.Minute})
+
+	// the clock of the NAS is an hour behind
+	p := newAccountingRequest(rfc2866.AcctStatusType_Value_InterimUpdate, "session")
+	rfc2869.EventTimestamp_Set(p, time.Now().Add(-time.Hour))
+	if _, err := store.Ingest(p, testNASAddr); err != nil {
+		t.Fatal(err)
+	}
+	// another session reports a longer interval
+	other := newAccountingRequest(rfc2866.AcctStatusType_Value_InterimUpdate, "other")
+	rfc2869.AcctInterimInterval_Set(other, 600)
+	if _, err := store.Ingest(other, testNASAddr); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	if stale := store.Stale(now); len(stale) != 0 {
+		t.Errorf("%s is stale right after an update", stale[0].Key.SessionID)
+	}
+	// two missed interim updates are tolerated
+	if stale := store.Stale(now.Add(2*time.Minute - time.Second)); len(stale) != 0 {
+		t.Errorf("%s is stale within two intervals", stale[0].Key.SessionID)
+	}
+	stale := store.Stale(now.Add(3 * time.Minute))
+	if len(stale) != 1 || stale[0].Key.SessionID != "session" {
+		t.Errorf("got %v stale after three intervals, want the session with the default interval", stale)
+	}
+	// the interval of the session overrides the one of the store
+	if stale := store.Stale(now.Add(21 * time.Minute)); len(stale) != 2 {
+		t.Errorf("%d sessions stale after 21 minutes, want 2", len(stale))
+	}
+}
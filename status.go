@@ -0,0 +1,85 @@
+package libradius
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"layeh.com/radius"
+)
+
+// Ping sends a Status-Server (RFC 5997) and returns the round-trip time of
+// the first authentic answer.
+func Ping(ctx context.Context, addr, secret string) (time.Duration, error) {
+	packet, err := newStatusServer([]byte(secret))
+	if err != nil {
+		return 0, err
+	}
+
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, defaultSendRadiusPacketTimeout)
+		defer cancel()
+	}
+
+	start := time.Now()
+	response, err := exchange(ctx, radius.DefaultClient, packet, addr, nil)
+	if err != nil {
+		return 0, err
+	}
+	rtt := time.Since(start)
+
+	if HasMessageAuthenticator(response) && !VerifyMessageAuthenticator(response, packet.Authenticator[:]) {
+		return 0, fmt.Errorf("invalid Message-Authenticator in %s", response.Code)
+	}
+
+	switch response.Code {
+	case radius.CodeAccessAccept, radius.CodeAccountingResponse:
+		return rtt, nil
+	default:
+		return 0, fmt.Errorf("unexpected status response: %s (%d)", response.Code, response.Code)
+	}
+}
+
+// newStatusServer builds a Status-Server request, which must be signed with
+// a Message-Authenticator.
+func newStatusServer(secret []byte) (*radius.Packet, error) {
+	packet := radius.New(radius.CodeStatusServer, secret)
+	if err := AddMessageAuthenticator(packet); err != nil {
+		return nil, err
+	}
+	return packet, nil
+}
+
+// statusServerHandler answers Status-Server with Access-Accept, or with
+// Accounting-Response on accounting listeners, before h sees the request.
+func statusServerHandler(cfg *RadiusServerConfig, h radius.Handler) radius.Handler {
+	return radius.HandlerFunc(func(w radius.ResponseWriter, r *radius.Request) {
+		if r.Code != radius.CodeStatusServer {
+			h.ServeRADIUS(w, r)
+			return
+		}
+
+		// RFC 5997, section 3: unsigned Status-Server is silently discarded
+		if !VerifyMessageAuthenticator(r.Packet, nil) {
+			return
+		}
+
+		code := radius.CodeAccessAccept
+		if cfg.Accounting {
+			code = radius.CodeAccountingResponse
+		}
+
+		response := r.Response(code)
+		if cfg.StatusHook != nil {
+			cfg.StatusHook(r, response)
+		}
+
+		if err := AddMessageAuthenticator(response); err != nil {
+			log.Printf("unable to sign Status-Server response: %v", err)
+			return
+		}
+		w.Write(response)
+	})
+}
@@ -0,0 +1,437 @@
+package libradius
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/pion/dtls/v2"
+	"layeh.com/radius"
+)
+
+type Transport string
+
+const (
+	TransportUDP  Transport = "udp"
+	TransportTCP  Transport = "tcp"
+	TransportTLS  Transport = "tls"
+	TransportDTLS Transport = "dtls"
+)
+
+const (
+	// RFC 6614, section 2.3: the shared secret of RADIUS/TLS
+	radsecSecret = "radsec"
+
+	defaultStreamHandshakeTimeout = 10 * time.Second
+)
+
+var ErrServerClosed = errors.New("radius: server closed")
+
+// readPacketFrame reads one packet from a stream, RADIUS packets carry their
+// length in the header so no other framing is needed (RFC 6613, section 2.1).
+func readPacketFrame(r io.Reader) ([]byte, error) {
+	var header [4]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+
+	length := int(binary.BigEndian.Uint16(header[2:4]))
+	if length < 20 || length > radius.MaxPacketLength {
+		return nil, fmt.Errorf("invalid packet length: %d", length)
+	}
+
+	b := make([]byte, length)
+	copy(b, header[:])
+	if _, err := io.ReadFull(r, b[4:]); err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+type streamPeerKey struct{}
+
+// StreamPeer describes the client of a RADIUS/TLS or RADIUS/DTLS connection.
+type StreamPeer struct {
+	NAS          string
+	Certificates []*x509.Certificate
+}
+
+// PeerFromContext returns the NAS identified by its client certificate,
+// available in the context of requests received over TLS and DTLS.
+func PeerFromContext(ctx context.Context) (*StreamPeer, bool) {
+	peer, ok := ctx.Value(streamPeerKey{}).(*StreamPeer)
+	return peer, ok
+}
+
+// StreamServer serves RADIUS over TCP (RFC 6613), TLS (RFC 6614) when
+// TLSConfig is set or DTLS (RFC 7360) when DTLSConfig is set, with the same
+// handlers as the UDP server. DTLS peers get a connection each, so requests
+// are framed the same way.
+type StreamServer struct {
+	Addr         string
+	Handler      radius.Handler
+	SecretSource radius.SecretSource
+	// TLSConfig enables RADIUS/TLS. Client certificates identify the NAS
+	// instead of the shared secret, so they are always required and verified
+	// against ClientCAs, whatever ClientAuth is set to. So is DTLSConfig.
+	TLSConfig  *tls.Config
+	DTLSConfig *dtls.Config
+	// NASFromCertificate names the NAS of a client certificate, an error
+	// closes the connection. The subject common name is used by default.
+	NASFromCertificate func(cert *x509.Certificate) (string, error)
+	// IdleTimeout closes connections without requests, 0 keeps them open.
+	IdleTimeout time.Duration
+	ErrorLog    *log.Logger
+
+	mu       sync.Mutex
+	listener net.Listener
+	conns    map[net.Conn]struct{}
+	ctx      context.Context
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+}
+
+// NewStreamServer fails for TLS and DTLS without a TLSConfig, rather than
+// serving plain TCP with the public secret of RFC 6614.
+func NewStreamServer(cfg *RadiusServerConfig, f func(w radius.ResponseWriter, r *radius.Request)) (*StreamServer, error) {
+	switch cfg.Transport {
+	case TransportTLS, TransportDTLS:
+		if cfg.TLSConfig == nil {
+			return nil, fmt.Errorf("radius: transport %s requires a TLS configuration", cfg.Transport)
+		}
+	}
+
+	secret := cfg.Secret
+	if len(secret) == 0 {
+		switch cfg.Transport {
+		case TransportTLS:
+			secret = radsecSecret
+		case TransportDTLS:
+			secret = dtlsSecret
+		}
+	}
+
+	server := &StreamServer{
+		Addr:         cfg.GetAddr(),
+		Handler:      instrumentHandler(statusServerHandler(cfg, radius.HandlerFunc(f))),
+		SecretSource: radius.StaticSecretSource([]byte(secret)),
+		IdleTimeout:  cfg.IdleTimeout,
+	}
+
+	switch cfg.Transport {
+	case TransportTLS:
+		server.TLSConfig = cfg.TLSConfig
+	case TransportDTLS:
+		server.DTLSConfig = newDTLSConfig(cfg.TLSConfig)
+	}
+
+	return server, nil
+}
+
+func (s *StreamServer) logf(format string, args ...interface{}) {
+	if s.ErrorLog != nil {
+		s.ErrorLog.Printf(format, args...)
+	} else {
+		log.Printf(format, args...)
+	}
+}
+
+func (s *StreamServer) ListenAndServe() error {
+	l, err := s.listen()
+	if err != nil {
+		return err
+	}
+
+	return s.Serve(l)
+}
+
+func (s *StreamServer) listen() (net.Listener, error) {
+	if s.DTLSConfig != nil {
+		return listenDTLS(s.Addr)
+	}
+	return net.Listen("tcp", s.Addr)
+}
+
+// Serve accepts connections from l, which must return plain UDP connections
+// per peer when DTLSConfig is set.
+func (s *StreamServer) Serve(l net.Listener) error {
+	if err := s.start(l); err != nil {
+		return err
+	}
+
+	return s.serve()
+}
+
+func (s *StreamServer) start(l net.Listener) error {
+	// the certificate of the NAS stands in for the shared secret, which is
+	// public with TLS and DTLS
+	if s.TLSConfig != nil {
+		cfg := s.TLSConfig
+		if cfg.ClientAuth != tls.RequireAndVerifyClientCert {
+			cfg = cfg.Clone()
+			cfg.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+		l = tls.NewListener(l, cfg)
+	}
+	if s.DTLSConfig != nil && s.DTLSConfig.ClientAuth != dtls.RequireAndVerifyClientCert {
+		cfg := *s.DTLSConfig
+		cfg.ClientAuth = dtls.RequireAndVerifyClientCert
+		s.DTLSConfig = &cfg
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.listener != nil {
+		return errors.New("radius: server already started")
+	}
+	s.listener = l
+	s.conns = make(map[net.Conn]struct{})
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+
+	return nil
+}
+
+func (s *StreamServer) serve() error {
+	l := s.listener
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if s.ctx.Err() != nil {
+				return ErrServerClosed
+			}
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				continue
+			}
+			return err
+		}
+
+		s.mu.Lock()
+		s.conns[conn] = struct{}{}
+		s.mu.Unlock()
+
+		s.wg.Add(1)
+		go s.serveConn(conn)
+	}
+}
+
+// Shutdown stops accepting connections, closes the open ones and waits for
+// running handlers until ctx is done.
+func (s *StreamServer) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	if s.listener == nil {
+		s.mu.Unlock()
+		return nil
+	}
+	s.cancel()
+	s.listener.Close()
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *StreamServer) serveConn(conn net.Conn) {
+	var handlers sync.WaitGroup
+	defer s.wg.Done()
+	defer handlers.Wait()
+
+	raw := conn
+	defer func() {
+		conn.Close()
+		raw.Close()
+		s.mu.Lock()
+		delete(s.conns, raw)
+		s.mu.Unlock()
+	}()
+
+	ctx := s.ctx
+	switch {
+	case s.DTLSConfig != nil:
+		dtlsConn, peer, err := s.handshakeDTLS(conn)
+		if err != nil {
+			s.logf("radius: DTLS client %s rejected: %v", conn.RemoteAddr(), err)
+			return
+		}
+		conn = dtlsConn
+		ctx = context.WithValue(ctx, streamPeerKey{}, peer)
+	default:
+		if tlsConn, ok := conn.(*tls.Conn); ok {
+			peer, err := s.handshake(tlsConn)
+			if err != nil {
+				s.logf("radius: TLS client %s rejected: %v", conn.RemoteAddr(), err)
+				return
+			}
+			ctx = context.WithValue(ctx, streamPeerKey{}, peer)
+		}
+	}
+
+	secret, err := s.SecretSource.RADIUSSecret(ctx, conn.RemoteAddr())
+	if err != nil || len(secret) == 0 {
+		metrics().ServerUnknownClient(conn.RemoteAddr())
+		return
+	}
+
+	// requests retransmitted over DTLS are dropped while the first one is
+	// handled, as radius.PacketServer does for UDP
+	var inflightMu sync.Mutex
+	inflight := make(map[byte]bool)
+
+	w := &streamResponseWriter{conn: conn}
+	reader := bufio.NewReader(conn)
+	for {
+		if s.IdleTimeout > 0 {
+			conn.SetReadDeadline(time.Now().Add(s.IdleTimeout))
+		}
+
+		b, err := readPacketFrame(reader)
+		if err != nil {
+			// RFC 6613, section 2.6.4: a malformed packet closes the
+			// connection as the stream can not be resynchronized
+			return
+		}
+
+		if !radius.IsAuthenticRequest(b, secret) {
+			metrics().ServerBadAuthenticator(conn.RemoteAddr(), radius.Code(b[0]))
+			continue
+		}
+
+		packet, err := radius.Parse(b, secret)
+		if err != nil {
+			continue
+		}
+
+		inflightMu.Lock()
+		duplicate := inflight[packet.Identifier]
+		inflight[packet.Identifier] = true
+		inflightMu.Unlock()
+		if duplicate {
+			continue
+		}
+
+		request := (&radius.Request{
+			LocalAddr:  conn.LocalAddr(),
+			RemoteAddr: conn.RemoteAddr(),
+			Packet:     packet,
+		}).WithContext(ctx)
+
+		handlers.Add(1)
+		go func() {
+			defer handlers.Done()
+			s.Handler.ServeRADIUS(w, request)
+
+			inflightMu.Lock()
+			delete(inflight, request.Identifier)
+			inflightMu.Unlock()
+		}()
+	}
+}
+
+func (s *StreamServer) handshake(conn *tls.Conn) (*StreamPeer, error) {
+	conn.SetDeadline(time.Now().Add(defaultStreamHandshakeTimeout))
+	if err := conn.Handshake(); err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+
+	peer := &StreamPeer{
+		Certificates: conn.ConnectionState().PeerCertificates,
+	}
+	if err := s.identify(peer); err != nil {
+		return nil, err
+	}
+
+	return peer, nil
+}
+
+// identify names the NAS of the peer by its certificate.
+func (s *StreamServer) identify(peer *StreamPeer) error {
+	if len(peer.Certificates) == 0 {
+		return errors.New("radius: peer presented no client certificate")
+	}
+
+	if s.NASFromCertificate == nil {
+		peer.NAS = peer.Certificates[0].Subject.CommonName
+		return nil
+	}
+
+	nas, err := s.NASFromCertificate(peer.Certificates[0])
+	if err != nil {
+		return err
+	}
+	peer.NAS = nas
+
+	return nil
+}
+
+type streamResponseWriter struct {
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+func (w *streamResponseWriter) Write(p *radius.Packet) error {
+	b, err := p.Encode()
+	if err != nil {
+		return err
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	_, err = w.conn.Write(b)
+	return err
+}
+
+func ServerRunStream(cfg *RadiusServerConfig, handler func(w radius.ResponseWriter, r *radius.Request)) error {
+	server, err := NewStreamServer(cfg, handler)
+	if err != nil {
+		return err
+	}
+
+	return server.ListenAndServe()
+}
+
+func ServerRunStreamAsync(cfg *RadiusServerConfig, handler func(w radius.ResponseWriter, r *radius.Request)) (*StreamServer, error) {
+	server, err := NewStreamServer(cfg, handler)
+	if err != nil {
+		return nil, err
+	}
+
+	l, err := server.listen()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := server.start(l); err != nil {
+		l.Close()
+		return nil, err
+	}
+
+	go server.serve()
+
+	return server, nil
+}
@@ -0,0 +1,498 @@
+package libradius
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"layeh.com/radius"
+)
+
+const (
+	defaultClientMaxConns  = 1
+	defaultClientKeepAlive = 30 * time.Second
+)
+
+var (
+	ErrClientClosed       = errors.New("radius: client closed")
+	ErrTooManyOutstanding = errors.New("radius: too many outstanding requests")
+	errStreamWatchdog     = errors.New("radius: no answer to watchdog Status-Server")
+)
+
+// Client sends requests to one server over UDP, TCP, TLS or DTLS. The
+// connections of the last three stay open, are shared by concurrent requests
+// and are checked with Status-Server while idle or after a request timed out.
+// Requests over DTLS are retransmitted every Retry like over UDP.
+type Client struct {
+	addr   string
+	secret []byte
+	cfg    RadiusClientConfig
+	udp    *radius.Client
+
+	dialMu sync.Mutex
+
+	mu     sync.Mutex
+	conns  []*streamConn
+	next   int
+	closed bool
+}
+
+// NewClient creates a client of the server at addr. The secret defaults to
+// "radsec" for TLS and to "radius/dtls" for DTLS.
+func NewClient(addr, secret string, cfg *RadiusClientConfig) (*Client, error) {
+	c := &Client{
+		addr: addr,
+		cfg:  *cfg,
+	}
+
+	switch c.cfg.Transport {
+	case "", TransportUDP:
+		c.udp = NewRadiusClient(cfg)
+	case TransportTCP:
+	case TransportTLS:
+		if c.cfg.TLSConfig == nil {
+			return nil, fmt.Errorf("transport %s requires a TLS config", c.cfg.Transport)
+		}
+		if len(secret) == 0 {
+			secret = radsecSecret
+		}
+	case TransportDTLS:
+		if c.cfg.TLSConfig == nil {
+			return nil, fmt.Errorf("transport %s requires a TLS config", c.cfg.Transport)
+		}
+		if len(secret) == 0 {
+			secret = dtlsSecret
+		}
+	default:
+		return nil, fmt.Errorf("unsupported transport: %s", c.cfg.Transport)
+	}
+	c.secret = []byte(secret)
+
+	if c.cfg.MaxConns <= 0 {
+		c.cfg.MaxConns = defaultClientMaxConns
+	}
+	if c.cfg.KeepAlive <= 0 {
+		c.cfg.KeepAlive = defaultClientKeepAlive
+	}
+
+	return c, nil
+}
+
+// Exchange sends the packet and waits for its response. The packet may be
+// built with any secret, like with ServerPool.Send.
+func (c *Client) Exchange(ctx context.Context, packet *radius.Packet) (*radius.Packet, error) {
+	request, err := packetWithSecret(packet, c.secret)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, defaultSendRadiusPacketTimeout)
+		defer cancel()
+	}
+
+	if c.udp != nil {
+		return exchange(ctx, c.udp, request, c.addr, nil)
+	}
+
+	conn, err := c.conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := conn.exchange(ctx, request)
+	if err != nil && ctx.Err() == nil && conn.closedWith() != nil && !errors.Is(err, ErrClientClosed) {
+		// the request is sent again over a new connection when the server
+		// closed the previous one, e.g. after its idle timeout
+		if conn, err = c.conn(ctx); err != nil {
+			return nil, err
+		}
+		return conn.exchange(ctx, request)
+	}
+
+	return response, err
+}
+
+// SendCoA is SendCoA over the transport of the client.
+func (c *Client) SendCoA(ctx context.Context, request CoaRequest) error {
+	return c.sendDynamicAuth(ctx, newCoAPacket(radius.CodeCoARequest, string(c.secret), request))
+}
+
+// SendDisconnect is SendDisconnect over the transport of the client.
+func (c *Client) SendDisconnect(ctx context.Context, request CoaRequest) error {
+	return c.sendDynamicAuth(ctx, newCoAPacket(radius.CodeDisconnectRequest, string(c.secret), request))
+}
+
+func (c *Client) sendDynamicAuth(ctx context.Context, packet *radius.Packet) error {
+	response, err := c.Exchange(ctx, packet)
+	if err != nil {
+		return err
+	}
+
+	return checkCoAResponse(packet, response)
+}
+
+// Close closes the open connections, running exchanges fail.
+func (c *Client) Close() error {
+	c.mu.Lock()
+	c.closed = true
+	conns := c.conns
+	c.conns = nil
+	c.mu.Unlock()
+
+	for _, conn := range conns {
+		conn.close(ErrClientClosed)
+	}
+	return nil
+}
+
+func (c *Client) conn(ctx context.Context) (*streamConn, error) {
+	if conn, err := c.pick(); conn != nil || err != nil {
+		return conn, err
+	}
+
+	c.dialMu.Lock()
+	defer c.dialMu.Unlock()
+
+	if conn, err := c.pick(); conn != nil || err != nil {
+		return conn, err
+	}
+
+	netConn, err := c.dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	conn := newStreamConn(c, netConn)
+
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		conn.close(ErrClientClosed)
+		return nil, ErrClientClosed
+	}
+	c.conns = append(c.conns, conn)
+	c.mu.Unlock()
+
+	// the connection is registered before its goroutines run, so that one
+	// closed at once is removed again
+	conn.start()
+
+	return conn, nil
+}
+
+// pick returns an open connection in turn, or nil when another one may be
+// opened.
+func (c *Client) pick() (*streamConn, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return nil, ErrClientClosed
+	}
+	if len(c.conns) < c.cfg.MaxConns {
+		return nil, nil
+	}
+
+	conn := c.conns[c.next%len(c.conns)]
+	c.next++
+	return conn, nil
+}
+
+func (c *Client) dial(ctx context.Context) (net.Conn, error) {
+	switch c.cfg.Transport {
+	case TransportTCP:
+		var dialer net.Dialer
+		return dialer.DialContext(ctx, "tcp", c.addr)
+	case TransportDTLS:
+		return dialDTLS(ctx, c.addr, c.cfg.TLSConfig)
+	}
+
+	dialer := &tls.Dialer{Config: c.cfg.TLSConfig}
+	return dialer.DialContext(ctx, "tcp", c.addr)
+}
+
+func (c *Client) remove(conn *streamConn) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for i, open := range c.conns {
+		if open == conn {
+			c.conns = append(c.conns[:i:i], c.conns[i+1:]...)
+			return
+		}
+	}
+}
+
+type streamRequest struct {
+	wire     []byte
+	response chan *radius.Packet
+}
+
+// streamConn multiplexes requests over one connection by their Identifier.
+type streamConn struct {
+	client *Client
+	conn   net.Conn
+
+	writeMu sync.Mutex
+
+	mu       sync.Mutex
+	pending  map[byte]*streamRequest
+	nextID   byte
+	lastUsed time.Time
+	probing  bool
+	err      error
+	done     chan struct{}
+}
+
+func newStreamConn(client *Client, conn net.Conn) *streamConn {
+	return &streamConn{
+		client:   client,
+		conn:     conn,
+		pending:  make(map[byte]*streamRequest),
+		lastUsed: time.Now(),
+		done:     make(chan struct{}),
+	}
+}
+
+func (s *streamConn) start() {
+	go s.readLoop()
+	go s.keepAlive()
+}
+
+func (s *streamConn) close(err error) {
+	s.mu.Lock()
+	if s.err != nil {
+		s.mu.Unlock()
+		return
+	}
+	s.err = err
+	close(s.done)
+	s.mu.Unlock()
+
+	s.conn.Close()
+	s.client.remove(s)
+}
+
+func (s *streamConn) closedWith() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.err
+}
+
+func (s *streamConn) exchange(ctx context.Context, packet *radius.Packet) (*radius.Packet, error) {
+	request, id, err := s.register(packet)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		s.mu.Lock()
+		if s.pending[id] == request {
+			delete(s.pending, id)
+		}
+		s.mu.Unlock()
+	}()
+
+	addr := s.client.addr
+	metrics().ClientRequest(addr, packet.Code)
+	start := time.Now()
+
+	if err := s.write(ctx, request.wire); err != nil {
+		return nil, err
+	}
+
+	var retry <-chan time.Time
+	if s.client.cfg.Transport == TransportDTLS && s.client.cfg.Retry > 0 {
+		ticker := time.NewTicker(s.client.cfg.Retry)
+		defer ticker.Stop()
+		retry = ticker.C
+	}
+
+	for {
+		select {
+		case <-retry:
+			metrics().ClientRetransmit(addr, packet.Code)
+			if err := s.write(ctx, request.wire); err != nil {
+				return nil, err
+			}
+		case response := <-request.response:
+			metrics().ClientResponse(addr, packet.Code, response.Code, time.Since(start))
+			return response, nil
+		case <-s.done:
+			return nil, s.err
+		case <-ctx.Done():
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				metrics().ClientTimeout(addr, packet.Code)
+				if packet.Code != radius.CodeStatusServer {
+					go s.watchdog()
+				}
+			}
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func (s *streamConn) write(ctx context.Context, wire []byte) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		s.conn.SetWriteDeadline(deadline)
+	}
+	if _, err := s.conn.Write(wire); err != nil {
+		s.close(err)
+		return err
+	}
+
+	return nil
+}
+
+// register allocates a free Identifier to the packet, a signed packet is
+// signed again with it.
+func (s *streamConn) register(packet *radius.Packet) (*streamRequest, byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.err != nil {
+		return nil, 0, s.err
+	}
+
+	id, free := s.nextID, false
+	for i := 0; i < 256; i++ {
+		if _, used := s.pending[id]; !used {
+			free = true
+			break
+		}
+		id++
+	}
+	if !free {
+		return nil, 0, ErrTooManyOutstanding
+	}
+	s.nextID = id + 1
+
+	request := ClonePacket(packet)
+	request.Identifier = id
+	if HasMessageAuthenticator(request) {
+		if err := AddMessageAuthenticator(request); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	wire, err := request.Encode()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	pending := &streamRequest{
+		wire:     wire,
+		response: make(chan *radius.Packet, 1),
+	}
+	s.pending[id] = pending
+	s.lastUsed = time.Now()
+
+	return pending, id, nil
+}
+
+func (s *streamConn) readLoop() {
+	reader := bufio.NewReader(s.conn)
+	for {
+		b, err := readPacketFrame(reader)
+		if err != nil {
+			s.close(err)
+			return
+		}
+
+		s.mu.Lock()
+		request := s.pending[b[1]]
+		authentic := request != nil && radius.IsAuthenticResponse(b, request.wire, s.client.secret)
+		if authentic {
+			delete(s.pending, b[1])
+		}
+		s.mu.Unlock()
+
+		if !authentic {
+			metrics().ClientBadAuthenticator(s.client.addr, radius.Code(b[0]))
+			continue
+		}
+
+		response, err := radius.Parse(b, s.client.secret)
+		if err != nil {
+			continue
+		}
+		request.response <- response
+	}
+}
+
+// watchdog probes the connection after a request timed out: responses are
+// never lost on a stream, so a silent server is likely gone and the
+// connection is closed unless it answers a Status-Server (RFC 6613,
+// section 2.6).
+func (s *streamConn) watchdog() {
+	s.mu.Lock()
+	if s.probing || s.err != nil {
+		s.mu.Unlock()
+		return
+	}
+	s.probing = true
+	s.mu.Unlock()
+
+	err := s.ping()
+
+	s.mu.Lock()
+	s.probing = false
+	s.mu.Unlock()
+
+	if err != nil {
+		s.close(errStreamWatchdog)
+	}
+}
+
+// keepAlive sends Status-Server over an idle connection and closes it when
+// the server does not answer (RFC 6614, section 2.6).
+func (s *streamConn) keepAlive() {
+	ticker := time.NewTicker(s.client.cfg.KeepAlive)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+		}
+
+		s.mu.Lock()
+		idle := len(s.pending) == 0 && time.Since(s.lastUsed) >= s.client.cfg.KeepAlive
+		s.mu.Unlock()
+		if !idle {
+			continue
+		}
+
+		s.watchdog()
+	}
+}
+
+func (s *streamConn) ping() error {
+	packet, err := newStatusServer(s.client.secret)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSendRadiusPacketTimeout)
+	defer cancel()
+
+	response, err := s.exchange(ctx, packet)
+	if err != nil {
+		return err
+	}
+	if HasMessageAuthenticator(response) && !VerifyMessageAuthenticator(response, packet.Authenticator[:]) {
+		return fmt.Errorf("invalid Message-Authenticator in %s", response.Code)
+	}
+
+	return nil
+}
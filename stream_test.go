@@ -0,0 +1,275 @@
+package libradius
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"testing"
+	"time"
+
+	"layeh.com/radius"
+	"layeh.com/radius/rfc2865"
+)
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pool *x509.CertPool
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "libradius test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return &testCA{cert: cert, key: key, pool: pool}
+}
+
+func (ca *testCA) issue(t *testing.T, name string, usage x509.ExtKeyUsage) tls.Certificate {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+const streamTestPassword = "password12345678"
+
+// streamTest runs a stream server on the loopback interface, it accepts
+// Access-Requests with the NAS of the peer in Reply-Message.
+type streamTest struct {
+	ca         *testCA
+	serverCert tls.Certificate
+	clientCert tls.Certificate
+}
+
+func newStreamTest(t *testing.T) *streamTest {
+	ca := newTestCA(t)
+	return &streamTest{
+		ca:         ca,
+		serverCert: ca.issue(t, "radius.example.com", x509.ExtKeyUsageServerAuth),
+		clientCert: ca.issue(t, "nas.example.com", x509.ExtKeyUsageClientAuth),
+	}
+}
+
+func (s *streamTest) serve(t *testing.T, transport Transport, secret string) string {
+	t.Helper()
+
+	cfg := NewRadiusServerConfig("127.0.0.1", "0", secret)
+	cfg.Transport = transport
+	if transport != TransportTCP {
+		cfg.TLSConfig = &tls.Config{
+			Certificates: []tls.Certificate{s.serverCert},
+			ClientCAs:    s.ca.pool,
+		}
+	}
+
+	server, err := ServerRunStreamAsync(cfg, func(w radius.ResponseWriter, r *radius.Request) {
+		if rfc2865.UserPassword_GetString(r.Packet) != streamTestPassword {
+			w.Write(r.Response(radius.CodeAccessReject))
+			return
+		}
+
+		response := r.Response(radius.CodeAccessAccept)
+		nas := "none"
+		if peer, ok := PeerFromContext(r.Context()); ok {
+			nas = peer.NAS
+		}
+		rfc2865.ReplyMessage_SetString(response, nas)
+		w.Write(response)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { server.Shutdown(context.Background()) })
+
+	return server.listener.Addr().String()
+}
+
+func (s *streamTest) clientConfig(transport Transport, cert bool) *RadiusClientConfig {
+	cfg := NewRadiusClientConfig(0, 100*time.Millisecond)
+	cfg.Transport = transport
+	if transport != TransportTCP {
+		cfg.TLSConfig = &tls.Config{
+			ServerName: "radius.example.com",
+			RootCAs:    s.ca.pool,
+		}
+		if cert {
+			cfg.TLSConfig.Certificates = []tls.Certificate{s.clientCert}
+		}
+	}
+	return cfg
+}
+
+// exchangeStream sends an Access-Request built with another secret, the
+// client encrypts User-Password again with its own.
+func exchangeStream(t *testing.T, addr, secret string, cfg *RadiusClientConfig) (*radius.Packet, error) {
+	t.Helper()
+
+	client, err := NewClient(addr, secret, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	request := radius.New(radius.CodeAccessRequest, []byte("other"))
+	rfc2865.UserName_SetString(request, "bob")
+	rfc2865.UserPassword_SetString(request, streamTestPassword)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	return client.Exchange(ctx, request)
+}
+
+func TestStreamLoopback(t *testing.T) {
+	s := newStreamTest(t)
+
+	tests := []struct {
+		name      string
+		transport Transport
+		secret    string
+		cert      bool
+		nas       string
+	}{
+		{"TCP", TransportTCP, "testing123", false, "none"},
+		{"TLS", TransportTLS, "", true, "nas.example.com"},
+		{"TLS without client certificate", TransportTLS, "", false, ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			addr := s.serve(t, test.transport, test.secret)
+
+			response, err := exchangeStream(t, addr, test.secret, s.clientConfig(test.transport, test.cert))
+			if len(test.nas) == 0 {
+				if err == nil {
+					t.Fatalf("got %s from a server requiring a client certificate", response.Code)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if response.Code != radius.CodeAccessAccept {
+				t.Fatalf("got %s", response.Code)
+			}
+			if nas := rfc2865.ReplyMessage_GetString(response); nas != test.nas {
+				t.Errorf("NAS = %q, want %q", nas, test.nas)
+			}
+		})
+	}
+}
+
+func TestStreamSecretMismatch(t *testing.T) {
+	s := newStreamTest(t)
+	addr := s.serve(t, TransportTCP, "testing123")
+
+	// responses signed with another secret are not accepted
+	if response, err := exchangeStream(t, addr, "wrong", s.clientConfig(TransportTCP, false)); err == nil {
+		t.Fatalf("got %s with a wrong secret", response.Code)
+	}
+}
+
+func TestStreamServerWithoutTLSConfig(t *testing.T) {
+	for _, transport := range []Transport{TransportTLS, TransportDTLS} {
+		cfg := NewRadiusServerConfig("127.0.0.1", "0", "")
+		cfg.Transport = transport
+		if _, err := NewStreamServer(cfg, nil); err == nil {
+			t.Errorf("%s server without a TLS configuration", transport)
+		}
+	}
+}
+
+func TestServerRunAsyncStreamTransport(t *testing.T) {
+	cfg := NewRadiusServerConfig("127.0.0.1", "0", "testing123")
+	cfg.Transport = TransportTCP
+	if _, err := ServerRunAsync(cfg, nil); err == nil {
+		t.Error("ServerRunAsync accepted a TCP configuration")
+	}
+	if _, err := ServerRunAsyncWithMultipleSecrets(cfg, radius.StaticSecretSource(nil), nil); err == nil {
+		t.Error("ServerRunAsyncWithMultipleSecrets accepted a TCP configuration")
+	}
+}
+
+func TestClientDynamicAuthOverTCP(t *testing.T) {
+	var codes []radius.Code
+	dynauth := NewDynamicAuthServer(func(ctx context.Context, request *DynamicAuthRequest) error {
+		codes = append(codes, request.Code)
+		return nil
+	})
+
+	cfg := NewRadiusServerConfig("127.0.0.1", "0", "testing123")
+	cfg.Transport = TransportTCP
+	server, err := ServerRunStreamAsync(cfg, dynauth.ServeRADIUS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Shutdown(context.Background())
+
+	clientCfg := NewRadiusClientConfig(0, 0)
+	clientCfg.Transport = TransportTCP
+	client, err := NewClient(server.listener.Addr().String(), "testing123", clientCfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	request := CoaRequest{AcctSessionID: "session", SessionTimeout: 60}
+	if err := client.SendCoA(context.Background(), request); err != nil {
+		t.Fatalf("SendCoA: %s", err)
+	}
+	if err := client.SendDisconnect(context.Background(), request); err != nil {
+		t.Fatalf("SendDisconnect: %s", err)
+	}
+
+	if len(codes) != 2 || codes[0] != radius.CodeCoARequest || codes[1] != radius.CodeDisconnectRequest {
+		t.Errorf("handled %v", codes)
+	}
+}
@@ -1,6 +1,7 @@
 package libradius
 
 import (
+	"bytes"
 	"encoding/binary"
 	"fmt"
 
@@ -18,6 +19,8 @@ type AVP struct {
 type WimarkAVPs struct {
 	ClientStr  string
 	SessionInt int
+	WLANID     string
+	CPEID      string
 }
 
 type AirspaceAVPs struct {
@@ -105,6 +108,12 @@ func DecodeWimarkAVPairsStruct(p *radius.Packet) (WimarkAVPs, error) {
 		if AVPItem.TypeId == uint8(WimarkAVPTypeClientStr) {
 			WimarkAVPStruct.ClientStr = string(AVPItem.Value)
 		}
+		if AVPItem.TypeId == uint8(WimarkIdentifierWLANType) {
+			WimarkAVPStruct.WLANID = string(AVPItem.Value)
+		}
+		if AVPItem.TypeId == uint8(WimarkAuthCPEIDType) {
+			WimarkAVPStruct.CPEID = string(AVPItem.Value)
+		}
 	}
 
 	return WimarkAVPStruct, nil
@@ -192,3 +201,39 @@ func CreateHexVSA(value string, t AVPType, vendor uint32) (hex []byte) {
 	copy(hex[4:], attr)
 	return
 }
+
+// ClonePacket returns a copy of the packet whose attributes can be changed
+// without changing the packet.
+func ClonePacket(p *radius.Packet) *radius.Packet {
+	q := *p
+	q.Attributes = make(radius.Attributes, 0, len(p.Attributes))
+	for _, avp := range p.Attributes {
+		q.Attributes = append(q.Attributes, &radius.AVP{
+			Type:      avp.Type,
+			Attribute: append(radius.Attribute(nil), avp.Attribute...),
+		})
+	}
+	return &q
+}
+
+// packetWithSecret returns a copy of the packet for another secret, with the
+// hidden attributes encrypted again, or the packet itself for the same secret.
+func packetWithSecret(packet *radius.Packet, secret []byte) (*radius.Packet, error) {
+	if bytes.Equal(secret, packet.Secret) {
+		return packet, nil
+	}
+
+	request := ClonePacket(packet)
+	request.Secret = secret
+
+	if err := reencryptAttributes(request, packet.Secret, packet.Authenticator[:], secret, request.Authenticator[:]); err != nil {
+		return nil, err
+	}
+	if HasMessageAuthenticator(request) {
+		if err := AddMessageAuthenticator(request); err != nil {
+			return nil, err
+		}
+	}
+
+	return request, nil
+}